@@ -9,10 +9,13 @@ import (
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/netdata/sd/manager"
 	"github.com/netdata/sd/manager/config/provider/file"
 	"github.com/netdata/sd/manager/config/provider/kubernetes"
+	"github.com/netdata/sd/pkg/health"
+	"github.com/netdata/sd/pkg/k8s"
 	"github.com/netdata/sd/pkg/log"
 
 	"github.com/jessevdk/go-flags"
@@ -20,9 +23,14 @@ import (
 )
 
 type options struct {
-	ConfigFile string `long:"config-file" env:"NETDATA_SD_CONFIG_FILE" description:"Configuration file path"`
-	ConfigMap  string `long:"config-map" env:"NETDATA_SD_CONFIG_MAP" description:"Configuration ConfigMap (name:key)"`
-	Debug      bool   `short:"d" long:"debug" description:"Debug mode"`
+	ConfigFile      string `long:"config-file" env:"NETDATA_SD_CONFIG_FILE" description:"Configuration file path"`
+	ConfigMap       string `long:"config-map" env:"NETDATA_SD_CONFIG_MAP" description:"Configuration ConfigMap (name:key)"`
+	APIServer       string `long:"api-server" description:"Kubernetes API server URL (ConfigMap provider)"`
+	KubeConfig      string `long:"kubeconfig" description:"Kubeconfig file path (ConfigMap provider)"`
+	Context         string `long:"kube-context" description:"Kubeconfig context name (ConfigMap provider)"`
+	BearerTokenFile string `long:"bearer-token-file" description:"Kubernetes API bearer token file path (ConfigMap provider)"`
+	CAFile          string `long:"ca-file" description:"Kubernetes API server CA certificate file path (ConfigMap provider)"`
+	Debug           bool   `short:"d" long:"debug" description:"Debug mode"`
 }
 
 var logger = log.New("main")
@@ -56,6 +64,9 @@ func run(mgr *manager.Manager) {
 	wg.Add(1)
 	go func() { defer wg.Done(); mgr.Run(ctx) }()
 
+	wg.Add(1)
+	go func() { defer wg.Done(); logHealth(ctx, mgr) }()
+
 	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
@@ -65,6 +76,54 @@ func run(mgr *manager.Manager) {
 	wg.Wait()
 }
 
+const healthCheckInterval = 10 * time.Second
+
+// logHealth periodically checks the health of the config provider and the pipelines and logs the state changes.
+func logHealth(ctx context.Context, mgr *manager.Manager) {
+	states := make(map[string]health.State)
+	tk := time.NewTicker(healthCheckInterval)
+	defer tk.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tk.C:
+		}
+
+		h := mgr.Health()
+		seen := make(map[string]bool)
+		logStateChanges(states, seen, "config provider", h.Provider)
+		for source, statuses := range h.Pipelines {
+			logStateChanges(states, seen, source, statuses)
+		}
+		for key := range states {
+			if !seen[key] {
+				delete(states, key)
+			}
+		}
+	}
+}
+
+func logStateChanges(states map[string]health.State, seen map[string]bool, source string, statuses []health.Status) {
+	for i, st := range statuses {
+		key := fmt.Sprintf("%s/%d/%s", source, i, st.Name)
+		seen[key] = true
+
+		prev, ok := states[key]
+		states[key] = st.State
+		if prev == st.State || (!ok && st.State == health.StateStarting) {
+			continue
+		}
+
+		if st.State == health.StateFailed {
+			logger.Warn().Str("last_error", st.LastError).Msgf("'%s': %s is %s", source, st.Name, st.State)
+		} else {
+			logger.Info().Msgf("'%s': %s is %s", source, st.Name, st.State)
+		}
+	}
+}
+
 func parseCLI() options {
 	var opts options
 	parser := flags.NewParser(&opts, flags.Default)
@@ -101,6 +160,13 @@ func newConfigProvider(opts options) (manager.ConfigProvider, error) {
 		Namespace: os.Getenv("MY_POD_NAMESPACE"),
 		ConfigMap: parts[0],
 		Key:       parts[1],
+		Client: k8s.ClientConfig{
+			APIServer:       opts.APIServer,
+			KubeConfig:      opts.KubeConfig,
+			Context:         opts.Context,
+			BearerTokenFile: opts.BearerTokenFile,
+			CAFile:          opts.CAFile,
+		},
 	})
 	if err != nil {
 		return nil, err
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/netdata/sd/manager/config"
+	"github.com/netdata/sd/pkg/health"
 	"github.com/netdata/sd/pkg/k8s"
 	"github.com/netdata/sd/pkg/log"
 
@@ -25,6 +26,7 @@ type Config struct {
 	Namespace string
 	ConfigMap string
 	Key       string
+	Client    k8s.ClientConfig
 }
 
 func validateConfig(cfg Config) error {
@@ -42,10 +44,12 @@ type Provider struct {
 	cmap      string
 	cmapKey   string
 	client    kubernetes.Interface
-	inf       cache.SharedInformer
+	informers *k8s.Informers
+	inf       k8s.Informer
 	queue     *workqueue.Type
 	configCh  chan []config.Config
 	started   chan struct{}
+	health    *health.Reporter
 	log       zerolog.Logger
 }
 
@@ -61,7 +65,7 @@ func NewProvider(cfg Config) (*Provider, error) {
 }
 
 func initProvider(cfg Config) (*Provider, error) {
-	client, err := k8s.Clientset()
+	client, err := k8s.Clientset(cfg.Client)
 	if err != nil {
 		return nil, err
 	}
@@ -70,9 +74,11 @@ func initProvider(cfg Config) (*Provider, error) {
 		cmap:      cfg.ConfigMap,
 		cmapKey:   cfg.Key,
 		client:    client,
+		informers: k8s.SharedInformers,
 		configCh:  make(chan []config.Config),
 		started:   make(chan struct{}),
 		queue:     workqueue.NewNamed("cmap"),
+		health:    health.NewReporter("k8s config provider"),
 		log:       log.New("k8s config provider"),
 	}
 	return p, nil
@@ -86,18 +92,23 @@ func (p *Provider) Configs() chan []config.Config {
 	return p.configCh
 }
 
+func (p *Provider) Health() []health.Status {
+	return []health.Status{p.health.Status()}
+}
+
 func (p *Provider) Run(ctx context.Context) {
 	p.log.Info().Msg("instance is started")
 	defer p.log.Info().Msg("instance is stopped")
 	defer p.queue.ShutDown()
 
-	p.inf = p.setupInformer(ctx)
+	p.inf = p.setupInformer()
+	defer p.inf.Close()
 	go p.inf.Run(ctx.Done())
 
-	if !cache.WaitForCacheSync(ctx.Done(), p.inf.HasSynced) {
-		p.log.Error().Msg("failed to sync caches")
+	if !k8s.DefaultSyncBackoff.WaitForCacheSync(ctx, p.log, p.health, p.inf) {
 		return
 	}
+	p.health.Synced()
 
 	go p.run(ctx)
 	close(p.started)
@@ -107,17 +118,20 @@ func (p *Provider) Run(ctx context.Context) {
 
 const resyncPeriod = 10 * time.Minute
 
-func (p *Provider) setupInformer(ctx context.Context) cache.SharedInformer {
-	client := p.client.CoreV1().ConfigMaps(p.namespace)
-	clw := &cache.ListWatch{
-		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
-			return client.List(ctx, options)
-		},
-		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
-			return client.Watch(ctx, options)
-		},
-	}
-	inf := cache.NewSharedInformer(clw, &apiv1.ConfigMap{}, resyncPeriod)
+func (p *Provider) setupInformer() k8s.Informer {
+	key := k8s.InformerKey{Client: p.client, Resource: "configmaps", Namespace: p.namespace}
+	inf := p.informers.Get(key, func(ctx context.Context) cache.SharedIndexInformer {
+		client := p.client.CoreV1().ConfigMaps(p.namespace)
+		clw := &cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return client.List(ctx, options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return client.Watch(ctx, options)
+			},
+		}
+		return cache.NewSharedIndexInformer(clw, &apiv1.ConfigMap{}, resyncPeriod, cache.Indexers{})
+	})
 	inf.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc:    func(obj interface{}) { p.enqueue(obj) },
 		UpdateFunc: func(_, obj interface{}) { p.enqueue(obj) },
@@ -125,6 +139,7 @@ func (p *Provider) setupInformer(ctx context.Context) cache.SharedInformer {
 	})
 	return inf
 }
+
 func (p *Provider) enqueue(obj interface{}) {
 	cmap, err := toConfigMap(obj)
 	if err != nil || p.cmap != cmap.Name {
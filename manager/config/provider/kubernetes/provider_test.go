@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/netdata/sd/manager/config"
+	"github.com/netdata/sd/pkg/health"
 	"github.com/netdata/sd/pkg/k8s"
 
 	"github.com/stretchr/testify/assert"
@@ -223,9 +224,11 @@ func prepareProvider(cfg Config, objects ...runtime.Object) (*Provider, v1.Confi
 		cmap:      cfg.ConfigMap,
 		cmapKey:   cfg.Key,
 		client:    client,
+		informers: k8s.NewInformers(),
 		configCh:  make(chan []config.Config),
 		started:   make(chan struct{}),
 		queue:     workqueue.NewNamed("cmap"),
+		health:    health.NewReporter("k8s config provider"),
 	}
 	return provider, client.CoreV1().ConfigMaps(cfg.Namespace)
 }
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/netdata/sd/manager/config"
+	"github.com/netdata/sd/pkg/health"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -42,6 +43,7 @@ func (sim runSim) run(t *testing.T) {
 
 	synced := cache.WaitForCacheSync(ctx.Done(), sim.provider.inf.HasSynced)
 	require.Truef(t, synced, "provider '%s' failed to sync", *sim.provider)
+	assert.Equal(t, health.StateSynced, sim.provider.Health()[0].State)
 
 	if sim.runAfterSync != nil {
 		sim.runAfterSync(ctx)
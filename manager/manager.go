@@ -10,6 +10,7 @@ import (
 	"github.com/netdata/sd/pipeline/discovery"
 	"github.com/netdata/sd/pipeline/export"
 	"github.com/netdata/sd/pipeline/tag"
+	"github.com/netdata/sd/pkg/health"
 	"github.com/netdata/sd/pkg/log"
 
 	"github.com/rs/zerolog"
@@ -20,7 +21,8 @@ type (
 		prov      ConfigProvider
 		factory   factory
 		cache     map[string]uint64
-		pipelines map[string]func()
+		mu        sync.Mutex
+		pipelines map[string]*runningPipeline
 		log       zerolog.Logger
 	}
 	ConfigProvider interface {
@@ -30,6 +32,13 @@ type (
 	sdPipeline interface {
 		Run(ctx context.Context)
 	}
+	healthReporter interface {
+		Health() []health.Status
+	}
+	runningPipeline struct {
+		pipeline sdPipeline
+		stop     func()
+	}
 	factory interface {
 		create(cfg config.PipelineConfig) (sdPipeline, error)
 	}
@@ -43,11 +52,36 @@ func New(provider ConfigProvider) *Manager {
 		prov:      provider,
 		factory:   factoryFunc(newPipeline),
 		cache:     make(map[string]uint64),
-		pipelines: make(map[string]func()),
+		pipelines: make(map[string]*runningPipeline),
 		log:       log.New("pipeline manager"),
 	}
 }
 
+// Health is the health of the config provider and of the running pipelines (keyed by the config source).
+// Components that don't report their health are omitted.
+type Health struct {
+	Provider  []health.Status
+	Pipelines map[string][]health.Status
+}
+
+func (m *Manager) Health() Health {
+	var h Health
+	if v, ok := m.prov.(healthReporter); ok {
+		h.Provider = v.Health()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h.Pipelines = make(map[string][]health.Status)
+	for source, rp := range m.pipelines {
+		if v, ok := rp.pipeline.(healthReporter); ok {
+			h.Pipelines[source] = v.Health()
+		}
+	}
+	return h
+}
+
 func (m *Manager) Run(ctx context.Context) {
 	m.log.Info().Msg("instance is started")
 	defer m.log.Info().Msg("instance is stopped")
@@ -66,8 +100,13 @@ func (m *Manager) Run(ctx context.Context) {
 }
 
 func (m *Manager) cleanup() {
-	for _, stop := range m.pipelines {
-		stop()
+	m.mu.Lock()
+	pipelines := m.pipelines
+	m.pipelines = make(map[string]*runningPipeline)
+	m.mu.Unlock()
+
+	for _, rp := range pipelines {
+		rp.stop()
 	}
 }
 
@@ -107,17 +146,25 @@ func (m *Manager) process(ctx context.Context, cfg config.Config) {
 }
 
 func (m *Manager) handleRemoveConfig(cfg config.Config) {
-	if stop, ok := m.pipelines[cfg.Source]; ok {
+	m.mu.Lock()
+	rp, ok := m.pipelines[cfg.Source]
+	delete(m.pipelines, cfg.Source)
+	m.mu.Unlock()
+
+	if ok {
 		m.log.Info().Msgf("received an empty config, stopping the pipeline ('%s')", cfg.Source)
-		delete(m.pipelines, cfg.Source)
-		stop()
+		rp.stop()
 	}
 }
 
 func (m *Manager) handleNewConfig(ctx context.Context, cfg config.Config) {
+	m.mu.Lock()
+	old, running := m.pipelines[cfg.Source]
+	m.mu.Unlock()
+
 	p, err := m.factory.create(*cfg.Pipeline)
 	if err != nil {
-		if _, ok := m.pipelines[cfg.Source]; ok {
+		if running {
 			m.log.Warn().Err(err).Msgf("unable to create a pipeline, will keep using old config ('%s')",
 				cfg.Source)
 		} else {
@@ -126,9 +173,9 @@ func (m *Manager) handleNewConfig(ctx context.Context, cfg config.Config) {
 		return
 	}
 
-	if stop, ok := m.pipelines[cfg.Source]; ok {
+	if running {
 		m.log.Info().Msgf("received an updated config, restarting the pipeline ('%s')", cfg.Source)
-		stop()
+		old.stop()
 	} else {
 		m.log.Info().Msgf("received a new config, starting a new pipeline ('%s')", cfg.Source)
 	}
@@ -140,7 +187,9 @@ func (m *Manager) handleNewConfig(ctx context.Context, cfg config.Config) {
 	go func() { defer wg.Done(); p.Run(pipelineCtx) }()
 	stop := func() { cancel(); wg.Wait() }
 
-	m.pipelines[cfg.Source] = stop
+	m.mu.Lock()
+	m.pipelines[cfg.Source] = &runningPipeline{pipeline: p, stop: stop}
+	m.mu.Unlock()
 }
 
 func newPipeline(cfg config.PipelineConfig) (sdPipeline, error) {
@@ -1,11 +1,17 @@
 package manager
 
 import (
+	"context"
+	"reflect"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/netdata/sd/manager/config"
+	"github.com/netdata/sd/pkg/health"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNew(t *testing.T) {
@@ -117,6 +123,41 @@ func TestManager_Run(t *testing.T) {
 	}
 }
 
+func TestManager_Health(t *testing.T) {
+	provider := &mockHealthProvider{
+		mockProvider: mockProvider{
+			cfgs: []config.Config{prepareConfig("source1", "name1"), prepareConfig("source2", "name2")},
+			ch:   make(chan []config.Config),
+		},
+		statuses: []health.Status{{Name: "provider", State: health.StateSynced}},
+	}
+	mgr := New(provider)
+	mgr.factory = &mockFactory{}
+
+	assert.Equal(t, Health{Provider: provider.statuses, Pipelines: map[string][]health.Status{}}, mgr.Health())
+
+	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(context.Background())
+
+	wg.Add(1)
+	go func() { defer wg.Done(); mgr.Run(ctx) }()
+
+	expected := Health{
+		Provider: provider.statuses,
+		Pipelines: map[string][]health.Status{
+			"source1": {{Name: "name1", State: health.StateSynced}},
+			"source2": {{Name: "name2", State: health.StateSynced}},
+		},
+	}
+	require.Eventually(t, func() bool { return reflect.DeepEqual(expected, mgr.Health()) },
+		time.Second*2, time.Millisecond*10)
+
+	cancel()
+	wg.Wait()
+
+	assert.Empty(t, mgr.Health().Pipelines, "stopped pipelines must not report")
+}
+
 func prepareConfig(source, name string) config.Config {
 	return config.Config{Pipeline: &config.PipelineConfig{Name: name}, Source: source}
 }
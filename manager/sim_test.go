@@ -8,6 +8,8 @@ import (
 	"time"
 
 	"github.com/netdata/sd/manager/config"
+	"github.com/netdata/sd/pkg/health"
+
 	"github.com/stretchr/testify/assert"
 )
 
@@ -60,8 +62,16 @@ type (
 	mockFactory struct {
 		created []*mockPipeline
 	}
+	mockHealthProvider struct {
+		mockProvider
+		statuses []health.Status
+	}
 )
 
+func (m mockHealthProvider) Health() []health.Status {
+	return m.statuses
+}
+
 func (m mockProvider) Run(ctx context.Context) {
 	select {
 	case <-ctx.Done():
@@ -82,6 +92,10 @@ func (m *mockPipeline) Run(ctx context.Context) {
 	<-ctx.Done()
 }
 
+func (m *mockPipeline) Health() []health.Status {
+	return []health.Status{{Name: m.name, State: health.StateSynced}}
+}
+
 func (m *mockFactory) create(cfg config.PipelineConfig) (sdPipeline, error) {
 	lock.Lock()
 	defer lock.Unlock()
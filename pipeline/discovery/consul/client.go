@@ -0,0 +1,130 @@
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+type (
+	serviceEntry struct {
+		Node    catalogNode    `json:"Node"`
+		Service catalogService `json:"Service"`
+		Checks  []healthCheck  `json:"Checks"`
+	}
+	catalogNode struct {
+		Node       string            `json:"Node"`
+		Address    string            `json:"Address"`
+		Datacenter string            `json:"Datacenter"`
+		Meta       map[string]string `json:"Meta"`
+	}
+	catalogService struct {
+		ID      string            `json:"ID"`
+		Service string            `json:"Service"`
+		Tags    []string          `json:"Tags"`
+		Address string            `json:"Address"`
+		Port    int               `json:"Port"`
+		Meta    map[string]string `json:"Meta"`
+	}
+	healthCheck struct {
+		CheckID string `json:"CheckID"`
+		Status  string `json:"Status"`
+	}
+)
+
+// apiClient is a minimal Consul HTTP API client that supports blocking queries.
+type apiClient struct {
+	httpClient *http.Client
+	address    string
+	datacenter string
+	token      string
+}
+
+func newAPIClient(address, datacenter, token string) *apiClient {
+	return &apiClient{
+		httpClient: &http.Client{},
+		address:    address,
+		datacenter: datacenter,
+		token:      token,
+	}
+}
+
+// services returns the catalog services (name: tags) and the index to use in the next blocking query.
+func (c *apiClient) services(ctx context.Context, index uint64, wait time.Duration) (map[string][]string, uint64, error) {
+	var services map[string][]string
+	index, err := c.blockingQuery(ctx, "/v1/catalog/services", index, wait, &services)
+	if err != nil {
+		return nil, 0, err
+	}
+	return services, index, nil
+}
+
+// healthService returns the service instances along with their health checks
+// and the index to use in the next blocking query.
+func (c *apiClient) healthService(ctx context.Context, name string, index uint64, wait time.Duration) ([]serviceEntry, uint64, error) {
+	var entries []serviceEntry
+	index, err := c.blockingQuery(ctx, "/v1/health/service/"+url.PathEscape(name), index, wait, &entries)
+	if err != nil {
+		return nil, 0, err
+	}
+	return entries, index, nil
+}
+
+// queryTimeoutMargin is added to the blocking query wait time to get the request timeout,
+// so that a query on a half-open connection fails instead of blocking forever.
+var queryTimeoutMargin = time.Second * 15
+
+// queryTimeout returns the blocking query request timeout, Consul adds up to wait/16 of jitter to the wait time.
+func queryTimeout(wait time.Duration) time.Duration {
+	return wait + wait/16 + queryTimeoutMargin
+}
+
+func (c *apiClient) blockingQuery(ctx context.Context, path string, index uint64, wait time.Duration, v interface{}) (uint64, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout(wait))
+	defer cancel()
+
+	query := url.Values{}
+	if c.datacenter != "" {
+		query.Set("dc", c.datacenter)
+	}
+	if index > 0 {
+		query.Set("index", strconv.FormatUint(index, 10))
+		query.Set("wait", wait.String())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.address+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return 0, err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("'%s' returned HTTP status code %d", path, resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return 0, fmt.Errorf("decode '%s' response: %v", path, err)
+	}
+
+	newIndex, err := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("'%s' response has bad X-Consul-Index header: %v", path, err)
+	}
+	return newIndex, nil
+}
@@ -0,0 +1,363 @@
+package consul
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/netdata/sd/pipeline/model"
+	"github.com/netdata/sd/pkg/log"
+
+	"github.com/ilyam8/hashstructure"
+	"github.com/rs/zerolog"
+)
+
+const (
+	defaultAddress = "http://127.0.0.1:8500"
+
+	healthPassing  = "passing"
+	healthWarning  = "warning"
+	healthCritical = "critical"
+)
+
+type Config struct {
+	Tags        string   `yaml:"tags"`
+	Address     string   `yaml:"address"`
+	Datacenter  string   `yaml:"datacenter"`
+	Token       string   `yaml:"token"`
+	Services    []string `yaml:"services"`
+	ServiceTags []string `yaml:"service_tags"`
+}
+
+func validateConfig(cfg Config) error {
+	if cfg.Tags == "" {
+		return errors.New("no tags set")
+	}
+	return nil
+}
+
+type (
+	serviceGroup struct {
+		targets []model.Target
+		source  string
+	}
+	ServiceTarget struct {
+		model.Base `hash:"ignore"`
+		hash       uint64
+		tuid       string
+		Address    string
+
+		Datacenter     string
+		Node           string
+		NodeAddress    string
+		NodeMeta       map[string]interface{}
+		ServiceID      string
+		ServiceName    string
+		ServiceAddress string
+		ServicePort    string
+		ServiceTags    []string
+		ServiceMeta    map[string]interface{}
+		Health         string
+	}
+)
+
+func (st ServiceTarget) Hash() uint64 { return st.hash }
+func (st ServiceTarget) TUID() string { return st.tuid }
+
+func (sg serviceGroup) Source() string          { return sg.source }
+func (sg serviceGroup) Targets() []model.Target { return sg.targets }
+
+type Discovery struct {
+	tags        model.Tags
+	client      *apiClient
+	datacenter  string
+	services    map[string]bool
+	serviceTags []string
+	source      string
+
+	waitTime   time.Duration
+	retryDelay time.Duration
+
+	log zerolog.Logger
+}
+
+func NewDiscovery(cfg Config) (*Discovery, error) {
+	if err := validateConfig(cfg); err != nil {
+		return nil, fmt.Errorf("consul discovery config validation: %v", err)
+	}
+
+	tags, err := model.ParseTags(cfg.Tags)
+	if err != nil {
+		return nil, fmt.Errorf("consul discovery initialization: parse config->tags: %v", err)
+	}
+
+	address := cfg.Address
+	if address == "" {
+		address = defaultAddress
+	}
+
+	d := &Discovery{
+		tags:        tags,
+		client:      newAPIClient(address, cfg.Datacenter, cfg.Token),
+		datacenter:  cfg.Datacenter,
+		serviceTags: cfg.ServiceTags,
+		source:      discoverySource(address, cfg.Datacenter, cfg.Services, cfg.ServiceTags),
+		waitTime:    time.Minute * 5,
+		retryDelay:  time.Second * 5,
+		log:         log.New("consul discovery"),
+	}
+	if len(cfg.Services) > 0 {
+		d.services = make(map[string]bool)
+		for _, name := range cfg.Services {
+			d.services[name] = true
+		}
+	}
+	return d, nil
+}
+
+func (d *Discovery) String() string {
+	return "consul discovery"
+}
+
+func (d *Discovery) Discover(ctx context.Context, in chan<- []model.Group) {
+	d.log.Info().Msg("instance is started")
+	defer d.log.Info().Msg("instance is stopped")
+
+	watchers := make(map[string]*serviceWatcher)
+	defer func() {
+		for _, w := range watchers {
+			w.stop()
+		}
+	}()
+
+	var index uint64
+	for {
+		services, newIndex, err := d.client.services(ctx, index, d.waitTime)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			d.log.Warn().Err(err).Msg("failed to query catalog services")
+			if !sleep(ctx, d.retryDelay) {
+				return
+			}
+			continue
+		}
+		index = nextIndex(index, newIndex)
+
+		for name, tags := range services {
+			if _, ok := watchers[name]; ok || !d.serviceMatches(name, tags) {
+				continue
+			}
+			watchers[name] = d.startServiceWatcher(ctx, in, name)
+		}
+
+		for name, w := range watchers {
+			if tags, ok := services[name]; ok && d.serviceMatches(name, tags) {
+				continue
+			}
+			// the watcher must be stopped before sending the empty group, otherwise they race
+			w.stop()
+			delete(watchers, name)
+			send(ctx, in, &serviceGroup{source: d.serviceSource(name)})
+		}
+	}
+}
+
+type serviceWatcher struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func (w *serviceWatcher) stop() {
+	w.cancel()
+	<-w.done
+}
+
+func (d *Discovery) startServiceWatcher(ctx context.Context, in chan<- []model.Group, name string) *serviceWatcher {
+	ctx, cancel := context.WithCancel(ctx)
+	w := &serviceWatcher{cancel: cancel, done: make(chan struct{})}
+
+	go func() { defer close(w.done); d.watchService(ctx, in, name) }()
+
+	return w
+}
+
+func (d *Discovery) watchService(ctx context.Context, in chan<- []model.Group, name string) {
+	var index uint64
+	var lastHash uint64
+	var sent bool
+
+	for {
+		entries, newIndex, err := d.client.healthService(ctx, name, index, d.waitTime)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			d.log.Warn().Err(err).Msgf("failed to query service '%s' health", name)
+			if !sleep(ctx, d.retryDelay) {
+				return
+			}
+			continue
+		}
+		index = nextIndex(index, newIndex)
+
+		group := d.buildGroup(name, entries)
+
+		var hash uint64
+		for _, tgt := range group.Targets() {
+			hash = hash*31 + tgt.Hash()
+		}
+		if sent && hash == lastHash {
+			continue
+		}
+		lastHash, sent = hash, true
+
+		send(ctx, in, group)
+	}
+}
+
+func (d *Discovery) buildGroup(name string, entries []serviceEntry) model.Group {
+	group := &serviceGroup{source: d.serviceSource(name)}
+
+	for _, entry := range entries {
+		if !hasAllTags(entry.Service.Tags, d.serviceTags) {
+			continue
+		}
+
+		host := entry.Service.Address
+		if host == "" {
+			host = entry.Node.Address
+		}
+		port := strconv.Itoa(entry.Service.Port)
+
+		target := &ServiceTarget{
+			tuid:           entry.Node.Node + "_" + entry.Service.ID,
+			Address:        net.JoinHostPort(host, port),
+			Datacenter:     entry.Node.Datacenter,
+			Node:           entry.Node.Node,
+			NodeAddress:    entry.Node.Address,
+			NodeMeta:       toMapInterface(entry.Node.Meta),
+			ServiceID:      entry.Service.ID,
+			ServiceName:    entry.Service.Service,
+			ServiceAddress: entry.Service.Address,
+			ServicePort:    port,
+			ServiceTags:    entry.Service.Tags,
+			ServiceMeta:    toMapInterface(entry.Service.Meta),
+			Health:         aggregatedHealth(entry.Checks),
+		}
+
+		if d.finalize(target) {
+			group.targets = append(group.targets, target)
+		}
+	}
+	return group
+}
+
+func (d *Discovery) finalize(target *ServiceTarget) bool {
+	hash, err := hashstructure.Hash(target, nil)
+	if err != nil {
+		return false
+	}
+	target.hash = hash
+	target.Tags().Merge(d.tags)
+	return true
+}
+
+func (d *Discovery) serviceMatches(name string, tags []string) bool {
+	if d.services != nil && !d.services[name] {
+		return false
+	}
+	// catalog service tags are the union of all its instances tags
+	return hasAllTags(tags, d.serviceTags)
+}
+
+func (d *Discovery) serviceSource(name string) string {
+	return d.source + "/" + name
+}
+
+// discoverySource identifies the agent and the filters, so that entries
+// watching the same services don't share group sources.
+func discoverySource(address, dc string, services, serviceTags []string) string {
+	source := "consul/" + address
+	if dc != "" {
+		source += "/dc:" + dc
+	}
+	if len(services) > 0 {
+		source += "/services:" + strings.Join(services, ",")
+	}
+	if len(serviceTags) > 0 {
+		source += "/service_tags:" + strings.Join(serviceTags, ",")
+	}
+	return source
+}
+
+// aggregatedHealth returns the worst status of the checks.
+func aggregatedHealth(checks []healthCheck) string {
+	status := healthPassing
+	for _, check := range checks {
+		switch check.Status {
+		case healthCritical:
+			return healthCritical
+		case healthWarning:
+			status = healthWarning
+		}
+	}
+	return status
+}
+
+// nextIndex returns the index for the next blocking query, it resets the index
+// if it goes backwards or is not greater than zero as suggested by the Consul docs.
+func nextIndex(prev, next uint64) uint64 {
+	if next < prev || next == 0 {
+		return 0
+	}
+	return next
+}
+
+func hasAllTags(tags, want []string) bool {
+	for _, w := range want {
+		var found bool
+		for _, tag := range tags {
+			if tag == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func send(ctx context.Context, in chan<- []model.Group, group model.Group) {
+	select {
+	case <-ctx.Done():
+	case in <- []model.Group{group}:
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+func toMapInterface(src map[string]string) map[string]interface{} {
+	if src == nil {
+		return nil
+	}
+	m := make(map[string]interface{}, len(src))
+	for k, v := range src {
+		m[k] = v
+	}
+	return m
+}
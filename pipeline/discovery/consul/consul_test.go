@@ -0,0 +1,426 @@
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/netdata/sd/pipeline/model"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDiscovery(t *testing.T) {
+	tests := map[string]struct {
+		cfg     Config
+		wantErr bool
+	}{
+		"default address": {cfg: Config{Tags: "consul"}},
+		"full config": {cfg: Config{
+			Tags:        "consul",
+			Address:     "http://consul:8500",
+			Datacenter:  "dc1",
+			Token:       "secret",
+			Services:    []string{"web"},
+			ServiceTags: []string{"prod"},
+		}},
+		"no tags":  {wantErr: true, cfg: Config{}},
+		"bad tags": {wantErr: true, cfg: Config{Tags: "!"}},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			d, err := NewDiscovery(test.cfg)
+
+			if test.wantErr {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.NotNil(t, d)
+			}
+		})
+	}
+}
+
+func TestDiscovery_String(t *testing.T) {
+	var d Discovery
+	assert.NotEmpty(t, d.String())
+}
+
+func TestDiscovery_Discover(t *testing.T) {
+	tests := map[string]struct {
+		cfg            Config
+		afterStart     func(api *fakeAPI)
+		expectedGroups [][]model.Group
+	}{
+		"all services": {
+			cfg: Config{},
+			expectedGroups: [][]model.Group{
+				{prepareDBGroup(""), prepareWebGroup("", prepareWeb1Target(""), prepareWeb2Target(""))},
+			},
+		},
+		"datacenter and token": {
+			cfg: Config{Datacenter: "dc1", Token: "secret"},
+			expectedGroups: [][]model.Group{
+				{prepareDBGroup("dc1"), prepareWebGroup("dc1", prepareWeb1Target("dc1"), prepareWeb2Target("dc1"))},
+			},
+		},
+		"services filter": {
+			cfg: Config{Services: []string{"db"}},
+			expectedGroups: [][]model.Group{
+				{prepareDBGroup("")},
+			},
+		},
+		"service tags filter": {
+			cfg: Config{ServiceTags: []string{"prod"}},
+			expectedGroups: [][]model.Group{
+				{prepareWebGroup("", prepareWeb1Target(""))},
+			},
+		},
+		"health changed": {
+			cfg: Config{Services: []string{"web"}},
+			afterStart: func(api *fakeAPI) {
+				api.update(func(s *fakeState) {
+					s.entries["web"][1].Checks[0].Status = healthCritical
+				})
+			},
+			expectedGroups: [][]model.Group{
+				{prepareWebGroup("", prepareWeb1Target(""), prepareWeb2Target(""))},
+				{prepareWebGroup("", prepareWeb1Target(""), prepareWeb2CriticalTarget(""))},
+			},
+		},
+		"service deregistered": {
+			cfg: Config{},
+			afterStart: func(api *fakeAPI) {
+				api.update(func(s *fakeState) {
+					delete(s.services, "db")
+					delete(s.entries, "db")
+				})
+			},
+			expectedGroups: [][]model.Group{
+				{prepareDBGroup(""), prepareWebGroup("", prepareWeb1Target(""), prepareWeb2Target(""))},
+				{&serviceGroup{source: "db"}},
+			},
+		},
+		"service stopped matching tags": {
+			cfg: Config{ServiceTags: []string{"prod"}},
+			afterStart: func(api *fakeAPI) {
+				api.update(func(s *fakeState) {
+					s.services["web"] = []string{"http", "staging"}
+				})
+			},
+			expectedGroups: [][]model.Group{
+				{prepareWebGroup("", prepareWeb1Target(""))},
+				{&serviceGroup{source: "web"}},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			api := newFakeAPI(test.cfg.Datacenter, test.cfg.Token)
+			defer api.srv.Close()
+
+			cfg := test.cfg
+			cfg.Tags = "consul"
+			cfg.Address = api.srv.URL
+			d, err := NewDiscovery(cfg)
+			require.NoError(t, err)
+			d.waitTime = time.Second * 5
+			d.retryDelay = time.Millisecond * 100
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+			defer cancel()
+
+			in := make(chan []model.Group)
+			go d.Discover(ctx, in)
+
+			for i, expected := range test.expectedGroups {
+				groups := collectGroups(ctx, t, in, len(expected))
+				assert.Equalf(t, withSources(d, expected), groups, "groups #%d", i)
+
+				if i == 0 && test.afterStart != nil {
+					test.afterStart(api)
+				}
+			}
+		})
+	}
+}
+
+func TestDiscovery_Discover_OverlappingServices(t *testing.T) {
+	api := newFakeAPI("", "")
+	defer api.srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	var discoverers []*Discovery
+	var groups [][]model.Group
+	for _, services := range [][]string{{"db", "web"}, {"web"}} {
+		d, err := NewDiscovery(Config{Tags: "consul", Address: api.srv.URL, Services: services})
+		require.NoError(t, err)
+		d.waitTime = time.Second * 5
+		d.retryDelay = time.Millisecond * 100
+
+		in := make(chan []model.Group)
+		go d.Discover(ctx, in)
+
+		discoverers = append(discoverers, d)
+		groups = append(groups, collectGroups(ctx, t, in, len(services)))
+	}
+
+	web := prepareWebGroup("", prepareWeb1Target(""), prepareWeb2Target(""))
+	assert.Equal(t, withSources(discoverers[0], []model.Group{prepareDBGroup(""), web}), groups[0])
+	web = prepareWebGroup("", prepareWeb1Target(""), prepareWeb2Target(""))
+	assert.Equal(t, withSources(discoverers[1], []model.Group{web}), groups[1])
+	assert.NotEqual(t, groups[0][1].Source(), groups[1][0].Source())
+}
+
+func TestAggregatedHealth(t *testing.T) {
+	tests := map[string]struct {
+		checks   []healthCheck
+		expected string
+	}{
+		"no checks": {expected: healthPassing},
+		"passing":   {checks: []healthCheck{{Status: "passing"}, {Status: "passing"}}, expected: healthPassing},
+		"warning":   {checks: []healthCheck{{Status: "passing"}, {Status: "warning"}}, expected: healthWarning},
+		"critical":  {checks: []healthCheck{{Status: "critical"}, {Status: "warning"}}, expected: healthCritical},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.expected, aggregatedHealth(test.checks))
+		})
+	}
+}
+
+func TestAPIClient_BlockingQueryTimeout(t *testing.T) {
+	margin := queryTimeoutMargin
+	queryTimeoutMargin = time.Millisecond * 100
+	defer func() { queryTimeoutMargin = margin }()
+
+	// the server never responds, like a peer on a half-open connection
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-done }))
+	defer srv.Close()
+	defer close(done)
+
+	client := newAPIClient(srv.URL, "", "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	_, _, err := client.services(ctx, 1, time.Millisecond*100)
+	require.Error(t, err)
+	assert.NoError(t, ctx.Err())
+}
+
+func collectGroups(ctx context.Context, t *testing.T, in chan []model.Group, num int) []model.Group {
+	var groups []model.Group
+	for len(groups) < num {
+		select {
+		case v := <-in:
+			groups = append(groups, v...)
+		case <-ctx.Done():
+			t.Fatalf("timeout waiting for %d groups, got %d", num, len(groups))
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Source() < groups[j].Source() })
+	return groups
+}
+
+func prepareDBGroup(dc string) model.Group {
+	target := &ServiceTarget{
+		tuid:        "node2_db-1",
+		Address:     "10.0.0.2:5432",
+		Datacenter:  dc,
+		Node:        "node2",
+		NodeAddress: "10.0.0.2",
+		ServiceID:   "db-1",
+		ServiceName: "db",
+		ServicePort: "5432",
+		ServiceTags: []string{"primary"},
+		Health:      healthPassing,
+	}
+	return &serviceGroup{source: "db", targets: []model.Target{withHashAndTags(target)}}
+}
+
+func prepareWebGroup(dc string, targets ...model.Target) model.Group {
+	return &serviceGroup{source: "web", targets: targets}
+}
+
+func prepareWeb1Target(dc string) model.Target {
+	return withHashAndTags(&ServiceTarget{
+		tuid:           "node1_web-1",
+		Address:        "172.16.0.1:8080",
+		Datacenter:     dc,
+		Node:           "node1",
+		NodeAddress:    "10.0.0.1",
+		NodeMeta:       map[string]interface{}{"rack": "r1"},
+		ServiceID:      "web-1",
+		ServiceName:    "web",
+		ServiceAddress: "172.16.0.1",
+		ServicePort:    "8080",
+		ServiceTags:    []string{"prod", "http"},
+		ServiceMeta:    map[string]interface{}{"version": "1.2"},
+		Health:         healthWarning,
+	})
+}
+
+func prepareWeb2Target(dc string) model.Target {
+	return withHashAndTags(prepareRawWeb2Target(dc, healthPassing))
+}
+
+func prepareWeb2CriticalTarget(dc string) model.Target {
+	return withHashAndTags(prepareRawWeb2Target(dc, healthCritical))
+}
+
+func prepareRawWeb2Target(dc, health string) *ServiceTarget {
+	return &ServiceTarget{
+		tuid:        "node2_web-2",
+		Address:     "10.0.0.2:8080",
+		Datacenter:  dc,
+		Node:        "node2",
+		NodeAddress: "10.0.0.2",
+		ServiceID:   "web-2",
+		ServiceName: "web",
+		ServicePort: "8080",
+		ServiceTags: []string{"staging"},
+		Health:      health,
+	}
+}
+
+func withHashAndTags(target *ServiceTarget) *ServiceTarget {
+	d := Discovery{tags: model.Tags{"consul": {}}}
+	d.finalize(target)
+	return target
+}
+
+// withSources replaces the service names set as expected group sources with the discovery sources.
+func withSources(d *Discovery, groups []model.Group) []model.Group {
+	for _, group := range groups {
+		g := group.(*serviceGroup)
+		g.source = d.serviceSource(g.source)
+	}
+	return groups
+}
+
+type (
+	fakeAPI struct {
+		mu      sync.Mutex
+		state   *fakeState
+		index   uint64
+		changed chan struct{}
+		dc      string
+		token   string
+		srv     *httptest.Server
+	}
+	fakeState struct {
+		services map[string][]string
+		entries  map[string][]serviceEntry
+	}
+)
+
+func newFakeAPI(dc, token string) *fakeAPI {
+	node1 := catalogNode{Node: "node1", Address: "10.0.0.1", Datacenter: dc, Meta: map[string]string{"rack": "r1"}}
+	node2 := catalogNode{Node: "node2", Address: "10.0.0.2", Datacenter: dc}
+
+	api := &fakeAPI{
+		state: &fakeState{
+			services: map[string][]string{
+				"web": {"prod", "http", "staging"},
+				"db":  {"primary"},
+			},
+			entries: map[string][]serviceEntry{
+				"web": {
+					{
+						Node: node1,
+						Service: catalogService{
+							ID: "web-1", Service: "web", Tags: []string{"prod", "http"},
+							Address: "172.16.0.1", Port: 8080, Meta: map[string]string{"version": "1.2"},
+						},
+						Checks: []healthCheck{{CheckID: "serfHealth", Status: "passing"}, {CheckID: "http", Status: "warning"}},
+					},
+					{
+						Node:    node2,
+						Service: catalogService{ID: "web-2", Service: "web", Tags: []string{"staging"}, Port: 8080},
+						Checks:  []healthCheck{{CheckID: "serfHealth", Status: "passing"}},
+					},
+				},
+				"db": {
+					{
+						Node:    node2,
+						Service: catalogService{ID: "db-1", Service: "db", Tags: []string{"primary"}, Port: 5432},
+						Checks:  []healthCheck{{CheckID: "serfHealth", Status: "passing"}},
+					},
+				},
+			},
+		},
+		index:   10,
+		changed: make(chan struct{}),
+		dc:      dc,
+		token:   token,
+	}
+	api.srv = httptest.NewServer(api)
+	return api
+}
+
+func (a *fakeAPI) update(fn func(s *fakeState)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	fn(a.state)
+	a.index++
+	close(a.changed)
+	a.changed = make(chan struct{})
+}
+
+func (a *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Consul-Token") != a.token {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if r.URL.Query().Get("dc") != a.dc {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// blocking query: wait until the index changes or the wait time elapses
+	index, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+	a.mu.Lock()
+	if index >= a.index {
+		changed := a.changed
+		a.mu.Unlock()
+		wait, _ := time.ParseDuration(r.URL.Query().Get("wait"))
+		select {
+		case <-changed:
+		case <-time.After(wait):
+		case <-r.Context().Done():
+			return
+		}
+		a.mu.Lock()
+	}
+	defer a.mu.Unlock()
+
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(a.index, 10))
+
+	switch {
+	case r.URL.Path == "/v1/catalog/services":
+		_ = json.NewEncoder(w).Encode(a.state.services)
+	case strings.HasPrefix(r.URL.Path, "/v1/health/service/"):
+		entries := a.state.entries[strings.TrimPrefix(r.URL.Path, "/v1/health/service/")]
+		if entries == nil {
+			entries = []serviceEntry{}
+		}
+		_ = json.NewEncoder(w).Encode(entries)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
@@ -0,0 +1,307 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/netdata/sd/pipeline/model"
+	"github.com/netdata/sd/pkg/log"
+
+	"github.com/ilyam8/hashstructure"
+	"github.com/rs/zerolog"
+)
+
+const (
+	TypeSRV  = "SRV"
+	TypeA    = "A"
+	TypeAAAA = "AAAA"
+)
+
+const (
+	defaultInterval = time.Second * 30
+	lookupTimeout   = time.Second * 10
+)
+
+type Config struct {
+	Tags     string        `yaml:"tags"`
+	Names    []string      `yaml:"names"`
+	Type     string        `yaml:"type"`
+	Port     int           `yaml:"port"`
+	Resolver string        `yaml:"resolver"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+func validateConfig(cfg Config) error {
+	if cfg.Tags == "" {
+		return errors.New("no tags set")
+	}
+	if len(cfg.Names) == 0 {
+		return errors.New("no names set")
+	}
+	switch strings.ToUpper(cfg.Type) {
+	case "", TypeSRV:
+	case TypeA, TypeAAAA:
+		if cfg.Port <= 0 || cfg.Port > 65535 {
+			return fmt.Errorf("'%s' type requires a valid port", cfg.Type)
+		}
+	default:
+		return fmt.Errorf("invalid type '%s', valid types: '%s', '%s', '%s'", cfg.Type, TypeSRV, TypeA, TypeAAAA)
+	}
+	if cfg.Resolver != "" {
+		if _, _, err := net.SplitHostPort(cfg.Resolver); err != nil {
+			return fmt.Errorf("invalid resolver address '%s': %v", cfg.Resolver, err)
+		}
+	}
+	if cfg.Interval < 0 {
+		return errors.New("negative interval")
+	}
+	return nil
+}
+
+type (
+	dnsGroup struct {
+		targets []model.Target
+		source  string
+	}
+	RecordTarget struct {
+		model.Base `hash:"ignore"`
+		hash       uint64
+		tuid       string
+		Address    string
+
+		Name     string
+		Type     string
+		Host     string
+		Port     string
+		Priority string
+		Weight   string
+	}
+)
+
+func (rt RecordTarget) Hash() uint64 { return rt.hash }
+func (rt RecordTarget) TUID() string { return rt.tuid }
+
+func (dg dnsGroup) Source() string          { return dg.source }
+func (dg dnsGroup) Targets() []model.Target { return dg.targets }
+
+type (
+	Discovery struct {
+		tags     model.Tags
+		names    []string
+		qtype    string
+		port     int
+		resolver *net.Resolver
+		source   string
+		interval time.Duration
+		cache    cache
+		log      zerolog.Logger
+	}
+	cache map[string]uint64 // name:group hash
+)
+
+func NewDiscovery(cfg Config) (*Discovery, error) {
+	if err := validateConfig(cfg); err != nil {
+		return nil, fmt.Errorf("dns discovery config validation: %v", err)
+	}
+
+	tags, err := model.ParseTags(cfg.Tags)
+	if err != nil {
+		return nil, fmt.Errorf("dns discovery initialization: parse config->tags: %v", err)
+	}
+
+	d := &Discovery{
+		tags:     tags,
+		names:    cfg.Names,
+		qtype:    strings.ToUpper(cfg.Type),
+		port:     cfg.Port,
+		resolver: newResolver(cfg.Resolver),
+		interval: cfg.Interval,
+		cache:    make(cache),
+		log:      log.New("dns discovery"),
+	}
+	if d.qtype == "" {
+		d.qtype = TypeSRV
+	}
+	d.source = discoverySource(d.qtype, d.port, cfg.Resolver)
+	if d.interval == 0 {
+		d.interval = defaultInterval
+	}
+	return d, nil
+}
+
+// newResolver returns a resolver that sends all queries to the address, or the default resolver if the address is empty.
+func newResolver(address string) *net.Resolver {
+	if address == "" {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, address)
+		},
+	}
+}
+
+func (d *Discovery) String() string {
+	return fmt.Sprintf("dns %s discovery: %v", d.qtype, d.names)
+}
+
+func (d *Discovery) Discover(ctx context.Context, in chan<- []model.Group) {
+	d.log.Info().Msg("instance is started")
+	defer d.log.Info().Msg("instance is stopped")
+
+	tk := time.NewTicker(d.interval)
+	defer tk.Stop()
+
+	for {
+		d.refresh(ctx, in)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-tk.C:
+		}
+	}
+}
+
+func (d *Discovery) refresh(ctx context.Context, in chan<- []model.Group) {
+	var groups []model.Group
+
+	for _, name := range d.names {
+		group, err := d.lookup(ctx, name)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			// keep the previous result on temporary errors, the records may still be there
+			d.log.Warn().Err(err).Msgf("failed to lookup %s '%s'", d.qtype, name)
+			continue
+		}
+
+		var hash uint64
+		for _, tgt := range group.Targets() {
+			hash = hash*31 + tgt.Hash()
+		}
+		if v, ok := d.cache[name]; ok && v == hash {
+			continue
+		}
+		d.cache[name] = hash
+		groups = append(groups, group)
+	}
+
+	if len(groups) == 0 {
+		return
+	}
+	select {
+	case <-ctx.Done():
+	case in <- groups:
+	}
+}
+
+func (d *Discovery) lookup(ctx context.Context, name string) (model.Group, error) {
+	ctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+
+	group := &dnsGroup{source: d.dnsSource(name)}
+
+	var targets []*RecordTarget
+	switch d.qtype {
+	case TypeSRV:
+		_, records, err := d.resolver.LookupSRV(ctx, "", "", name)
+		if err != nil && !isNotFound(err) {
+			return nil, err
+		}
+		for _, r := range records {
+			targets = append(targets, &RecordTarget{
+				Host:     strings.TrimSuffix(r.Target, "."),
+				Port:     strconv.Itoa(int(r.Port)),
+				Priority: strconv.Itoa(int(r.Priority)),
+				Weight:   strconv.Itoa(int(r.Weight)),
+			})
+		}
+		// the resolver shuffles records of the same priority by weight
+		sort.Slice(targets, func(i, j int) bool {
+			if targets[i].Priority != targets[j].Priority {
+				return lessNum(targets[i].Priority, targets[j].Priority)
+			}
+			if targets[i].Host != targets[j].Host {
+				return targets[i].Host < targets[j].Host
+			}
+			return lessNum(targets[i].Port, targets[j].Port)
+		})
+	case TypeA, TypeAAAA:
+		network := "ip4"
+		if d.qtype == TypeAAAA {
+			network = "ip6"
+		}
+		ips, err := d.resolver.LookupIP(ctx, network, name)
+		if err != nil && !isNotFound(err) {
+			return nil, err
+		}
+		sort.Slice(ips, func(i, j int) bool { return ips[i].String() < ips[j].String() })
+		for _, ip := range ips {
+			targets = append(targets, &RecordTarget{
+				Host: ip.String(),
+				Port: strconv.Itoa(d.port),
+			})
+		}
+	}
+
+	for _, target := range targets {
+		target.tuid = fmt.Sprintf("%s_%s_%s", name, target.Host, target.Port)
+		target.Address = net.JoinHostPort(target.Host, target.Port)
+		target.Name = name
+		target.Type = d.qtype
+
+		if d.finalize(target) {
+			group.targets = append(group.targets, target)
+		}
+	}
+	return group, nil
+}
+
+func (d *Discovery) finalize(target *RecordTarget) bool {
+	hash, err := hashstructure.Hash(target, nil)
+	if err != nil {
+		return false
+	}
+	target.hash = hash
+	target.Tags().Merge(d.tags)
+	return true
+}
+
+// isNotFound reports whether the name doesn't exist or has no records of the type, the group becomes empty in that case.
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+func (d *Discovery) dnsSource(name string) string {
+	return d.source + "/" + name
+}
+
+// discoverySource identifies the query type, the port and the resolver, so that entries
+// resolving the same names don't share group sources.
+func discoverySource(qtype string, port int, resolver string) string {
+	source := "dns/" + strings.ToLower(qtype)
+	if qtype != TypeSRV {
+		source += "/port:" + strconv.Itoa(port)
+	}
+	if resolver != "" {
+		source += "/resolver:" + resolver
+	}
+	return source
+}
+
+func lessNum(a, b string) bool {
+	x, _ := strconv.Atoi(a)
+	y, _ := strconv.Atoi(b)
+	return x < y
+}
@@ -0,0 +1,387 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/netdata/sd/pipeline/model"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestNewDiscovery(t *testing.T) {
+	tests := map[string]struct {
+		cfg     Config
+		wantErr bool
+	}{
+		"srv (default type)": {cfg: Config{Tags: "dns", Names: []string{"_http._tcp.example.com"}}},
+		"a with port":        {cfg: Config{Tags: "dns", Names: []string{"example.com"}, Type: "a", Port: 80}},
+		"aaaa with port":     {cfg: Config{Tags: "dns", Names: []string{"example.com"}, Type: "AAAA", Port: 80}},
+		"custom resolver":    {cfg: Config{Tags: "dns", Names: []string{"example.com"}, Resolver: "127.0.0.1:53"}},
+		"no tags":            {wantErr: true, cfg: Config{Names: []string{"example.com"}}},
+		"bad tags":           {wantErr: true, cfg: Config{Tags: "!", Names: []string{"example.com"}}},
+		"no names":           {wantErr: true, cfg: Config{Tags: "dns"}},
+		"unknown type":       {wantErr: true, cfg: Config{Tags: "dns", Names: []string{"example.com"}, Type: "MX"}},
+		"a without port":     {wantErr: true, cfg: Config{Tags: "dns", Names: []string{"example.com"}, Type: "A"}},
+		"bad resolver":       {wantErr: true, cfg: Config{Tags: "dns", Names: []string{"example.com"}, Resolver: "127.0.0.1"}},
+		"negative interval":  {wantErr: true, cfg: Config{Tags: "dns", Names: []string{"example.com"}, Interval: -1}},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			d, err := NewDiscovery(test.cfg)
+
+			if test.wantErr {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.NotNil(t, d)
+			}
+		})
+	}
+}
+
+func TestDiscovery_String(t *testing.T) {
+	var d Discovery
+	assert.NotEmpty(t, d.String())
+}
+
+func TestDiscovery_Discover(t *testing.T) {
+	tests := map[string]struct {
+		cfg            Config
+		afterStart     func(srv *fakeServer)
+		expectedGroups [][]model.Group
+	}{
+		"srv records": {
+			cfg: Config{Names: []string{"_http._tcp.example.com", "_missing._tcp.example.com"}},
+			expectedGroups: [][]model.Group{{
+				prepareGroup("_http._tcp.example.com",
+					prepareSRVTarget("_http._tcp.example.com", "web1.example.com", "8080", "10", "60"),
+					prepareSRVTarget("_http._tcp.example.com", "web2.example.com", "8080", "10", "40"),
+					prepareSRVTarget("_http._tcp.example.com", "backup.example.com", "80", "20", "0"),
+				),
+				prepareGroup("_missing._tcp.example.com"),
+			}},
+		},
+		"a records": {
+			cfg: Config{Names: []string{"db.example.com"}, Type: TypeA, Port: 5432},
+			expectedGroups: [][]model.Group{{
+				prepareGroup("db.example.com",
+					prepareIPTarget(TypeA, "db.example.com", "192.0.2.10", "5432"),
+					prepareIPTarget(TypeA, "db.example.com", "192.0.2.11", "5432"),
+				),
+			}},
+		},
+		"aaaa records": {
+			cfg: Config{Names: []string{"db.example.com"}, Type: TypeAAAA, Port: 5432},
+			expectedGroups: [][]model.Group{{
+				prepareGroup("db.example.com",
+					prepareIPTarget(TypeAAAA, "db.example.com", "2001:db8::10", "5432"),
+				),
+			}},
+		},
+		"record removed": {
+			cfg: Config{Names: []string{"db.example.com"}, Type: TypeA, Port: 5432},
+			afterStart: func(srv *fakeServer) {
+				srv.setA("db.example.com", "192.0.2.11")
+			},
+			expectedGroups: [][]model.Group{
+				{
+					prepareGroup("db.example.com",
+						prepareIPTarget(TypeA, "db.example.com", "192.0.2.10", "5432"),
+						prepareIPTarget(TypeA, "db.example.com", "192.0.2.11", "5432"),
+					),
+				},
+				{
+					prepareGroup("db.example.com",
+						prepareIPTarget(TypeA, "db.example.com", "192.0.2.11", "5432"),
+					),
+				},
+			},
+		},
+		"name removed": {
+			cfg: Config{Names: []string{"db.example.com"}, Type: TypeA, Port: 5432},
+			afterStart: func(srv *fakeServer) {
+				srv.removeName("db.example.com")
+			},
+			expectedGroups: [][]model.Group{
+				{
+					prepareGroup("db.example.com",
+						prepareIPTarget(TypeA, "db.example.com", "192.0.2.10", "5432"),
+						prepareIPTarget(TypeA, "db.example.com", "192.0.2.11", "5432"),
+					),
+				},
+				{
+					prepareGroup("db.example.com"),
+				},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv := newFakeServer(t)
+			defer srv.close()
+
+			cfg := test.cfg
+			cfg.Tags = "dns"
+			cfg.Resolver = srv.addr()
+			cfg.Interval = time.Millisecond * 100
+			d, err := NewDiscovery(cfg)
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+			defer cancel()
+
+			in := make(chan []model.Group)
+			go d.Discover(ctx, in)
+
+			for i, expected := range test.expectedGroups {
+				select {
+				case groups := <-in:
+					assert.Equalf(t, withSources(d, expected), groups, "groups #%d", i)
+				case <-ctx.Done():
+					t.Fatalf("timeout waiting for groups #%d", i)
+				}
+				if i == 0 && test.afterStart != nil {
+					test.afterStart(srv)
+				}
+			}
+		})
+	}
+}
+
+func TestDiscovery_Discover_SameName(t *testing.T) {
+	srv1, srv2 := newFakeServer(t), newFakeServer(t)
+	defer srv1.close()
+	defer srv2.close()
+	srv2.setA("db.example.com", "192.0.2.20")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	cfgs := []Config{
+		{Names: []string{"db.example.com"}, Type: TypeA, Port: 5432, Resolver: srv1.addr()},
+		{Names: []string{"db.example.com"}, Type: TypeA, Port: 6432, Resolver: srv1.addr()},
+		{Names: []string{"db.example.com"}, Type: TypeA, Port: 5432, Resolver: srv2.addr()},
+	}
+	expected := [][]model.Group{
+		{prepareGroup("db.example.com",
+			prepareIPTarget(TypeA, "db.example.com", "192.0.2.10", "5432"),
+			prepareIPTarget(TypeA, "db.example.com", "192.0.2.11", "5432"),
+		)},
+		{prepareGroup("db.example.com",
+			prepareIPTarget(TypeA, "db.example.com", "192.0.2.10", "6432"),
+			prepareIPTarget(TypeA, "db.example.com", "192.0.2.11", "6432"),
+		)},
+		{prepareGroup("db.example.com",
+			prepareIPTarget(TypeA, "db.example.com", "192.0.2.20", "5432"),
+		)},
+	}
+
+	sources := make(map[string]bool)
+	for i, cfg := range cfgs {
+		cfg.Tags = "dns"
+		d, err := NewDiscovery(cfg)
+		require.NoError(t, err)
+
+		in := make(chan []model.Group)
+		go d.Discover(ctx, in)
+
+		select {
+		case groups := <-in:
+			assert.Equalf(t, withSources(d, expected[i]), groups, "config #%d", i)
+			sources[groups[0].Source()] = true
+		case <-ctx.Done():
+			t.Fatalf("timeout waiting for groups of config #%d", i)
+		}
+	}
+	assert.Len(t, sources, len(cfgs))
+}
+
+func prepareGroup(name string, targets ...model.Target) model.Group {
+	return &dnsGroup{source: name, targets: targets}
+}
+
+// withSources replaces the names set as expected group sources with the discovery sources.
+func withSources(d *Discovery, groups []model.Group) []model.Group {
+	for _, group := range groups {
+		g := group.(*dnsGroup)
+		g.source = d.dnsSource(g.source)
+	}
+	return groups
+}
+
+func prepareSRVTarget(name, host, port, priority, weight string) model.Target {
+	return withHashAndTags(&RecordTarget{
+		tuid:     name + "_" + host + "_" + port,
+		Address:  net.JoinHostPort(host, port),
+		Name:     name,
+		Type:     TypeSRV,
+		Host:     host,
+		Port:     port,
+		Priority: priority,
+		Weight:   weight,
+	})
+}
+
+func prepareIPTarget(qtype, name, ip, port string) model.Target {
+	return withHashAndTags(&RecordTarget{
+		tuid:    name + "_" + ip + "_" + port,
+		Address: net.JoinHostPort(ip, port),
+		Name:    name,
+		Type:    qtype,
+		Host:    ip,
+		Port:    port,
+	})
+}
+
+func withHashAndTags(target *RecordTarget) *RecordTarget {
+	d := Discovery{tags: model.Tags{"dns": {}}}
+	d.finalize(target)
+	return target
+}
+
+// fakeServer is an in-process authoritative DNS server that serves records over UDP.
+type fakeServer struct {
+	mu   sync.Mutex
+	srv  map[string][]dnsmessage.SRVResource
+	a    map[string][]dnsmessage.AResource
+	aaaa map[string][]dnsmessage.AAAAResource
+	conn net.PacketConn
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := &fakeServer{
+		srv: map[string][]dnsmessage.SRVResource{
+			"_http._tcp.example.com.": {
+				{Priority: 20, Weight: 0, Port: 80, Target: dnsmessage.MustNewName("backup.example.com.")},
+				{Priority: 10, Weight: 40, Port: 8080, Target: dnsmessage.MustNewName("web2.example.com.")},
+				{Priority: 10, Weight: 60, Port: 8080, Target: dnsmessage.MustNewName("web1.example.com.")},
+			},
+		},
+		a:    make(map[string][]dnsmessage.AResource),
+		aaaa: make(map[string][]dnsmessage.AAAAResource),
+		conn: conn,
+	}
+	s.setA("db.example.com", "192.0.2.11", "192.0.2.10")
+	s.aaaa["db.example.com."] = []dnsmessage.AAAAResource{{AAAA: ip16("2001:db8::10")}}
+
+	go s.serve()
+	return s
+}
+
+func (s *fakeServer) addr() string { return s.conn.LocalAddr().String() }
+func (s *fakeServer) close()       { _ = s.conn.Close() }
+
+func (s *fakeServer) setA(name string, ips ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var records []dnsmessage.AResource
+	for _, ip := range ips {
+		var v [4]byte
+		copy(v[:], net.ParseIP(ip).To4())
+		records = append(records, dnsmessage.AResource{A: v})
+	}
+	s.a[name+"."] = records
+}
+
+func (s *fakeServer) removeName(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.srv, name+".")
+	delete(s.a, name+".")
+	delete(s.aaaa, name+".")
+}
+
+func (s *fakeServer) serve() {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if resp, err := s.answer(buf[:n]); err == nil {
+			_, _ = s.conn.WriteTo(resp, addr)
+		}
+	}
+}
+
+func (s *fakeServer) answer(req []byte) ([]byte, error) {
+	var p dnsmessage.Parser
+	hdr, err := p.Start(req)
+	if err != nil {
+		return nil, err
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := strings.ToLower(q.Name.String())
+	_, hasSRV := s.srv[name]
+	_, hasA := s.a[name]
+	_, hasAAAA := s.aaaa[name]
+
+	rcode := dnsmessage.RCodeSuccess
+	if !hasSRV && !hasA && !hasAAAA {
+		rcode = dnsmessage.RCodeNameError
+	}
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:            hdr.ID,
+		Response:      true,
+		Authoritative: true,
+		RCode:         rcode,
+	})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(q); err != nil {
+		return nil, err
+	}
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+
+	rh := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 60}
+	switch q.Type {
+	case dnsmessage.TypeSRV:
+		for _, r := range s.srv[name] {
+			if err := b.SRVResource(rh, r); err != nil {
+				return nil, err
+			}
+		}
+	case dnsmessage.TypeA:
+		for _, r := range s.a[name] {
+			if err := b.AResource(rh, r); err != nil {
+				return nil, err
+			}
+		}
+	case dnsmessage.TypeAAAA:
+		for _, r := range s.aaaa[name] {
+			if err := b.AAAAResource(rh, r); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return b.Finish()
+}
+
+func ip16(ip string) (v [16]byte) {
+	copy(v[:], net.ParseIP(ip).To16())
+	return v
+}
@@ -0,0 +1,171 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var errNotFound = errors.New("not found")
+
+type (
+	containerSummary struct {
+		ID    string `json:"Id"`
+		State string `json:"State"`
+	}
+	containerJSON struct {
+		ID    string `json:"Id"`
+		Name  string `json:"Name"`
+		State struct {
+			Running bool `json:"Running"`
+		} `json:"State"`
+		Config struct {
+			Image        string              `json:"Image"`
+			Labels       map[string]string   `json:"Labels"`
+			ExposedPorts map[string]struct{} `json:"ExposedPorts"`
+		} `json:"Config"`
+		HostConfig struct {
+			NetworkMode string `json:"NetworkMode"`
+		} `json:"HostConfig"`
+		NetworkSettings struct {
+			Ports    map[string][]portBinding `json:"Ports"`
+			Networks map[string]struct {
+				IPAddress string `json:"IPAddress"`
+			} `json:"Networks"`
+		} `json:"NetworkSettings"`
+	}
+	portBinding struct {
+		HostIP   string `json:"HostIp"`
+		HostPort string `json:"HostPort"`
+	}
+	event struct {
+		Type   string `json:"Type"`
+		Action string `json:"Action"`
+		Actor  struct {
+			ID string `json:"ID"`
+		} `json:"Actor"`
+	}
+)
+
+// apiClient is a minimal Docker Engine API client.
+type apiClient struct {
+	httpClient *http.Client
+	baseURL    string
+}
+
+func newAPIClient(address string) (*apiClient, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("parse address '%s': %v", address, err)
+	}
+
+	switch u.Scheme {
+	case "unix":
+		path := u.Path
+		dialer := &net.Dialer{Timeout: 5 * time.Second}
+		return &apiClient{
+			httpClient: &http.Client{Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return dialer.DialContext(ctx, "unix", path)
+				},
+			}},
+			baseURL: "http://docker",
+		}, nil
+	case "tcp", "http":
+		return &apiClient{
+			httpClient: &http.Client{},
+			baseURL:    "http://" + u.Host,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported address scheme '%s'", u.Scheme)
+	}
+}
+
+func (c *apiClient) containers(ctx context.Context) ([]containerSummary, error) {
+	var containers []containerSummary
+	if err := c.getJSON(ctx, "/containers/json", &containers); err != nil {
+		return nil, err
+	}
+	return containers, nil
+}
+
+func (c *apiClient) inspect(ctx context.Context, id string) (*containerJSON, error) {
+	var cntr containerJSON
+	if err := c.getJSON(ctx, "/containers/"+url.PathEscape(id)+"/json", &cntr); err != nil {
+		return nil, err
+	}
+	return &cntr, nil
+}
+
+// events streams container events until the context is done or the connection is closed.
+func (c *apiClient) events(ctx context.Context, fn func(event)) error {
+	filters := url.QueryEscape(`{"type":["container"]}`)
+	resp, err := c.get(ctx, "/events?filters="+filters)
+	if err != nil {
+		return err
+	}
+	// the stream never ends, don't drain it
+	defer func() { _ = resp.Body.Close() }()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var e event
+		if err := dec.Decode(&e); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		fn(e)
+	}
+}
+
+// requestTimeout limits the non-streaming requests, so that a hung daemon doesn't block the discoverer.
+var requestTimeout = time.Second * 30
+
+func (c *apiClient) getJSON(ctx context.Context, path string, v interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	resp, err := c.get(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer closeBody(resp)
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (c *apiClient) get(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp, nil
+	case http.StatusNotFound:
+		closeBody(resp)
+		return nil, errNotFound
+	default:
+		closeBody(resp)
+		return nil, fmt.Errorf("'%s' returned HTTP status code %d", strings.SplitN(path, "?", 2)[0], resp.StatusCode)
+	}
+}
+
+func closeBody(resp *http.Response) {
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+}
@@ -0,0 +1,412 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/netdata/sd/pipeline/model"
+	"github.com/netdata/sd/pkg/log"
+
+	"github.com/ilyam8/hashstructure"
+	"github.com/rs/zerolog"
+)
+
+const (
+	defaultAddress = "unix:///var/run/docker.sock"
+
+	labelComposeProject = "com.docker.compose.project"
+	labelComposeService = "com.docker.compose.service"
+)
+
+type Config struct {
+	Tags    string `yaml:"tags"`
+	Address string `yaml:"address"`
+}
+
+func validateConfig(cfg Config) error {
+	if cfg.Tags == "" {
+		return errors.New("no tags set")
+	}
+	return nil
+}
+
+type (
+	containerGroup struct {
+		targets []model.Target
+		source  string
+	}
+	ContainerTarget struct {
+		model.Base `hash:"ignore"`
+		hash       uint64
+		tuid       string
+		Address    string
+
+		ID             string
+		Name           string
+		Image          string
+		Labels         map[string]interface{}
+		NetworkMode    string
+		Networks       map[string]interface{}
+		IPAddress      string
+		ComposeProject string
+		ComposeService string
+
+		Port          string
+		PortProtocol  string
+		PublishedIP   string
+		PublishedPort string
+	}
+)
+
+func (ct ContainerTarget) Hash() uint64 { return ct.hash }
+func (ct ContainerTarget) TUID() string { return ct.tuid }
+
+func (cg containerGroup) Source() string          { return cg.source }
+func (cg containerGroup) Targets() []model.Target { return cg.targets }
+
+type (
+	Discovery struct {
+		tags         model.Tags
+		client       *apiClient
+		refreshEvery time.Duration
+		cache        cache
+		log          zerolog.Logger
+	}
+	cache map[string]uint64 // container id:group hash
+)
+
+func NewDiscovery(cfg Config) (*Discovery, error) {
+	if err := validateConfig(cfg); err != nil {
+		return nil, fmt.Errorf("docker discovery config validation: %v", err)
+	}
+
+	d, err := initDiscovery(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("docker discovery initialization: %v", err)
+	}
+	return d, nil
+}
+
+func initDiscovery(cfg Config) (*Discovery, error) {
+	tags, err := model.ParseTags(cfg.Tags)
+	if err != nil {
+		return nil, fmt.Errorf("parse config->tags: %v", err)
+	}
+	address := cfg.Address
+	if address == "" {
+		address = defaultAddress
+	}
+	client, err := newAPIClient(address)
+	if err != nil {
+		return nil, fmt.Errorf("create client: %v", err)
+	}
+
+	d := &Discovery{
+		tags:         tags,
+		client:       client,
+		refreshEvery: time.Minute,
+		cache:        make(cache),
+		log:          log.New("docker discovery"),
+	}
+	return d, nil
+}
+
+func (d *Discovery) String() string {
+	return "docker discovery"
+}
+
+func (d *Discovery) Discover(ctx context.Context, in chan<- []model.Group) {
+	d.log.Info().Msg("instance is started")
+	defer d.log.Info().Msg("instance is stopped")
+
+	events := make(chan string)
+	go d.watchEvents(ctx, events)
+
+	d.refresh(ctx, in)
+
+	tk := time.NewTicker(d.refreshEvery)
+	defer tk.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tk.C:
+			d.refresh(ctx, in)
+		case id := <-events:
+			d.refreshContainer(ctx, in, id)
+		}
+	}
+}
+
+// watchEvents forwards IDs of changed containers, it reconnects if the stream breaks.
+func (d *Discovery) watchEvents(ctx context.Context, events chan<- string) {
+	for {
+		err := d.client.events(ctx, func(e event) {
+			if e.Type != "container" || e.Actor.ID == "" || !isStateChangeAction(e.Action) {
+				return
+			}
+			select {
+			case <-ctx.Done():
+			case events <- e.Actor.ID:
+			}
+		})
+		if err != nil {
+			d.log.Warn().Err(err).Msg("events stream error")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second * 5):
+		}
+	}
+}
+
+func (d *Discovery) refresh(ctx context.Context, in chan<- []model.Group) {
+	containers, err := d.client.containers(ctx)
+	if err != nil {
+		d.log.Error().Err(err).Msg("failed to list containers")
+		return
+	}
+
+	var groups []model.Group
+	seen := make(map[string]bool)
+
+	for _, c := range containers {
+		cntr, err := d.client.inspect(ctx, c.ID)
+		if err != nil {
+			if !errors.Is(err, errNotFound) {
+				// the container still exists, keep its group until the next successful inspect
+				seen[c.ID] = true
+				d.log.Warn().Err(err).Msgf("failed to inspect container '%s'", c.ID)
+			}
+			continue
+		}
+		seen[cntr.ID] = true
+		if group := d.updateCache(cntr); group != nil {
+			groups = append(groups, group)
+		}
+	}
+
+	for id := range d.cache {
+		if !seen[id] {
+			delete(d.cache, id)
+			groups = append(groups, &containerGroup{source: containerSource(id)})
+		}
+	}
+
+	d.send(ctx, in, groups)
+}
+
+func (d *Discovery) refreshContainer(ctx context.Context, in chan<- []model.Group, id string) {
+	cntr, err := d.client.inspect(ctx, id)
+	if err != nil && !errors.Is(err, errNotFound) {
+		d.log.Warn().Err(err).Msgf("failed to inspect container '%s'", id)
+		return
+	}
+
+	if cntr == nil || !cntr.State.Running {
+		if _, ok := d.cache[id]; ok {
+			delete(d.cache, id)
+			d.send(ctx, in, []model.Group{&containerGroup{source: containerSource(id)}})
+		}
+		return
+	}
+
+	if group := d.updateCache(cntr); group != nil {
+		d.send(ctx, in, []model.Group{group})
+	}
+}
+
+// updateCache returns the container group if it is new or changed since the last time.
+func (d *Discovery) updateCache(cntr *containerJSON) model.Group {
+	group := d.buildGroup(cntr)
+
+	var hash uint64
+	for _, tgt := range group.Targets() {
+		hash = hash*31 + tgt.Hash()
+	}
+	if v, ok := d.cache[cntr.ID]; ok && v == hash {
+		return nil
+	}
+	d.cache[cntr.ID] = hash
+	return group
+}
+
+func (d *Discovery) buildGroup(cntr *containerJSON) model.Group {
+	return &containerGroup{
+		source:  containerSource(cntr.ID),
+		targets: d.buildTargets(cntr),
+	}
+}
+
+func (d *Discovery) buildTargets(cntr *containerJSON) (targets []model.Target) {
+	name := strings.TrimPrefix(cntr.Name, "/")
+	networks, ip := containerNetworks(cntr)
+	labels := cntr.Config.Labels
+
+	newTarget := func() *ContainerTarget {
+		return &ContainerTarget{
+			ID:             cntr.ID,
+			Name:           name,
+			Image:          cntr.Config.Image,
+			Labels:         toMapInterface(labels),
+			NetworkMode:    cntr.HostConfig.NetworkMode,
+			Networks:       networks,
+			IPAddress:      ip,
+			ComposeProject: labels[labelComposeProject],
+			ComposeService: labels[labelComposeService],
+		}
+	}
+
+	ports := containerPorts(cntr)
+	if len(ports) == 0 {
+		target := newTarget()
+		target.tuid = name
+		target.Address = ip
+		if d.finalize(target) {
+			targets = append(targets, target)
+		}
+		return targets
+	}
+
+	for _, port := range ports {
+		portNum, proto := splitPort(port)
+		target := newTarget()
+		target.tuid = fmt.Sprintf("%s_%s_%s", name, proto, portNum)
+		target.Port = portNum
+		target.PortProtocol = proto
+
+		if bindings := cntr.NetworkSettings.Ports[port]; len(bindings) > 0 {
+			target.PublishedIP = bindings[0].HostIP
+			target.PublishedPort = bindings[0].HostPort
+		}
+
+		switch {
+		case ip != "":
+			target.Address = net.JoinHostPort(ip, portNum)
+		case target.PublishedPort != "":
+			target.Address = net.JoinHostPort(publishedHost(target.PublishedIP), target.PublishedPort)
+		default:
+			// host network mode: the container listens on the host interfaces
+			target.Address = net.JoinHostPort("127.0.0.1", portNum)
+		}
+
+		if d.finalize(target) {
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+func (d *Discovery) finalize(target *ContainerTarget) bool {
+	hash, err := hashstructure.Hash(target, nil)
+	if err != nil {
+		return false
+	}
+	target.hash = hash
+	target.Tags().Merge(d.tags)
+	return true
+}
+
+func (d *Discovery) send(ctx context.Context, in chan<- []model.Group, groups []model.Group) {
+	if len(groups) == 0 {
+		return
+	}
+	select {
+	case <-ctx.Done():
+	case in <- groups:
+	}
+}
+
+func containerNetworks(cntr *containerJSON) (map[string]interface{}, string) {
+	if len(cntr.NetworkSettings.Networks) == 0 {
+		return nil, ""
+	}
+	names := make([]string, 0, len(cntr.NetworkSettings.Networks))
+	for name := range cntr.NetworkSettings.Networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var ip string
+	networks := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		addr := cntr.NetworkSettings.Networks[name].IPAddress
+		networks[name] = addr
+		if ip == "" {
+			ip = addr
+		}
+	}
+	return networks, ip
+}
+
+// containerPorts returns both exposed and published ports ('80/tcp') in sorted order.
+func containerPorts(cntr *containerJSON) []string {
+	set := make(map[string]bool)
+	for port := range cntr.Config.ExposedPorts {
+		set[port] = true
+	}
+	for port := range cntr.NetworkSettings.Ports {
+		set[port] = true
+	}
+	ports := make([]string, 0, len(set))
+	for port := range set {
+		ports = append(ports, port)
+	}
+	sort.Slice(ports, func(i, j int) bool {
+		pi, proti := splitPort(ports[i])
+		pj, protj := splitPort(ports[j])
+		ni, _ := strconv.Atoi(pi)
+		nj, _ := strconv.Atoi(pj)
+		if ni != nj {
+			return ni < nj
+		}
+		return proti < protj
+	})
+	return ports
+}
+
+func splitPort(port string) (num, proto string) {
+	if idx := strings.IndexByte(port, '/'); idx != -1 {
+		return port[:idx], port[idx+1:]
+	}
+	return port, "tcp"
+}
+
+func publishedHost(ip string) string {
+	if ip == "" || ip == "0.0.0.0" || ip == "::" {
+		return "127.0.0.1"
+	}
+	return ip
+}
+
+func isStateChangeAction(action string) bool {
+	switch action {
+	case "start", "restart", "die", "stop", "kill", "destroy", "pause", "unpause", "rename", "update", "connect", "disconnect":
+		return true
+	}
+	return false
+}
+
+func containerSource(id string) string {
+	return "docker/container/" + id
+}
+
+func toMapInterface(src map[string]string) map[string]interface{} {
+	if src == nil {
+		return nil
+	}
+	m := make(map[string]interface{}, len(src))
+	for k, v := range src {
+		m[k] = v
+	}
+	return m
+}
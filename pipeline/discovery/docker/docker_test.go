@@ -0,0 +1,384 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/netdata/sd/pipeline/model"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDiscovery(t *testing.T) {
+	tests := map[string]struct {
+		cfg     Config
+		wantErr bool
+	}{
+		"default address":   {cfg: Config{Tags: "docker"}},
+		"tcp address":       {cfg: Config{Tags: "docker", Address: "tcp://127.0.0.1:2375"}},
+		"no tags":           {wantErr: true, cfg: Config{}},
+		"bad tags":          {wantErr: true, cfg: Config{Tags: "!"}},
+		"unsupported proto": {wantErr: true, cfg: Config{Tags: "docker", Address: "ftp://127.0.0.1"}},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			d, err := NewDiscovery(test.cfg)
+
+			if test.wantErr {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.NotNil(t, d)
+			}
+		})
+	}
+}
+
+func TestDiscovery_String(t *testing.T) {
+	var d Discovery
+	assert.NotEmpty(t, d.String())
+}
+
+func TestDiscovery_Discover(t *testing.T) {
+	tests := map[string]struct {
+		containers     []containerJSON
+		afterStart     func(api *fakeAPI)
+		expectedGroups [][]model.Group
+	}{
+		"container with exposed and published ports": {
+			containers: []containerJSON{prepareNginxContainer()},
+			expectedGroups: [][]model.Group{
+				{prepareNginxGroup()},
+			},
+		},
+		"container without ports": {
+			containers: []containerJSON{prepareBusyboxContainer()},
+			expectedGroups: [][]model.Group{
+				{prepareBusyboxGroup()},
+			},
+		},
+		"container stopped": {
+			containers: []containerJSON{prepareNginxContainer()},
+			afterStart: func(api *fakeAPI) {
+				api.remove("nginx")
+				api.sendEvent(event{Type: "container", Action: "die", Actor: struct {
+					ID string `json:"ID"`
+				}{ID: "nginx"}})
+			},
+			expectedGroups: [][]model.Group{
+				{prepareNginxGroup()},
+				{&containerGroup{source: containerSource("nginx")}},
+			},
+		},
+		"container started": {
+			containers: []containerJSON{prepareBusyboxContainer()},
+			afterStart: func(api *fakeAPI) {
+				api.add(prepareNginxContainer())
+				api.sendEvent(event{Type: "container", Action: "start", Actor: struct {
+					ID string `json:"ID"`
+				}{ID: "nginx"}})
+			},
+			expectedGroups: [][]model.Group{
+				{prepareBusyboxGroup()},
+				{prepareNginxGroup()},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			api := newFakeAPI(t, test.containers...)
+			defer api.close()
+
+			d, err := NewDiscovery(Config{Tags: "docker", Address: "unix://" + api.socket})
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+			defer cancel()
+
+			in := make(chan []model.Group)
+			go d.Discover(ctx, in)
+
+			for i, expected := range test.expectedGroups {
+				select {
+				case groups := <-in:
+					assert.Equal(t, expected, groups)
+				case <-ctx.Done():
+					t.Fatalf("timeout waiting for groups #%d", i)
+				}
+				if i == 0 && test.afterStart != nil {
+					api.waitEventsSubscriber(t)
+					test.afterStart(api)
+				}
+			}
+		})
+	}
+}
+
+func TestDiscovery_refresh_InspectError(t *testing.T) {
+	api := newFakeAPI(t, prepareNginxContainer())
+	defer api.close()
+
+	d, err := NewDiscovery(Config{Tags: "docker", Address: "unix://" + api.socket})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	in := make(chan []model.Group, 1)
+	d.refresh(ctx, in)
+	assert.Equal(t, []model.Group{prepareNginxGroup()}, <-in)
+
+	// a transient API error must not remove the container group
+	api.mu.Lock()
+	api.inspectStatus = http.StatusInternalServerError
+	api.mu.Unlock()
+	d.refresh(ctx, in)
+	assert.Empty(t, in)
+	assert.Contains(t, d.cache, "nginx")
+}
+
+func TestDiscovery_refresh_Timeout(t *testing.T) {
+	timeout := requestTimeout
+	requestTimeout = time.Millisecond * 100
+	defer func() { requestTimeout = timeout }()
+
+	api := newFakeAPI(t, prepareNginxContainer())
+	api.hang = make(chan struct{})
+	defer api.close()
+	defer close(api.hang)
+
+	d, err := NewDiscovery(Config{Tags: "docker", Address: "unix://" + api.socket})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	_, err = d.client.containers(ctx)
+	assert.Error(t, err)
+	assert.NoError(t, ctx.Err())
+}
+
+func prepareNginxContainer() containerJSON {
+	var c containerJSON
+	c.ID = "nginx"
+	c.Name = "/web_nginx_1"
+	c.State.Running = true
+	c.Config.Image = "nginx:latest"
+	c.Config.Labels = map[string]string{
+		labelComposeProject: "web",
+		labelComposeService: "nginx",
+	}
+	c.Config.ExposedPorts = map[string]struct{}{"80/tcp": {}}
+	c.HostConfig.NetworkMode = "web_default"
+	c.NetworkSettings.Ports = map[string][]portBinding{
+		"80/tcp":  {{HostIP: "0.0.0.0", HostPort: "8080"}},
+		"443/tcp": nil,
+	}
+	c.NetworkSettings.Networks = map[string]struct {
+		IPAddress string `json:"IPAddress"`
+	}{
+		"web_default": {IPAddress: "172.18.0.2"},
+	}
+	return c
+}
+
+func prepareNginxGroup() model.Group {
+	newTarget := func() *ContainerTarget {
+		return &ContainerTarget{
+			ID:             "nginx",
+			Name:           "web_nginx_1",
+			Image:          "nginx:latest",
+			Labels:         map[string]interface{}{labelComposeProject: "web", labelComposeService: "nginx"},
+			NetworkMode:    "web_default",
+			Networks:       map[string]interface{}{"web_default": "172.18.0.2"},
+			IPAddress:      "172.18.0.2",
+			ComposeProject: "web",
+			ComposeService: "nginx",
+			PortProtocol:   "tcp",
+		}
+	}
+	http := newTarget()
+	http.tuid = "web_nginx_1_tcp_80"
+	http.Address = "172.18.0.2:80"
+	http.Port = "80"
+	http.PublishedIP = "0.0.0.0"
+	http.PublishedPort = "8080"
+
+	https := newTarget()
+	https.tuid = "web_nginx_1_tcp_443"
+	https.Address = "172.18.0.2:443"
+	https.Port = "443"
+
+	return &containerGroup{
+		source:  containerSource("nginx"),
+		targets: []model.Target{withHashAndTags(http), withHashAndTags(https)},
+	}
+}
+
+func prepareBusyboxContainer() containerJSON {
+	var c containerJSON
+	c.ID = "busybox"
+	c.Name = "/busybox"
+	c.State.Running = true
+	c.Config.Image = "busybox"
+	c.HostConfig.NetworkMode = "bridge"
+	c.NetworkSettings.Networks = map[string]struct {
+		IPAddress string `json:"IPAddress"`
+	}{
+		"bridge": {IPAddress: "172.17.0.3"},
+	}
+	return c
+}
+
+func prepareBusyboxGroup() model.Group {
+	target := &ContainerTarget{
+		tuid:        "busybox",
+		Address:     "172.17.0.3",
+		ID:          "busybox",
+		Name:        "busybox",
+		Image:       "busybox",
+		NetworkMode: "bridge",
+		Networks:    map[string]interface{}{"bridge": "172.17.0.3"},
+		IPAddress:   "172.17.0.3",
+	}
+	return &containerGroup{
+		source:  containerSource("busybox"),
+		targets: []model.Target{withHashAndTags(target)},
+	}
+}
+
+func withHashAndTags(target *ContainerTarget) *ContainerTarget {
+	d := Discovery{tags: model.Tags{"docker": {}}}
+	d.finalize(target)
+	return target
+}
+
+type fakeAPI struct {
+	mu         sync.Mutex
+	containers map[string]containerJSON
+	// inspectStatus is the HTTP status code of the inspect responses if set
+	inspectStatus int
+	// hang makes the API never respond to the non-streaming requests
+	hang       chan struct{}
+	events     chan event
+	subscribed chan struct{}
+	srv        *httptest.Server
+	socket     string
+}
+
+func newFakeAPI(t *testing.T, containers ...containerJSON) *fakeAPI {
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	ln, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+
+	api := &fakeAPI{
+		containers: make(map[string]containerJSON),
+		events:     make(chan event),
+		subscribed: make(chan struct{}, 1),
+		socket:     socket,
+	}
+	for _, c := range containers {
+		api.containers[c.ID] = c
+	}
+
+	api.srv = httptest.NewUnstartedServer(api)
+	api.srv.Listener = ln
+	api.srv.Start()
+	return api
+}
+
+func (a *fakeAPI) close() {
+	a.srv.CloseClientConnections()
+	a.srv.Close()
+}
+
+func (a *fakeAPI) add(c containerJSON) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.containers[c.ID] = c
+}
+
+func (a *fakeAPI) remove(id string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.containers, id)
+}
+
+func (a *fakeAPI) sendEvent(e event) {
+	a.events <- e
+}
+
+func (a *fakeAPI) waitEventsSubscriber(t *testing.T) {
+	select {
+	case <-a.subscribed:
+	case <-time.After(time.Second * 5):
+		t.Fatal("events stream is not subscribed")
+	}
+}
+
+func (a *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	hang, inspectStatus := a.hang, a.inspectStatus
+	a.mu.Unlock()
+	if hang != nil && r.URL.Path != "/events" {
+		select {
+		case <-hang:
+		case <-r.Context().Done():
+		}
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/containers/json":
+		a.mu.Lock()
+		var list []containerSummary
+		for _, c := range a.containers {
+			list = append(list, containerSummary{ID: c.ID, State: "running"})
+		}
+		a.mu.Unlock()
+		_ = json.NewEncoder(w).Encode(list)
+	case strings.HasPrefix(r.URL.Path, "/containers/") && strings.HasSuffix(r.URL.Path, "/json"):
+		id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/containers/"), "/json")
+		a.mu.Lock()
+		c, ok := a.containers[id]
+		a.mu.Unlock()
+		if inspectStatus != 0 {
+			w.WriteHeader(inspectStatus)
+			return
+		}
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(c)
+	case r.URL.Path == "/events":
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		select {
+		case a.subscribed <- struct{}{}:
+		default:
+		}
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case e := <-a.events:
+				_ = json.NewEncoder(w).Encode(e)
+				w.(http.Flusher).Flush()
+			}
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
@@ -0,0 +1,219 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/netdata/sd/pipeline/model"
+	"github.com/netdata/sd/pkg/fswatch"
+	"github.com/netdata/sd/pkg/log"
+
+	"github.com/ilyam8/hashstructure"
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v2"
+)
+
+type Config struct {
+	Tags  string   `yaml:"tags"`
+	Files []string `yaml:"files"`
+}
+
+func validateConfig(cfg Config) error {
+	if cfg.Tags == "" {
+		return errors.New("no tags set")
+	}
+	if len(cfg.Files) == 0 {
+		return errors.New("no files set")
+	}
+	for _, pattern := range cfg.Files {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("bad files pattern '%s': %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+type (
+	fileGroup struct {
+		targets []model.Target
+		source  string
+	}
+	StaticTarget struct {
+		model.Base `hash:"ignore"`
+		hash       uint64
+		tuid       string
+		Address    string
+
+		File   string
+		Labels map[string]interface{}
+		Fields map[string]interface{}
+	}
+)
+
+func (st StaticTarget) Hash() uint64 { return st.hash }
+func (st StaticTarget) TUID() string { return st.tuid }
+
+func (fg fileGroup) Source() string          { return fg.source }
+func (fg fileGroup) Targets() []model.Target { return fg.targets }
+
+type Discovery struct {
+	tags    model.Tags
+	paths   []string
+	watcher *fswatch.Watcher
+	log     zerolog.Logger
+}
+
+func NewDiscovery(cfg Config) (*Discovery, error) {
+	if err := validateConfig(cfg); err != nil {
+		return nil, fmt.Errorf("file discovery config validation: %v", err)
+	}
+
+	tags, err := model.ParseTags(cfg.Tags)
+	if err != nil {
+		return nil, fmt.Errorf("file discovery initialization: parse config->tags: %v", err)
+	}
+
+	logger := log.New("file discovery")
+	d := &Discovery{
+		tags:    tags,
+		paths:   cfg.Files,
+		watcher: fswatch.New(cfg.Files, logger),
+		log:     logger,
+	}
+	return d, nil
+}
+
+func (d *Discovery) String() string {
+	return fmt.Sprintf("file discovery: %v", d.paths)
+}
+
+func (d *Discovery) Discover(ctx context.Context, in chan<- []model.Group) {
+	d.log.Info().Msg("instance is started")
+	defer d.log.Info().Msg("instance is stopped")
+
+	d.watcher.Run(ctx, func(ctx context.Context, changedFiles, removedFiles []string) {
+		d.refresh(ctx, in, changedFiles, removedFiles)
+	})
+}
+
+func (d *Discovery) refresh(ctx context.Context, in chan<- []model.Group, changedFiles, removedFiles []string) {
+	var groups []model.Group
+
+	for _, file := range changedFiles {
+		var entries []map[string]interface{}
+		switch err := load(&entries, file); err {
+		case nil, io.EOF:
+			groups = append(groups, d.buildGroup(file, entries))
+		default:
+			d.log.Warn().Err(err).Msgf("failed to load '%s'", file)
+		}
+	}
+
+	for _, file := range removedFiles {
+		groups = append(groups, &fileGroup{source: fileSource(file)})
+	}
+
+	d.send(ctx, in, groups)
+}
+
+func (d *Discovery) buildGroup(file string, entries []map[string]interface{}) model.Group {
+	group := &fileGroup{source: fileSource(file)}
+	seen := make(map[string]bool)
+
+	for i, entry := range entries {
+		address, _ := entry["address"].(string)
+		if address == "" {
+			d.log.Warn().Msgf("'%s': entry #%d has no address, skipping it", file, i)
+			continue
+		}
+		if seen[address] {
+			d.log.Warn().Msgf("'%s': duplicate address '%s', skipping it", file, address)
+			continue
+		}
+		seen[address] = true
+
+		target := &StaticTarget{
+			tuid:    address,
+			Address: address,
+			File:    file,
+		}
+		for k, v := range entry {
+			switch k {
+			case "address":
+			case "labels":
+				if labels, ok := normalize(v).(map[string]interface{}); ok {
+					target.Labels = labels
+				}
+			default:
+				if target.Fields == nil {
+					target.Fields = make(map[string]interface{})
+				}
+				target.Fields[k] = normalize(v)
+			}
+		}
+
+		if d.finalize(target) {
+			group.targets = append(group.targets, target)
+		}
+	}
+	return group
+}
+
+func (d *Discovery) finalize(target *StaticTarget) bool {
+	hash, err := hashstructure.Hash(target, nil)
+	if err != nil {
+		return false
+	}
+	target.hash = hash
+	target.Tags().Merge(d.tags)
+	return true
+}
+
+func (d *Discovery) send(ctx context.Context, in chan<- []model.Group, groups []model.Group) {
+	if len(groups) == 0 {
+		return
+	}
+	select {
+	case <-ctx.Done():
+	case in <- groups:
+	}
+}
+
+func fileSource(path string) string {
+	return "file/" + path
+}
+
+// normalize converts yaml maps (map[interface{}]interface{}) to map[string]interface{},
+// so the values can be used in templates.
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			m[fmt.Sprint(k)] = normalize(val)
+		}
+		return m
+	case []interface{}:
+		for i := range v {
+			v[i] = normalize(v[i])
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// load decodes both YAML and JSON files, JSON is a subset of YAML.
+func load(conf interface{}, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return yaml.NewDecoder(f).Decode(conf)
+}
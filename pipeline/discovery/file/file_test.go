@@ -0,0 +1,200 @@
+package file
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/netdata/sd/pipeline/model"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDiscovery(t *testing.T) {
+	tests := map[string]struct {
+		cfg     Config
+		wantErr bool
+	}{
+		"valid config": {cfg: Config{Tags: "static", Files: []string{"/etc/sd/*.yaml"}}},
+		"no tags":      {wantErr: true, cfg: Config{Files: []string{"/etc/sd/*.yaml"}}},
+		"bad tags":     {wantErr: true, cfg: Config{Tags: "!", Files: []string{"/etc/sd/*.yaml"}}},
+		"no files":     {wantErr: true, cfg: Config{Tags: "static"}},
+		"bad pattern":  {wantErr: true, cfg: Config{Tags: "static", Files: []string{"/etc/sd/[.yaml"}}},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			d, err := NewDiscovery(test.cfg)
+
+			if test.wantErr {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.NotNil(t, d)
+			}
+		})
+	}
+}
+
+func TestDiscovery_String(t *testing.T) {
+	var d Discovery
+	assert.NotEmpty(t, d.String())
+}
+
+const (
+	yamlTargets = `
+- address: 192.0.2.1:9100
+  labels:
+    env: prod
+  name: host1
+  tags: [a, b]
+- address: 192.0.2.2:9100
+  extra:
+    nested: true
+- address: 192.0.2.1:9100
+- name: no-address
+`
+	jsonTargets = `[{"address": "198.51.100.1:80", "labels": {"dc": "west"}}]`
+)
+
+func TestDiscovery_Discover(t *testing.T) {
+	tests := map[string]struct {
+		files          map[string]string
+		afterStart     func(dir string)
+		expectedGroups func(dir string) [][]model.Group
+	}{
+		"yaml and json files": {
+			files: map[string]string{
+				"hosts.yaml": yamlTargets,
+				"web.json":   jsonTargets,
+				"skip.txt":   "not matched",
+			},
+			expectedGroups: func(dir string) [][]model.Group {
+				return [][]model.Group{{
+					prepareYAMLGroup(filepath.Join(dir, "hosts.yaml")),
+					prepareJSONGroup(filepath.Join(dir, "web.json")),
+				}}
+			},
+		},
+		"file removed": {
+			files: map[string]string{"web.json": jsonTargets},
+			afterStart: func(dir string) {
+				_ = os.Remove(filepath.Join(dir, "web.json"))
+			},
+			expectedGroups: func(dir string) [][]model.Group {
+				file := filepath.Join(dir, "web.json")
+				return [][]model.Group{
+					{prepareJSONGroup(file)},
+					{&fileGroup{source: fileSource(file)}},
+				}
+			},
+		},
+		"file emptied": {
+			files: map[string]string{"web.json": jsonTargets},
+			afterStart: func(dir string) {
+				_ = os.WriteFile(filepath.Join(dir, "web.json"), nil, 0644)
+			},
+			expectedGroups: func(dir string) [][]model.Group {
+				file := filepath.Join(dir, "web.json")
+				return [][]model.Group{
+					{prepareJSONGroup(file)},
+					{&fileGroup{source: fileSource(file)}},
+				}
+			},
+		},
+		"file added": {
+			files: map[string]string{"web.json": jsonTargets},
+			afterStart: func(dir string) {
+				_ = os.WriteFile(filepath.Join(dir, "hosts.yaml"), []byte(yamlTargets), 0644)
+			},
+			expectedGroups: func(dir string) [][]model.Group {
+				return [][]model.Group{
+					{prepareJSONGroup(filepath.Join(dir, "web.json"))},
+					{prepareYAMLGroup(filepath.Join(dir, "hosts.yaml"))},
+				}
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range test.files {
+				require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+			}
+
+			d, err := NewDiscovery(Config{
+				Tags:  "static",
+				Files: []string{filepath.Join(dir, "*.yaml"), filepath.Join(dir, "*.json")},
+			})
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+			defer cancel()
+
+			in := make(chan []model.Group)
+			go d.Discover(ctx, in)
+
+			for i, expected := range test.expectedGroups(dir) {
+				select {
+				case groups := <-in:
+					assert.Equal(t, expected, groups)
+				case <-ctx.Done():
+					t.Fatalf("timeout waiting for groups #%d", i)
+				}
+				if i == 0 && test.afterStart != nil {
+					test.afterStart(dir)
+				}
+			}
+		})
+	}
+}
+
+func prepareYAMLGroup(file string) model.Group {
+	return &fileGroup{
+		source: fileSource(file),
+		targets: []model.Target{
+			prepareTarget(&StaticTarget{
+				tuid:    "192.0.2.1:9100",
+				Address: "192.0.2.1:9100",
+				File:    file,
+				Labels:  map[string]interface{}{"env": "prod"},
+				Fields: map[string]interface{}{
+					"name": "host1",
+					"tags": []interface{}{"a", "b"},
+				},
+			}),
+			prepareTarget(&StaticTarget{
+				tuid:    "192.0.2.2:9100",
+				Address: "192.0.2.2:9100",
+				File:    file,
+				Fields: map[string]interface{}{
+					"extra": map[string]interface{}{"nested": true},
+				},
+			}),
+		},
+	}
+}
+
+func prepareJSONGroup(file string) model.Group {
+	return &fileGroup{
+		source: fileSource(file),
+		targets: []model.Target{
+			prepareTarget(&StaticTarget{
+				tuid:    "198.51.100.1:80",
+				Address: "198.51.100.1:80",
+				File:    file,
+				Labels:  map[string]interface{}{"dc": "west"},
+			}),
+		},
+	}
+}
+
+func prepareTarget(target *StaticTarget) model.Target {
+	d := Discovery{tags: model.Tags{"static": {}}}
+	d.finalize(target)
+	return target
+}
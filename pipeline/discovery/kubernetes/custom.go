@@ -0,0 +1,219 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/netdata/sd/pipeline/model"
+	"github.com/netdata/sd/pkg/health"
+	"github.com/netdata/sd/pkg/k8s"
+	"github.com/netdata/sd/pkg/log"
+
+	"github.com/rs/zerolog"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+type (
+	customGroup struct {
+		targets []model.Target
+		source  string
+	}
+	CustomTarget struct {
+		model.Base `hash:"ignore"`
+		hash       uint64
+		tuid       string
+		Address    string
+
+		Cluster     string
+		Group       string
+		Version     string
+		Resource    string
+		Kind        string
+		Namespace   string
+		Name        string
+		Annotations map[string]interface{}
+		Labels      map[string]interface{}
+		Object      map[string]interface{}
+	}
+)
+
+func (ct CustomTarget) Hash() uint64 { return ct.hash }
+func (ct CustomTarget) TUID() string { return ct.tuid }
+
+func (cg customGroup) Source() string          { return cg.source }
+func (cg customGroup) Targets() []model.Target { return cg.targets }
+
+// Custom discovers objects of any resource (e.g. custom resources) using an informer of unstructured objects.
+type Custom struct {
+	informer cache.SharedInformer
+	queue    *workqueue.Type
+	gvr      schema.GroupVersionResource
+	cluster  string
+	health   *health.Reporter
+	log      zerolog.Logger
+}
+
+func NewCustom(inf cache.SharedInformer, gvr schema.GroupVersionResource) *Custom {
+	queue := workqueue.NewWithConfig(workqueue.QueueConfig{Name: "custom"})
+	inf.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { enqueue(queue, obj) },
+		UpdateFunc: func(_, obj interface{}) { enqueue(queue, obj) },
+		DeleteFunc: func(obj interface{}) { enqueue(queue, obj) },
+	})
+
+	return &Custom{
+		informer: inf,
+		queue:    queue,
+		gvr:      gvr,
+		health:   health.NewReporter("k8s custom discovery"),
+		log:      log.New("k8s custom discovery"),
+	}
+}
+
+func (c Custom) String() string {
+	return fmt.Sprintf("k8s %s discovery (%s)", RoleCustom, customResourceName(c.gvr))
+}
+
+func (c *Custom) Health() []health.Status {
+	return []health.Status{c.health.Status()}
+}
+
+func (c *Custom) OnHealthChange(fn func()) { c.health.OnChange(fn) }
+
+func (c *Custom) Discover(ctx context.Context, in chan<- []model.Group) {
+	c.log.Info().Msg("instance is started")
+	defer c.log.Info().Msg("instance is stopped")
+	defer c.queue.ShutDown()
+
+	go c.informer.Run(ctx.Done())
+
+	if !k8s.DefaultSyncBackoff.WaitForCacheSync(ctx, c.log, c.health, c.informer) {
+		return
+	}
+
+	go c.run(ctx, in)
+	<-ctx.Done()
+}
+
+func (c *Custom) run(ctx context.Context, in chan<- []model.Group) {
+	initial := newInitialSync(c.queue, c.health)
+
+	for {
+		item, shutdown := c.queue.Get()
+		if shutdown {
+			return
+		}
+
+		func() {
+			defer c.queue.Done(item)
+
+			key := item.(string)
+			namespace, name, err := cache.SplitMetaNamespaceKey(key)
+			if err != nil {
+				return
+			}
+
+			item, exists, err := c.informer.GetStore().GetByKey(key)
+			if err != nil {
+				return
+			}
+
+			if !exists {
+				group := &customGroup{source: clusterSource(c.cluster, customSourceFromNsName(c.gvr, namespace, name))}
+				send(ctx, in, group)
+				return
+			}
+
+			obj, err := toUnstructured(item)
+			if err != nil {
+				return
+			}
+
+			group := c.buildGroup(obj)
+			send(ctx, in, group)
+		}()
+		initial.itemDone()
+	}
+}
+
+func (c Custom) buildGroup(obj *unstructured.Unstructured) model.Group {
+	target := c.buildTarget(obj)
+	if target == nil {
+		return &customGroup{
+			source: clusterSource(c.cluster, customSource(c.gvr, obj)),
+		}
+	}
+	return &customGroup{
+		source:  clusterSource(c.cluster, customSource(c.gvr, obj)),
+		targets: []model.Target{target},
+	}
+}
+
+func (c Custom) buildTarget(obj *unstructured.Unstructured) model.Target {
+	content := obj.DeepCopy().UnstructuredContent()
+	// managed fields, resource version and generation are bookkeeping of the API server, they change on every update.
+	// Status is written by the object controllers, reconciling it must not recreate the target configs.
+	unstructured.RemoveNestedField(content, "metadata", "managedFields")
+	unstructured.RemoveNestedField(content, "metadata", "resourceVersion")
+	unstructured.RemoveNestedField(content, "metadata", "generation")
+	unstructured.RemoveNestedField(content, "status")
+
+	target := &CustomTarget{
+		tuid:        clusterTUID(c.cluster, customTUID(c.gvr, obj)),
+		Cluster:     c.cluster,
+		Group:       c.gvr.Group,
+		Version:     c.gvr.Version,
+		Resource:    c.gvr.Resource,
+		Kind:        obj.GetKind(),
+		Namespace:   obj.GetNamespace(),
+		Name:        obj.GetName(),
+		Annotations: toMapInterface(obj.GetAnnotations()),
+		Labels:      toMapInterface(obj.GetLabels()),
+		Object:      content,
+	}
+
+	hash, err := calcHash(target)
+	if err != nil {
+		return nil
+	}
+	target.hash = hash
+
+	return target
+}
+
+// customResourceName returns the resource name qualified by its group ('servicemonitors.monitoring.coreos.com').
+func customResourceName(gvr schema.GroupVersionResource) string {
+	if gvr.Group == "" {
+		return gvr.Resource
+	}
+	return gvr.Resource + "." + gvr.Group
+}
+
+func customTUID(gvr schema.GroupVersionResource, obj *unstructured.Unstructured) string {
+	if obj.GetNamespace() == "" {
+		return fmt.Sprintf("%s_%s", customResourceName(gvr), obj.GetName())
+	}
+	return fmt.Sprintf("%s_%s_%s", customResourceName(gvr), obj.GetNamespace(), obj.GetName())
+}
+
+func customSourceFromNsName(gvr schema.GroupVersionResource, namespace, name string) string {
+	if namespace == "" {
+		return "k8s/custom/" + customResourceName(gvr) + "/" + name
+	}
+	return "k8s/custom/" + customResourceName(gvr) + "/" + namespace + "/" + name
+}
+
+func customSource(gvr schema.GroupVersionResource, obj *unstructured.Unstructured) string {
+	return customSourceFromNsName(gvr, obj.GetNamespace(), obj.GetName())
+}
+
+func toUnstructured(item interface{}) (*unstructured.Unstructured, error) {
+	obj, ok := item.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("received unexpected object type: %T", item)
+	}
+	return obj, nil
+}
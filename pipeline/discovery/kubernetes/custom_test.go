@@ -0,0 +1,269 @@
+package kubernetes
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/netdata/sd/pipeline/model"
+	"github.com/netdata/sd/pkg/health"
+	"github.com/netdata/sd/pkg/k8s"
+
+	"github.com/stretchr/testify/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var (
+	serviceMonitorGVR = schema.GroupVersionResource{
+		Group:    "monitoring.coreos.com",
+		Version:  "v1",
+		Resource: "servicemonitors",
+	}
+	clusterIssuerGVR = schema.GroupVersionResource{
+		Group:    "cert-manager.io",
+		Version:  "v1",
+		Resource: "clusterissuers",
+	}
+)
+
+func TestCustom_String(t *testing.T) {
+	assert.NotEmpty(t, Custom{gvr: serviceMonitorGVR}.String())
+}
+
+func TestCustomGroup_Source(t *testing.T) {
+	httpd := newHTTPDServiceMonitor()
+	issuer := newClusterIssuer("letsencrypt")
+
+	assert.Equal(t,
+		"k8s/custom/servicemonitors.monitoring.coreos.com/default/httpd",
+		prepareCustomGroup(serviceMonitorGVR, httpd).Source())
+	assert.Equal(t,
+		"k8s/custom/clusterissuers.cert-manager.io/letsencrypt",
+		prepareCustomGroup(clusterIssuerGVR, issuer).Source())
+}
+
+func TestCustomTarget_TUID(t *testing.T) {
+	httpd := newHTTPDServiceMonitor()
+	issuer := newClusterIssuer("letsencrypt")
+	podMonitorGVR := schema.GroupVersionResource{Group: "monitoring.coreos.com", Version: "v1", Resource: "podmonitors"}
+
+	assert.Equal(t,
+		"servicemonitors.monitoring.coreos.com_default_httpd",
+		prepareCustomGroup(serviceMonitorGVR, httpd).Targets()[0].TUID())
+	assert.Equal(t,
+		"podmonitors.monitoring.coreos.com_default_httpd",
+		prepareCustomGroup(podMonitorGVR, httpd).Targets()[0].TUID())
+	assert.Equal(t,
+		"clusterissuers.cert-manager.io_letsencrypt",
+		prepareCustomGroup(clusterIssuerGVR, issuer).Targets()[0].TUID())
+}
+
+func TestCustom_Discover(t *testing.T) {
+	tests := map[string]func() discoverySim{
+		"ADD: objects exist before run": func() discoverySim {
+			httpd, nginx := newHTTPDServiceMonitor(), newNGINXServiceMonitor()
+			discovery, _ := prepareCustomDiscovery(serviceMonitorGVR, httpd, nginx)
+
+			sim := discoverySim{
+				discovery:        discovery,
+				sortBeforeVerify: true,
+				expectedGroups: []model.Group{
+					prepareCustomGroup(serviceMonitorGVR, httpd),
+					prepareCustomGroup(serviceMonitorGVR, nginx),
+				},
+			}
+			return sim
+		},
+		"ADD: object added after sync": func() discoverySim {
+			httpd, nginx := newHTTPDServiceMonitor(), newNGINXServiceMonitor()
+			discovery, client := prepareCustomDiscovery(serviceMonitorGVR, httpd)
+			smClient := client.Resource(serviceMonitorGVR).Namespace("default")
+
+			sim := discoverySim{
+				discovery: discovery,
+				runAfterSync: func(ctx context.Context) {
+					time.Sleep(time.Millisecond * 50)
+					_, _ = smClient.Create(ctx, nginx, metav1.CreateOptions{})
+				},
+				expectedGroups: []model.Group{
+					prepareCustomGroup(serviceMonitorGVR, httpd),
+					prepareCustomGroup(serviceMonitorGVR, nginx),
+				},
+			}
+			return sim
+		},
+		"UPDATE: object spec changed after sync": func() discoverySim {
+			httpd := newHTTPDServiceMonitor()
+			updated := httpd.DeepCopy()
+			_ = unstructured.SetNestedSlice(updated.Object, []interface{}{
+				map[string]interface{}{"port": "http", "interval": "30s"},
+			}, "spec", "endpoints")
+			discovery, client := prepareCustomDiscovery(serviceMonitorGVR, httpd)
+			smClient := client.Resource(serviceMonitorGVR).Namespace("default")
+
+			sim := discoverySim{
+				discovery: discovery,
+				runAfterSync: func(ctx context.Context) {
+					time.Sleep(time.Millisecond * 50)
+					_, _ = smClient.Update(ctx, updated, metav1.UpdateOptions{})
+				},
+				expectedGroups: []model.Group{
+					prepareCustomGroup(serviceMonitorGVR, httpd),
+					prepareCustomGroup(serviceMonitorGVR, updated),
+				},
+			}
+			return sim
+		},
+		"DELETE: object removed after sync": func() discoverySim {
+			httpd := newHTTPDServiceMonitor()
+			discovery, client := prepareCustomDiscovery(serviceMonitorGVR, httpd)
+			smClient := client.Resource(serviceMonitorGVR).Namespace("default")
+
+			sim := discoverySim{
+				discovery: discovery,
+				runAfterSync: func(ctx context.Context) {
+					time.Sleep(time.Millisecond * 50)
+					_ = smClient.Delete(ctx, httpd.GetName(), metav1.DeleteOptions{})
+				},
+				expectedGroups: []model.Group{
+					prepareCustomGroup(serviceMonitorGVR, httpd),
+					prepareEmptyCustomGroup(serviceMonitorGVR, httpd),
+				},
+			}
+			return sim
+		},
+		"ADD: cluster-scoped objects": func() discoverySim {
+			issuer := newClusterIssuer("letsencrypt")
+			discovery, _ := prepareCustomDiscovery(clusterIssuerGVR, issuer)
+
+			sim := discoverySim{
+				discovery: discovery,
+				expectedGroups: []model.Group{
+					prepareCustomGroup(clusterIssuerGVR, issuer),
+				},
+			}
+			return sim
+		},
+	}
+
+	for name, sim := range tests {
+		t.Run(name, func(t *testing.T) { sim().run(t) })
+	}
+}
+
+func TestCustom_buildTarget(t *testing.T) {
+	c := Custom{gvr: serviceMonitorGVR}
+	httpd := newHTTPDServiceMonitor()
+	httpd.SetResourceVersion("1")
+	updated := httpd.DeepCopy()
+	updated.SetResourceVersion("2")
+
+	target := c.buildTarget(httpd)
+	assert.Equal(t, target.Hash(), c.buildTarget(updated).Hash(), "resource version must not change the hash")
+	_, found, _ := unstructured.NestedString(target.(*CustomTarget).Object, "metadata", "resourceVersion")
+	assert.False(t, found)
+
+	statusUpdated := httpd.DeepCopy()
+	statusUpdated.SetGeneration(2)
+	_ = unstructured.SetNestedField(statusUpdated.Object, "True", "status", "conditions", "ready")
+
+	assert.Equal(t, target.Hash(), c.buildTarget(statusUpdated).Hash(), "status update must not change the hash")
+	_, found, _ = unstructured.NestedMap(c.buildTarget(statusUpdated).(*CustomTarget).Object, "status")
+	assert.False(t, found)
+}
+
+func prepareCustomDiscovery(
+	gvr schema.GroupVersionResource,
+	objects ...runtime.Object,
+) (*Discovery, dynamic.Interface) {
+	listKinds := map[schema.GroupVersionResource]string{
+		serviceMonitorGVR: "ServiceMonitorList",
+		clusterIssuerGVR:  "ClusterIssuerList",
+	}
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds, objects...)
+	discovery := &Discovery{
+		tags:       discoveryTags,
+		namespaces: []string{apiv1.NamespaceAll},
+		role:       RoleCustom,
+		customGVR:  gvr,
+		client:     fake.NewSimpleClientset(),
+		dynClient:  client,
+		informers:  k8s.NewInformers(),
+		started:    make(chan struct{}),
+		health:     health.NewReporter("k8s discovery manager"),
+	}
+	return discovery, client
+}
+
+func newHTTPDServiceMonitor() *unstructured.Unstructured {
+	return newServiceMonitor("httpd")
+}
+
+func newNGINXServiceMonitor() *unstructured.Unstructured {
+	return newServiceMonitor("nginx")
+}
+
+func newServiceMonitor(app string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "monitoring.coreos.com/v1",
+		"kind":       "ServiceMonitor",
+		"metadata": map[string]interface{}{
+			"name":        app,
+			"namespace":   "default",
+			"labels":      map[string]interface{}{"app": app},
+			"annotations": map[string]interface{}{"phase": "prod"},
+		},
+		"spec": map[string]interface{}{
+			"selector": map[string]interface{}{
+				"matchLabels": map[string]interface{}{"app": app},
+			},
+			"endpoints": []interface{}{
+				map[string]interface{}{"port": "http", "path": "/metrics"},
+			},
+		},
+	}}
+}
+
+func newClusterIssuer(name string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "cert-manager.io/v1",
+		"kind":       "ClusterIssuer",
+		"metadata": map[string]interface{}{
+			"name": name,
+		},
+		"spec": map[string]interface{}{
+			"acme": map[string]interface{}{"server": "https://acme-v02.api.letsencrypt.org/directory"},
+		},
+	}}
+}
+
+func prepareEmptyCustomGroup(gvr schema.GroupVersionResource, obj *unstructured.Unstructured) *customGroup {
+	return &customGroup{source: customSource(gvr, obj)}
+}
+
+func prepareCustomGroup(gvr schema.GroupVersionResource, obj *unstructured.Unstructured) *customGroup {
+	group := prepareEmptyCustomGroup(gvr, obj)
+	target := &CustomTarget{
+		tuid:        customTUID(gvr, obj),
+		Group:       gvr.Group,
+		Version:     gvr.Version,
+		Resource:    gvr.Resource,
+		Kind:        obj.GetKind(),
+		Namespace:   obj.GetNamespace(),
+		Name:        obj.GetName(),
+		Annotations: toMapInterface(obj.GetAnnotations()),
+		Labels:      toMapInterface(obj.GetLabels()),
+		Object:      obj.DeepCopy().Object,
+	}
+	target.hash = mustCalcHash(target)
+	target.Tags().Merge(discoveryTags)
+	group.targets = append(group.targets, target)
+	return group
+}
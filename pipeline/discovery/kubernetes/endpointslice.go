@@ -0,0 +1,397 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/netdata/sd/pipeline/model"
+	"github.com/netdata/sd/pkg/health"
+	"github.com/netdata/sd/pkg/k8s"
+	"github.com/netdata/sd/pkg/log"
+
+	"github.com/rs/zerolog"
+	apiv1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+type (
+	endpointSliceGroup struct {
+		targets []model.Target
+		source  string
+	}
+	EndpointSliceTarget struct {
+		model.Base `hash:"ignore"`
+		hash       uint64
+		tuid       string
+		Address    string
+
+		Cluster     string
+		Namespace   string
+		Name        string
+		ServiceName string
+		Annotations map[string]interface{}
+		Labels      map[string]interface{}
+		AddressType string
+
+		EndpointAddress string
+		Hostname        string
+		NodeName        string
+		Zone            string
+		Ready           bool
+		Serving         bool
+		Terminating     bool
+
+		Port         string
+		PortName     string
+		PortProtocol string
+		AppProtocol  string
+
+		TargetRefKind  string
+		TargetRefName  string
+		PodName        string
+		PodLabels      map[string]interface{}
+		PodAnnotations map[string]interface{}
+	}
+)
+
+func (et EndpointSliceTarget) Hash() uint64 { return et.hash }
+func (et EndpointSliceTarget) TUID() string { return et.tuid }
+
+func (eg endpointSliceGroup) Source() string          { return eg.source }
+func (eg endpointSliceGroup) Targets() []model.Target { return eg.targets }
+
+type EndpointSlice struct {
+	informer    cache.SharedInformer
+	podInformer cache.SharedInformer
+	queue       *workqueue.Type
+	cluster     string
+	health      *health.Reporter
+	log         zerolog.Logger
+}
+
+func NewEndpointSlice(es, pod cache.SharedInformer) *EndpointSlice {
+	queue := workqueue.NewWithConfig(workqueue.QueueConfig{Name: "endpointslice"})
+	es.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { enqueue(queue, obj) },
+		UpdateFunc: func(_, obj interface{}) { enqueue(queue, obj) },
+		DeleteFunc: func(obj interface{}) { enqueue(queue, obj) },
+	})
+	if pod == nil {
+		panic("nil pod informer")
+	}
+
+	e := &EndpointSlice{
+		informer:    es,
+		podInformer: pod,
+		queue:       queue,
+		health:      health.NewReporter("k8s endpointslice discovery"),
+		log:         log.New("k8s endpointslice discovery"),
+	}
+	pod.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { e.enqueuePodSlices(obj) },
+		UpdateFunc: func(_, obj interface{}) { e.enqueuePodSlices(obj) },
+		DeleteFunc: func(obj interface{}) { e.enqueuePodSlices(obj) },
+	})
+	return e
+}
+
+func (e EndpointSlice) String() string {
+	return fmt.Sprintf("k8s %s discovery", RoleEndpointSlice)
+}
+
+func (e *EndpointSlice) Health() []health.Status {
+	return []health.Status{e.health.Status()}
+}
+
+func (e *EndpointSlice) OnHealthChange(fn func()) { e.health.OnChange(fn) }
+
+func (e *EndpointSlice) Discover(ctx context.Context, in chan<- []model.Group) {
+	e.log.Info().Msg("instance is started")
+	defer e.log.Info().Msg("instance is stopped")
+	defer e.queue.ShutDown()
+
+	go e.informer.Run(ctx.Done())
+	go e.podInformer.Run(ctx.Done())
+
+	if !k8s.DefaultSyncBackoff.WaitForCacheSync(ctx, e.log, e.health, e.informer, e.podInformer) {
+		return
+	}
+
+	go e.run(ctx, in)
+	<-ctx.Done()
+}
+
+func (e *EndpointSlice) run(ctx context.Context, in chan<- []model.Group) {
+	initial := newInitialSync(e.queue, e.health)
+
+	for {
+		item, shutdown := e.queue.Get()
+		if shutdown {
+			return
+		}
+
+		func() {
+			defer e.queue.Done(item)
+
+			key := item.(string)
+			namespace, name, err := cache.SplitMetaNamespaceKey(key)
+			if err != nil {
+				return
+			}
+
+			item, exists, err := e.informer.GetStore().GetByKey(key)
+			if err != nil {
+				return
+			}
+
+			if !exists {
+				group := &endpointSliceGroup{source: clusterSource(e.cluster, endpointSliceSourceFromNsName(namespace, name))}
+				send(ctx, in, group)
+				return
+			}
+
+			es, err := toEndpointSlice(item)
+			if err != nil {
+				return
+			}
+
+			group := e.buildGroup(es)
+			send(ctx, in, group)
+		}()
+		initial.itemDone()
+	}
+}
+
+// enqueuePodSlices enqueues every slice that has an endpoint referencing the pod,
+// so that the resolved pod fields stay up to date.
+func (e *EndpointSlice) enqueuePodSlices(obj interface{}) {
+	if d, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = d.Obj
+	}
+	pod, err := toPod(obj)
+	if err != nil {
+		return
+	}
+	for _, item := range e.podSlices(pod) {
+		es, err := toEndpointSlice(item)
+		if err != nil || es.Namespace != pod.Namespace {
+			continue
+		}
+		for _, ep := range es.Endpoints {
+			if isPodRef(ep.TargetRef, pod.Namespace, pod.Name) {
+				enqueue(e.queue, es)
+				break
+			}
+		}
+	}
+}
+
+// podSlices returns the slices referencing the pod using the slice informer pod index,
+// all the slices are returned if the informer has no such index.
+func (e *EndpointSlice) podSlices(pod *apiv1.Pod) []interface{} {
+	if inf, ok := e.informer.(cache.SharedIndexInformer); ok {
+		if items, err := inf.GetIndexer().ByIndex(endpointSlicePodIndex, pod.Namespace+"/"+pod.Name); err == nil {
+			return items
+		}
+	}
+	return e.informer.GetStore().List()
+}
+
+// endpointSlicePodIndex is the name of the slice informers index by the pods ('namespace/name') the endpoints refer to.
+const endpointSlicePodIndex = "endpoints.targetRef.pod"
+
+func endpointSlicePodIndexFunc(obj interface{}) ([]string, error) {
+	es, err := toEndpointSlice(obj)
+	if err != nil {
+		return nil, nil
+	}
+	var keys []string
+	seen := make(map[string]bool)
+	for _, ep := range es.Endpoints {
+		ref := ep.TargetRef
+		if ref == nil || ref.Kind != "Pod" || ref.Name == "" {
+			continue
+		}
+		namespace := es.Namespace
+		if ref.Namespace != "" {
+			namespace = ref.Namespace
+		}
+		if key := namespace + "/" + ref.Name; !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (e EndpointSlice) buildGroup(es *discoveryv1.EndpointSlice) model.Group {
+	if len(es.Endpoints) == 0 {
+		return &endpointSliceGroup{
+			source: clusterSource(e.cluster, endpointSliceSource(es)),
+		}
+	}
+	return &endpointSliceGroup{
+		source:  clusterSource(e.cluster, endpointSliceSource(es)),
+		targets: e.buildTargets(es),
+	}
+}
+
+func (e EndpointSlice) buildTargets(es *discoveryv1.EndpointSlice) (targets []model.Target) {
+	for _, ep := range es.Endpoints {
+		pod := e.lookupPod(es.Namespace, ep.TargetRef)
+
+		for _, addr := range ep.Addresses {
+			if len(es.Ports) == 0 {
+				target := e.newTarget(es, ep, pod, addr)
+				target.tuid = clusterTUID(e.cluster, endpointSliceTUID(es, addr))
+				target.Address = addr
+				hash, err := calcHash(target)
+				if err != nil {
+					continue
+				}
+				target.hash = hash
+
+				targets = append(targets, target)
+				continue
+			}
+
+			for _, port := range es.Ports {
+				if port.Port == nil {
+					continue
+				}
+				portNum := strconv.FormatInt(int64(*port.Port), 10)
+				target := e.newTarget(es, ep, pod, addr)
+				target.tuid = clusterTUID(e.cluster, endpointSliceTUIDWithPort(es, addr, port))
+				target.Address = net.JoinHostPort(addr, portNum)
+				target.Port = portNum
+				target.PortName = derefString(port.Name)
+				target.PortProtocol = string(derefProtocol(port.Protocol))
+				target.AppProtocol = derefString(port.AppProtocol)
+				hash, err := calcHash(target)
+				if err != nil {
+					continue
+				}
+				target.hash = hash
+
+				targets = append(targets, target)
+			}
+		}
+	}
+	return targets
+}
+
+func (e EndpointSlice) newTarget(es *discoveryv1.EndpointSlice, ep discoveryv1.Endpoint, pod *apiv1.Pod, addr string) *EndpointSliceTarget {
+	// A nil 'ready' condition indicates an unknown state and should be interpreted as ready.
+	// A nil 'serving' condition should be interpreted the same way as 'ready'.
+	ready := ep.Conditions.Ready == nil || *ep.Conditions.Ready
+	serving := ready
+	if ep.Conditions.Serving != nil {
+		serving = *ep.Conditions.Serving
+	}
+
+	target := &EndpointSliceTarget{
+		Cluster:         e.cluster,
+		Namespace:       es.Namespace,
+		Name:            es.Name,
+		ServiceName:     es.Labels[discoveryv1.LabelServiceName],
+		Annotations:     toMapInterface(es.Annotations),
+		Labels:          toMapInterface(es.Labels),
+		AddressType:     string(es.AddressType),
+		EndpointAddress: addr,
+		Hostname:        derefString(ep.Hostname),
+		NodeName:        derefString(ep.NodeName),
+		Zone:            derefString(ep.Zone),
+		Ready:           ready,
+		Serving:         serving,
+		Terminating:     ep.Conditions.Terminating != nil && *ep.Conditions.Terminating,
+	}
+	if ep.TargetRef != nil {
+		target.TargetRefKind = ep.TargetRef.Kind
+		target.TargetRefName = ep.TargetRef.Name
+	}
+	if pod != nil {
+		target.PodName = pod.Name
+		target.PodLabels = toMapInterface(pod.Labels)
+		target.PodAnnotations = toMapInterface(pod.Annotations)
+	}
+	return target
+}
+
+func (e EndpointSlice) lookupPod(namespace string, ref *apiv1.ObjectReference) *apiv1.Pod {
+	if ref == nil || ref.Kind != "Pod" || ref.Name == "" {
+		return nil
+	}
+	if ref.Namespace != "" {
+		namespace = ref.Namespace
+	}
+	item, exist, err := e.podInformer.GetStore().GetByKey(namespace + "/" + ref.Name)
+	if err != nil || !exist {
+		return nil
+	}
+	pod, err := toPod(item)
+	if err != nil {
+		return nil
+	}
+	return pod
+}
+
+func isPodRef(ref *apiv1.ObjectReference, namespace, name string) bool {
+	if ref == nil || ref.Kind != "Pod" || ref.Name != name {
+		return false
+	}
+	return ref.Namespace == "" || ref.Namespace == namespace
+}
+
+func endpointSliceTUID(es *discoveryv1.EndpointSlice, addr string) string {
+	return fmt.Sprintf("%s_%s_%s",
+		es.Namespace,
+		es.Name,
+		addr,
+	)
+}
+
+func endpointSliceTUIDWithPort(es *discoveryv1.EndpointSlice, addr string, port discoveryv1.EndpointPort) string {
+	return fmt.Sprintf("%s_%s_%s_%s_%s",
+		es.Namespace,
+		es.Name,
+		addr,
+		strings.ToLower(string(derefProtocol(port.Protocol))),
+		strconv.FormatInt(int64(*port.Port), 10),
+	)
+}
+
+func endpointSliceSourceFromNsName(namespace, name string) string {
+	return "k8s/endpointslice/" + namespace + "/" + name
+}
+
+func endpointSliceSource(es *discoveryv1.EndpointSlice) string {
+	return endpointSliceSourceFromNsName(es.Namespace, es.Name)
+}
+
+func toEndpointSlice(item interface{}) (*discoveryv1.EndpointSlice, error) {
+	es, ok := item.(*discoveryv1.EndpointSlice)
+	if !ok {
+		return nil, fmt.Errorf("received unexpected object type: %T", item)
+	}
+	return es, nil
+}
+
+func derefString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func derefProtocol(p *apiv1.Protocol) apiv1.Protocol {
+	if p == nil {
+		// TCP is the default protocol.
+		return apiv1.ProtocolTCP
+	}
+	return *p
+}
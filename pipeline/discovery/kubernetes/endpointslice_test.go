@@ -0,0 +1,374 @@
+package kubernetes
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/netdata/sd/pipeline/model"
+
+	"github.com/stretchr/testify/assert"
+	apiv1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestEndpointSliceGroup_Source(t *testing.T) {
+	tests := map[string]struct {
+		sim            func() discoverySim
+		expectedSource []string
+	}{
+		"slices with multiple ports": {
+			sim: func() discoverySim {
+				httpd, nginx := newHTTPDEndpointSlice(), newNGINXEndpointSlice()
+				discovery, _ := prepareAllNsDiscovery(RoleEndpointSlice, httpd, nginx)
+
+				sim := discoverySim{
+					discovery: discovery,
+					expectedGroups: []model.Group{
+						prepareEndpointSliceGroup(httpd, nil),
+						prepareEndpointSliceGroup(nginx, nil),
+					},
+				}
+				return sim
+			},
+			expectedSource: []string{
+				"k8s/endpointslice/default/httpd-cluster-ip-service-abcde",
+				"k8s/endpointslice/default/nginx-cluster-ip-service-fghij",
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			sim := test.sim()
+			var actual []string
+			for _, group := range sim.run(t) {
+				actual = append(actual, group.Source())
+			}
+
+			assert.Equal(t, test.expectedSource, actual)
+		})
+	}
+}
+
+func TestEndpointSliceGroup_Targets(t *testing.T) {
+	tests := map[string]struct {
+		sim                func() discoverySim
+		expectedNumTargets int
+	}{
+		"slices with multiple ports": {
+			sim: func() discoverySim {
+				httpd, nginx := newHTTPDEndpointSlice(), newNGINXEndpointSlice()
+				discovery, _ := prepareAllNsDiscovery(RoleEndpointSlice, httpd, nginx)
+
+				sim := discoverySim{
+					discovery: discovery,
+					expectedGroups: []model.Group{
+						prepareEndpointSliceGroup(httpd, nil),
+						prepareEndpointSliceGroup(nginx, nil),
+					},
+				}
+				return sim
+			},
+			expectedNumTargets: 4,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			sim := test.sim()
+			var actual int
+			for _, group := range sim.run(t) {
+				actual += len(group.Targets())
+			}
+
+			assert.Equal(t, test.expectedNumTargets, actual)
+		})
+	}
+}
+
+func TestEndpointSliceTarget_TUID(t *testing.T) {
+	tests := map[string]struct {
+		sim          func() discoverySim
+		expectedTUID []string
+	}{
+		"slices with multiple ports": {
+			sim: func() discoverySim {
+				httpd, nginx := newHTTPDEndpointSlice(), newNGINXEndpointSlice()
+				discovery, _ := prepareAllNsDiscovery(RoleEndpointSlice, httpd, nginx)
+
+				sim := discoverySim{
+					discovery: discovery,
+					expectedGroups: []model.Group{
+						prepareEndpointSliceGroup(httpd, nil),
+						prepareEndpointSliceGroup(nginx, nil),
+					},
+				}
+				return sim
+			},
+			expectedTUID: []string{
+				"default_httpd-cluster-ip-service-abcde_172.17.0.1_tcp_80",
+				"default_httpd-cluster-ip-service-abcde_172.17.0.1_tcp_443",
+				"default_nginx-cluster-ip-service-fghij_172.17.0.2_tcp_80",
+				"default_nginx-cluster-ip-service-fghij_172.17.0.2_tcp_443",
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			sim := test.sim()
+			var actual []string
+			for _, group := range sim.run(t) {
+				for _, tg := range group.Targets() {
+					actual = append(actual, tg.TUID())
+				}
+			}
+
+			assert.Equal(t, test.expectedTUID, actual)
+		})
+	}
+}
+
+func TestNewEndpointSlice(t *testing.T) {
+	tests := map[string]struct {
+		esInf     cache.SharedInformer
+		podInf    cache.SharedInformer
+		wantPanic bool
+	}{
+		"valid informers": {
+			esInf:  cache.NewSharedInformer(nil, &discoveryv1.EndpointSlice{}, resyncPeriod),
+			podInf: cache.NewSharedInformer(nil, &apiv1.Pod{}, resyncPeriod),
+		},
+		"nil informers": {wantPanic: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if test.wantPanic {
+				assert.Panics(t, func() { NewEndpointSlice(nil, nil) })
+			} else {
+				assert.IsType(t, &EndpointSlice{}, NewEndpointSlice(test.esInf, test.podInf))
+			}
+		})
+	}
+}
+
+func TestEndpointSlice_String(t *testing.T) {
+	assert.NotEmpty(t, EndpointSlice{}.String())
+}
+
+func TestEndpointSlice_Discover(t *testing.T) {
+	tests := map[string]func() discoverySim{
+		"ADD: slices exist before run": func() discoverySim {
+			httpd, nginx := newHTTPDEndpointSlice(), newNGINXEndpointSlice()
+			discovery, _ := prepareAllNsDiscovery(RoleEndpointSlice, httpd, nginx)
+
+			sim := discoverySim{
+				discovery: discovery,
+				expectedGroups: []model.Group{
+					prepareEndpointSliceGroup(httpd, nil),
+					prepareEndpointSliceGroup(nginx, nil),
+				},
+			}
+			return sim
+		},
+		"ADD: slices with backing pods": func() discoverySim {
+			httpd, nginx := newHTTPDEndpointSlice(), newNGINXEndpointSlice()
+			httpdPod, nginxPod := newHTTPDPod(), newNGINXPod()
+			discovery, _ := prepareAllNsDiscovery(RoleEndpointSlice, httpd, nginx, httpdPod, nginxPod)
+
+			sim := discoverySim{
+				discovery: discovery,
+				expectedGroups: []model.Group{
+					prepareEndpointSliceGroup(httpd, httpdPod),
+					prepareEndpointSliceGroup(nginx, nginxPod),
+				},
+			}
+			return sim
+		},
+		"ADD: slices exist before run and add after sync": func() discoverySim {
+			httpd, nginx := newHTTPDEndpointSlice(), newNGINXEndpointSlice()
+			discovery, clientset := prepareAllNsDiscovery(RoleEndpointSlice, httpd)
+			esClient := clientset.DiscoveryV1().EndpointSlices("default")
+
+			sim := discoverySim{
+				discovery: discovery,
+				runAfterSync: func(ctx context.Context) {
+					_, _ = esClient.Create(ctx, nginx, metav1.CreateOptions{})
+				},
+				expectedGroups: []model.Group{
+					prepareEndpointSliceGroup(httpd, nil),
+					prepareEndpointSliceGroup(nginx, nil),
+				},
+			}
+			return sim
+		},
+		"DELETE: remove slices after sync": func() discoverySim {
+			httpd, nginx := newHTTPDEndpointSlice(), newNGINXEndpointSlice()
+			discovery, clientset := prepareAllNsDiscovery(RoleEndpointSlice, httpd, nginx)
+			esClient := clientset.DiscoveryV1().EndpointSlices("default")
+
+			sim := discoverySim{
+				discovery: discovery,
+				runAfterSync: func(ctx context.Context) {
+					time.Sleep(time.Millisecond * 50)
+					_ = esClient.Delete(ctx, httpd.Name, metav1.DeleteOptions{})
+					_ = esClient.Delete(ctx, nginx.Name, metav1.DeleteOptions{})
+				},
+				expectedGroups: []model.Group{
+					prepareEndpointSliceGroup(httpd, nil),
+					prepareEndpointSliceGroup(nginx, nil),
+					prepareEmptyEndpointSliceGroup(httpd),
+					prepareEmptyEndpointSliceGroup(nginx),
+				},
+			}
+			return sim
+		},
+		"UPDATE: backing pod added after sync": func() discoverySim {
+			httpd := newHTTPDEndpointSlice()
+			httpdPod := newHTTPDPod()
+			discovery, clientset := prepareAllNsDiscovery(RoleEndpointSlice, httpd)
+			podClient := clientset.CoreV1().Pods("default")
+
+			sim := discoverySim{
+				discovery: discovery,
+				runAfterSync: func(ctx context.Context) {
+					time.Sleep(time.Millisecond * 50)
+					_, _ = podClient.Create(ctx, httpdPod, metav1.CreateOptions{})
+				},
+				expectedGroups: []model.Group{
+					prepareEndpointSliceGroup(httpd, nil),
+					prepareEndpointSliceGroup(httpd, httpdPod),
+				},
+			}
+			return sim
+		},
+		"ADD: slices without endpoints": func() discoverySim {
+			httpd, nginx := newHTTPDEndpointSlice(), newNGINXEndpointSlice()
+			httpd.Endpoints = nil
+			nginx.Endpoints = nil
+			discovery, _ := prepareAllNsDiscovery(RoleEndpointSlice, httpd, nginx)
+
+			sim := discoverySim{
+				discovery: discovery,
+				expectedGroups: []model.Group{
+					prepareEmptyEndpointSliceGroup(httpd),
+					prepareEmptyEndpointSliceGroup(nginx),
+				},
+			}
+			return sim
+		},
+	}
+
+	for name, sim := range tests {
+		t.Run(name, func(t *testing.T) { sim().run(t) })
+	}
+}
+
+func TestEndpointSlicePodIndexFunc(t *testing.T) {
+	es := newHTTPDEndpointSlice()
+	nginxPod := newNGINXPod()
+	es.Endpoints = append(es.Endpoints,
+		discoveryv1.Endpoint{TargetRef: &apiv1.ObjectReference{Kind: "Pod", Name: nginxPod.Name}},
+		discoveryv1.Endpoint{TargetRef: &apiv1.ObjectReference{Kind: "Node", Name: "m01"}},
+		discoveryv1.Endpoint{},
+	)
+
+	keys, err := endpointSlicePodIndexFunc(es)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"default/httpd-dd95c4d68-5bkwl", "default/" + nginxPod.Name}, keys)
+}
+
+func newHTTPDEndpointSlice() *discoveryv1.EndpointSlice {
+	pod := newHTTPDPod()
+	return newEndpointSlice("httpd-cluster-ip-service-abcde", "httpd-cluster-ip-service", pod)
+}
+
+func newNGINXEndpointSlice() *discoveryv1.EndpointSlice {
+	pod := newNGINXPod()
+	return newEndpointSlice("nginx-cluster-ip-service-fghij", "nginx-cluster-ip-service", pod)
+}
+
+func newEndpointSlice(name, svcName string, pod *apiv1.Pod) *discoveryv1.EndpointSlice {
+	ready, serving, terminating := true, true, false
+	httpName, httpsName := "http", "https"
+	httpPort, httpsPort := int32(80), int32(443)
+	tcp := apiv1.ProtocolTCP
+	zone := "zone-a"
+	return &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			Annotations: map[string]string{"phase": "prod"},
+			Labels:      map[string]string{discoveryv1.LabelServiceName: svcName},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints: []discoveryv1.Endpoint{
+			{
+				Addresses: []string{pod.Status.PodIP},
+				Conditions: discoveryv1.EndpointConditions{
+					Ready:       &ready,
+					Serving:     &serving,
+					Terminating: &terminating,
+				},
+				TargetRef: &apiv1.ObjectReference{Kind: "Pod", Name: pod.Name, Namespace: pod.Namespace},
+				NodeName:  &pod.Spec.NodeName,
+				Zone:      &zone,
+			},
+		},
+		Ports: []discoveryv1.EndpointPort{
+			{Name: &httpName, Protocol: &tcp, Port: &httpPort},
+			{Name: &httpsName, Protocol: &tcp, Port: &httpsPort},
+		},
+	}
+}
+
+func prepareEmptyEndpointSliceGroup(es *discoveryv1.EndpointSlice) *endpointSliceGroup {
+	return &endpointSliceGroup{source: endpointSliceSource(es)}
+}
+
+func prepareEndpointSliceGroup(es *discoveryv1.EndpointSlice, pod *apiv1.Pod) *endpointSliceGroup {
+	group := prepareEmptyEndpointSliceGroup(es)
+	for _, ep := range es.Endpoints {
+		for _, addr := range ep.Addresses {
+			for _, port := range es.Ports {
+				portNum := strconv.FormatInt(int64(*port.Port), 10)
+				target := &EndpointSliceTarget{
+					tuid:            endpointSliceTUIDWithPort(es, addr, port),
+					Address:         net.JoinHostPort(addr, portNum),
+					Namespace:       es.Namespace,
+					Name:            es.Name,
+					ServiceName:     es.Labels[discoveryv1.LabelServiceName],
+					Annotations:     toMapInterface(es.Annotations),
+					Labels:          toMapInterface(es.Labels),
+					AddressType:     string(es.AddressType),
+					EndpointAddress: addr,
+					NodeName:        *ep.NodeName,
+					Zone:            *ep.Zone,
+					Ready:           *ep.Conditions.Ready,
+					Serving:         *ep.Conditions.Serving,
+					Terminating:     *ep.Conditions.Terminating,
+					Port:            portNum,
+					PortName:        *port.Name,
+					PortProtocol:    string(*port.Protocol),
+					TargetRefKind:   ep.TargetRef.Kind,
+					TargetRefName:   ep.TargetRef.Name,
+				}
+				if pod != nil {
+					target.PodName = pod.Name
+					target.PodLabels = toMapInterface(pod.Labels)
+					target.PodAnnotations = toMapInterface(pod.Annotations)
+				}
+				target.hash = mustCalcHash(target)
+				target.Tags().Merge(discoveryTags)
+				group.targets = append(group.targets, target)
+			}
+		}
+	}
+	return group
+}
@@ -0,0 +1,312 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/netdata/sd/pipeline/model"
+	"github.com/netdata/sd/pkg/health"
+	"github.com/netdata/sd/pkg/k8s"
+	"github.com/netdata/sd/pkg/log"
+
+	"github.com/rs/zerolog"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const annotationIngressClass = "kubernetes.io/ingress.class"
+
+type (
+	ingressGroup struct {
+		targets []model.Target
+		source  string
+	}
+	IngressTarget struct {
+		model.Base `hash:"ignore"`
+		hash       uint64
+		tuid       string
+		Address    string
+
+		Cluster      string
+		Namespace    string
+		Name         string
+		Annotations  map[string]interface{}
+		Labels       map[string]interface{}
+		IngressClass string
+
+		Scheme      string
+		Host        string
+		Path        string
+		PathType    string
+		URL         string
+		ServiceName string
+		ServicePort string
+	}
+)
+
+func (it IngressTarget) Hash() uint64 { return it.hash }
+func (it IngressTarget) TUID() string { return it.tuid }
+
+func (ig ingressGroup) Source() string          { return ig.source }
+func (ig ingressGroup) Targets() []model.Target { return ig.targets }
+
+type Ingress struct {
+	informer cache.SharedInformer
+	queue    *workqueue.Type
+	cluster  string
+	health   *health.Reporter
+	log      zerolog.Logger
+}
+
+func NewIngress(inf cache.SharedInformer) *Ingress {
+	queue := workqueue.NewWithConfig(workqueue.QueueConfig{Name: "ingress"})
+	inf.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { enqueue(queue, obj) },
+		UpdateFunc: func(_, obj interface{}) { enqueue(queue, obj) },
+		DeleteFunc: func(obj interface{}) { enqueue(queue, obj) },
+	})
+
+	return &Ingress{
+		informer: inf,
+		queue:    queue,
+		health:   health.NewReporter("k8s ingress discovery"),
+		log:      log.New("k8s ingress discovery"),
+	}
+}
+
+func (i Ingress) String() string {
+	return fmt.Sprintf("k8s %s discovery", RoleIngress)
+}
+
+func (i *Ingress) Health() []health.Status {
+	return []health.Status{i.health.Status()}
+}
+
+func (i *Ingress) OnHealthChange(fn func()) { i.health.OnChange(fn) }
+
+func (i *Ingress) Discover(ctx context.Context, in chan<- []model.Group) {
+	i.log.Info().Msg("instance is started")
+	defer i.log.Info().Msg("instance is stopped")
+	defer i.queue.ShutDown()
+
+	go i.informer.Run(ctx.Done())
+
+	if !k8s.DefaultSyncBackoff.WaitForCacheSync(ctx, i.log, i.health, i.informer) {
+		return
+	}
+
+	go i.run(ctx, in)
+	<-ctx.Done()
+}
+
+func (i *Ingress) run(ctx context.Context, in chan<- []model.Group) {
+	initial := newInitialSync(i.queue, i.health)
+
+	for {
+		item, shutdown := i.queue.Get()
+		if shutdown {
+			return
+		}
+
+		func() {
+			defer i.queue.Done(item)
+
+			key := item.(string)
+			namespace, name, err := cache.SplitMetaNamespaceKey(key)
+			if err != nil {
+				return
+			}
+
+			item, exists, err := i.informer.GetStore().GetByKey(key)
+			if err != nil {
+				return
+			}
+
+			if !exists {
+				group := &ingressGroup{source: clusterSource(i.cluster, ingressSourceFromNsName(namespace, name))}
+				send(ctx, in, group)
+				return
+			}
+
+			ing, err := toIngress(item)
+			if err != nil {
+				return
+			}
+
+			group := i.buildGroup(ing)
+			send(ctx, in, group)
+		}()
+		initial.itemDone()
+	}
+}
+
+func (i Ingress) buildGroup(ing *networkingv1.Ingress) model.Group {
+	if len(ing.Spec.Rules) == 0 && ing.Spec.DefaultBackend == nil {
+		return &ingressGroup{
+			source: clusterSource(i.cluster, ingressSource(ing)),
+		}
+	}
+	return &ingressGroup{
+		source:  clusterSource(i.cluster, ingressSource(ing)),
+		targets: i.buildTargets(ing),
+	}
+}
+
+func (i Ingress) buildTargets(ing *networkingv1.Ingress) (targets []model.Target) {
+	class := ingressClass(ing)
+
+	rules := ing.Spec.Rules
+	if len(rules) == 0 {
+		// the default backend handles all the traffic through the load balancer
+		rules = []networkingv1.IngressRule{{}}
+	}
+	// a scheme/host/path is served by one backend, only the first matching rule path is used
+	seen := make(map[string]bool)
+
+	for _, rule := range rules {
+		host := rule.Host
+		if strings.HasPrefix(host, "*") {
+			// a wildcard host matches many hosts, there is no address to scrape
+			continue
+		}
+		if host == "" {
+			// a rule without a host applies to all inbound HTTP traffic through the load balancer
+			if host = ingressLoadBalancerHost(ing); host == "" {
+				continue
+			}
+		}
+
+		scheme, port := "http", "80"
+		if isIngressTLSHost(ing, rule.Host) {
+			scheme, port = "https", "443"
+		}
+
+		paths := []networkingv1.HTTPIngressPath{{Path: "/"}}
+		if rule.HTTP != nil && len(rule.HTTP.Paths) > 0 {
+			paths = rule.HTTP.Paths
+		} else if ing.Spec.DefaultBackend != nil {
+			// the rule host traffic goes to the default backend
+			paths[0].Backend = *ing.Spec.DefaultBackend
+		}
+
+		for _, path := range paths {
+			svcName, svcPort := ingressBackendService(path.Backend)
+			pathValue := path.Path
+			if pathValue == "" {
+				pathValue = "/"
+			}
+
+			tuid := clusterTUID(i.cluster, ingressTUID(ing, scheme, host, pathValue))
+			if seen[tuid] {
+				continue
+			}
+			seen[tuid] = true
+
+			target := &IngressTarget{
+				tuid:         tuid,
+				Address:      net.JoinHostPort(host, port),
+				Cluster:      i.cluster,
+				Namespace:    ing.Namespace,
+				Name:         ing.Name,
+				Annotations:  toMapInterface(ing.Annotations),
+				Labels:       toMapInterface(ing.Labels),
+				IngressClass: class,
+				Scheme:       scheme,
+				Host:         host,
+				Path:         pathValue,
+				URL:          scheme + "://" + host + pathValue,
+				ServiceName:  svcName,
+				ServicePort:  svcPort,
+			}
+			if path.PathType != nil {
+				target.PathType = string(*path.PathType)
+			}
+			hash, err := calcHash(target)
+			if err != nil {
+				continue
+			}
+			target.hash = hash
+
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+func ingressClass(ing *networkingv1.Ingress) string {
+	if ing.Spec.IngressClassName != nil {
+		return *ing.Spec.IngressClassName
+	}
+	return ing.Annotations[annotationIngressClass]
+}
+
+func ingressLoadBalancerHost(ing *networkingv1.Ingress) string {
+	for _, lb := range ing.Status.LoadBalancer.Ingress {
+		if v := firstNotEmpty(lb.Hostname, lb.IP); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func isIngressTLSHost(ing *networkingv1.Ingress, host string) bool {
+	for _, tls := range ing.Spec.TLS {
+		// TLS section without hosts applies to the wildcard host
+		if len(tls.Hosts) == 0 && host == "" {
+			return true
+		}
+		for _, h := range tls.Hosts {
+			if h == host {
+				return true
+			}
+			if strings.HasPrefix(h, "*.") && host != "" {
+				if idx := strings.IndexByte(host, '.'); idx > 0 && host[idx:] == h[1:] {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// ingressBackendService returns the backend service name and port, they are empty for resource backends.
+func ingressBackendService(backend networkingv1.IngressBackend) (name, port string) {
+	svc := backend.Service
+	if svc == nil {
+		return "", ""
+	}
+	if svc.Port.Number > 0 {
+		return svc.Name, strconv.FormatInt(int64(svc.Port.Number), 10)
+	}
+	return svc.Name, svc.Port.Name
+}
+
+func ingressTUID(ing *networkingv1.Ingress, scheme, host, path string) string {
+	return fmt.Sprintf("%s_%s_%s_%s%s",
+		ing.Namespace,
+		ing.Name,
+		scheme,
+		host,
+		path,
+	)
+}
+
+func ingressSourceFromNsName(namespace, name string) string {
+	return "k8s/ingress/" + namespace + "/" + name
+}
+
+func ingressSource(ing *networkingv1.Ingress) string {
+	return ingressSourceFromNsName(ing.Namespace, ing.Name)
+}
+
+func toIngress(item interface{}) (*networkingv1.Ingress, error) {
+	ing, ok := item.(*networkingv1.Ingress)
+	if !ok {
+		return nil, fmt.Errorf("received unexpected object type: %T", item)
+	}
+	return ing, nil
+}
@@ -0,0 +1,382 @@
+package kubernetes
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/netdata/sd/pipeline/model"
+
+	"github.com/stretchr/testify/assert"
+	apiv1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestIngressGroup_Source(t *testing.T) {
+	tests := map[string]struct {
+		sim            func() discoverySim
+		expectedSource []string
+	}{
+		"ingresses with multiple rules": {
+			sim: func() discoverySim {
+				httpd, nginx := newHTTPDIngress(), newNGINXIngress()
+				discovery, _ := prepareAllNsDiscovery(RoleIngress, httpd, nginx)
+
+				sim := discoverySim{
+					discovery: discovery,
+					expectedGroups: []model.Group{
+						prepareHTTPDIngressGroup(httpd),
+						prepareNGINXIngressGroup(nginx),
+					},
+				}
+				return sim
+			},
+			expectedSource: []string{
+				"k8s/ingress/default/httpd-ingress",
+				"k8s/ingress/default/nginx-ingress",
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			sim := test.sim()
+			var actual []string
+			for _, group := range sim.run(t) {
+				actual = append(actual, group.Source())
+			}
+
+			assert.Equal(t, test.expectedSource, actual)
+		})
+	}
+}
+
+func TestIngressTarget_TUID(t *testing.T) {
+	tests := map[string]struct {
+		sim          func() discoverySim
+		expectedTUID []string
+	}{
+		"ingresses with multiple rules": {
+			sim: func() discoverySim {
+				httpd, nginx := newHTTPDIngress(), newNGINXIngress()
+				discovery, _ := prepareAllNsDiscovery(RoleIngress, httpd, nginx)
+
+				sim := discoverySim{
+					discovery: discovery,
+					expectedGroups: []model.Group{
+						prepareHTTPDIngressGroup(httpd),
+						prepareNGINXIngressGroup(nginx),
+					},
+				}
+				return sim
+			},
+			expectedTUID: []string{
+				"default_httpd-ingress_https_httpd.example.com/",
+				"default_httpd-ingress_https_httpd.example.com/api",
+				"default_nginx-ingress_http_nginx.example.com/",
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			sim := test.sim()
+			var actual []string
+			for _, group := range sim.run(t) {
+				for _, tg := range group.Targets() {
+					actual = append(actual, tg.TUID())
+				}
+			}
+
+			assert.Equal(t, test.expectedTUID, actual)
+		})
+	}
+}
+
+func TestNewIngress(t *testing.T) {
+	tests := map[string]struct {
+		informer  cache.SharedInformer
+		wantPanic bool
+	}{
+		"valid informer": {informer: cache.NewSharedInformer(nil, &networkingv1.Ingress{}, resyncPeriod)},
+		"nil informer":   {wantPanic: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if test.wantPanic {
+				assert.Panics(t, func() { NewIngress(nil) })
+			} else {
+				assert.IsType(t, &Ingress{}, NewIngress(test.informer))
+			}
+		})
+	}
+}
+
+func TestIngress_String(t *testing.T) {
+	assert.NotEmpty(t, Ingress{}.String())
+}
+
+func TestIngress_Discover(t *testing.T) {
+	tests := map[string]func() discoverySim{
+		"ADD: ingresses exist before run": func() discoverySim {
+			httpd, nginx := newHTTPDIngress(), newNGINXIngress()
+			discovery, _ := prepareAllNsDiscovery(RoleIngress, httpd, nginx)
+
+			sim := discoverySim{
+				discovery: discovery,
+				expectedGroups: []model.Group{
+					prepareHTTPDIngressGroup(httpd),
+					prepareNGINXIngressGroup(nginx),
+				},
+			}
+			return sim
+		},
+		"DELETE: remove ingresses after sync": func() discoverySim {
+			httpd, nginx := newHTTPDIngress(), newNGINXIngress()
+			discovery, clientset := prepareAllNsDiscovery(RoleIngress, httpd, nginx)
+			ingClient := clientset.NetworkingV1().Ingresses("default")
+
+			sim := discoverySim{
+				discovery: discovery,
+				runAfterSync: func(ctx context.Context) {
+					time.Sleep(time.Millisecond * 50)
+					_ = ingClient.Delete(ctx, httpd.Name, metav1.DeleteOptions{})
+					_ = ingClient.Delete(ctx, nginx.Name, metav1.DeleteOptions{})
+				},
+				expectedGroups: []model.Group{
+					prepareHTTPDIngressGroup(httpd),
+					prepareNGINXIngressGroup(nginx),
+					prepareEmptyIngressGroup(httpd),
+					prepareEmptyIngressGroup(nginx),
+				},
+			}
+			return sim
+		},
+		"ADD: ingresses without rules": func() discoverySim {
+			httpd, nginx := newHTTPDIngress(), newNGINXIngress()
+			httpd.Spec.Rules = nil
+			nginx.Spec.Rules = nil
+			discovery, _ := prepareAllNsDiscovery(RoleIngress, httpd, nginx)
+
+			sim := discoverySim{
+				discovery: discovery,
+				expectedGroups: []model.Group{
+					prepareEmptyIngressGroup(httpd),
+					prepareEmptyIngressGroup(nginx),
+				},
+			}
+			return sim
+		},
+		"ADD: ingress with only a default backend": func() discoverySim {
+			nginx := newNGINXIngress()
+			nginx.Spec.Rules = nil
+			nginx.Status.LoadBalancer.Ingress = []networkingv1.IngressLoadBalancerIngress{{IP: "192.168.0.10"}}
+			discovery, _ := prepareAllNsDiscovery(RoleIngress, nginx)
+
+			group := prepareEmptyIngressGroup(nginx)
+			group.targets = []model.Target{
+				prepareIngressTarget(nginx, "traefik", "http", "192.168.0.10", "/", "", "nginx-cluster-ip-service", "80"),
+			}
+			sim := discoverySim{
+				discovery: discovery,
+				expectedGroups: []model.Group{
+					group,
+				},
+			}
+			return sim
+		},
+		"ADD: ingress with duplicate hostless rules and paths": func() discoverySim {
+			nginx := newNGINXIngress()
+			exact := networkingv1.PathTypeExact
+			nginx.Status.LoadBalancer.Ingress = []networkingv1.IngressLoadBalancerIngress{{Hostname: "lb.example.com"}}
+			nginx.Spec.Rules = []networkingv1.IngressRule{
+				{},
+				{},
+				{
+					Host: "nginx.example.com",
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{
+							Paths: []networkingv1.HTTPIngressPath{
+								{Path: "/", Backend: newIngressBackend("nginx-cluster-ip-service", 80, "")},
+								{Path: "/", PathType: &exact, Backend: newIngressBackend("nginx-api", 8080, "")},
+							},
+						},
+					},
+				},
+			}
+			discovery, _ := prepareAllNsDiscovery(RoleIngress, nginx)
+
+			group := prepareEmptyIngressGroup(nginx)
+			group.targets = []model.Target{
+				prepareIngressTarget(nginx, "traefik", "http", "lb.example.com", "/", "", "nginx-cluster-ip-service", "80"),
+				prepareIngressTarget(nginx, "traefik", "http", "nginx.example.com", "/", "", "nginx-cluster-ip-service", "80"),
+			}
+			sim := discoverySim{
+				discovery: discovery,
+				expectedGroups: []model.Group{
+					group,
+				},
+			}
+			return sim
+		},
+		"ADD: ingress with a resource backend path": func() discoverySim {
+			nginx := newNGINXIngress()
+			apiGroup := "k8s.example.com"
+			nginx.Spec.Rules[0].HTTP = &networkingv1.HTTPIngressRuleValue{
+				Paths: []networkingv1.HTTPIngressPath{
+					{
+						Path: "/static",
+						Backend: networkingv1.IngressBackend{
+							Resource: &apiv1.TypedLocalObjectReference{APIGroup: &apiGroup, Kind: "StorageBucket", Name: "static"},
+						},
+					},
+				},
+			}
+			discovery, _ := prepareAllNsDiscovery(RoleIngress, nginx)
+
+			group := prepareEmptyIngressGroup(nginx)
+			group.targets = []model.Target{
+				prepareIngressTarget(nginx, "traefik", "http", "nginx.example.com", "/static", "", "", ""),
+			}
+			sim := discoverySim{
+				discovery: discovery,
+				expectedGroups: []model.Group{
+					group,
+				},
+			}
+			return sim
+		},
+		"ADD: ingress with a wildcard host rule": func() discoverySim {
+			httpd := newHTTPDIngress()
+			wildcard := httpd.Spec.Rules[0]
+			wildcard.Host = "*.example.com"
+			httpd.Spec.Rules = append(httpd.Spec.Rules, wildcard)
+			discovery, _ := prepareAllNsDiscovery(RoleIngress, httpd)
+
+			sim := discoverySim{
+				discovery: discovery,
+				expectedGroups: []model.Group{
+					prepareHTTPDIngressGroup(httpd),
+				},
+			}
+			return sim
+		},
+	}
+
+	for name, sim := range tests {
+		t.Run(name, func(t *testing.T) { sim().run(t) })
+	}
+}
+
+func newHTTPDIngress() *networkingv1.Ingress {
+	class := "nginx"
+	prefix := networkingv1.PathTypePrefix
+	return &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "httpd-ingress",
+			Namespace:   "default",
+			Annotations: map[string]string{"phase": "prod"},
+			Labels:      map[string]string{"app": "httpd"},
+		},
+		Spec: networkingv1.IngressSpec{
+			IngressClassName: &class,
+			TLS: []networkingv1.IngressTLS{
+				{Hosts: []string{"*.example.com"}, SecretName: "example-tls"},
+			},
+			Rules: []networkingv1.IngressRule{
+				{
+					Host: "httpd.example.com",
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{
+							Paths: []networkingv1.HTTPIngressPath{
+								{Path: "/", PathType: &prefix, Backend: newIngressBackend("httpd-cluster-ip-service", 80, "")},
+								{Path: "/api", PathType: &prefix, Backend: newIngressBackend("httpd-api", 0, "http")},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func newNGINXIngress() *networkingv1.Ingress {
+	return &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "nginx-ingress",
+			Namespace:   "default",
+			Annotations: map[string]string{annotationIngressClass: "traefik"},
+			Labels:      map[string]string{"app": "nginx"},
+		},
+		Spec: networkingv1.IngressSpec{
+			DefaultBackend: &networkingv1.IngressBackend{
+				Service: &networkingv1.IngressServiceBackend{
+					Name: "nginx-cluster-ip-service",
+					Port: networkingv1.ServiceBackendPort{Number: 80},
+				},
+			},
+			Rules: []networkingv1.IngressRule{
+				{Host: "nginx.example.com"},
+			},
+		},
+	}
+}
+
+func newIngressBackend(name string, number int32, portName string) networkingv1.IngressBackend {
+	return networkingv1.IngressBackend{
+		Service: &networkingv1.IngressServiceBackend{
+			Name: name,
+			Port: networkingv1.ServiceBackendPort{Number: number, Name: portName},
+		},
+	}
+}
+
+func prepareEmptyIngressGroup(ing *networkingv1.Ingress) *ingressGroup {
+	return &ingressGroup{source: ingressSource(ing)}
+}
+
+func prepareHTTPDIngressGroup(ing *networkingv1.Ingress) *ingressGroup {
+	group := prepareEmptyIngressGroup(ing)
+	group.targets = []model.Target{
+		prepareIngressTarget(ing, "nginx", "https", "httpd.example.com", "/", "Prefix", "httpd-cluster-ip-service", "80"),
+		prepareIngressTarget(ing, "nginx", "https", "httpd.example.com", "/api", "Prefix", "httpd-api", "http"),
+	}
+	return group
+}
+
+func prepareNGINXIngressGroup(ing *networkingv1.Ingress) *ingressGroup {
+	group := prepareEmptyIngressGroup(ing)
+	group.targets = []model.Target{
+		prepareIngressTarget(ing, "traefik", "http", "nginx.example.com", "/", "", "nginx-cluster-ip-service", "80"),
+	}
+	return group
+}
+
+func prepareIngressTarget(ing *networkingv1.Ingress, class, scheme, host, path, pathType, svcName, svcPort string) *IngressTarget {
+	port := "80"
+	if scheme == "https" {
+		port = "443"
+	}
+	target := &IngressTarget{
+		tuid:         ingressTUID(ing, scheme, host, path),
+		Address:      host + ":" + port,
+		Namespace:    ing.Namespace,
+		Name:         ing.Name,
+		Annotations:  toMapInterface(ing.Annotations),
+		Labels:       toMapInterface(ing.Labels),
+		IngressClass: class,
+		Scheme:       scheme,
+		Host:         host,
+		Path:         path,
+		PathType:     pathType,
+		URL:          scheme + "://" + host + path,
+		ServiceName:  svcName,
+		ServicePort:  svcPort,
+	}
+	target.hash = mustCalcHash(target)
+	target.Tags().Merge(discoveryTags)
+	return target
+}
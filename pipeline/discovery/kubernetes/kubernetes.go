@@ -2,22 +2,34 @@ package kubernetes
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/netdata/sd/pipeline/model"
+	"github.com/netdata/sd/pkg/health"
 	"github.com/netdata/sd/pkg/k8s"
 	"github.com/netdata/sd/pkg/log"
 
 	"github.com/ilyam8/hashstructure"
 	"github.com/rs/zerolog"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	apiv1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
@@ -26,35 +38,139 @@ import (
 type Role string
 
 const (
-	RolePod     = "pod"
-	RoleService = "service"
+	RolePod           = "pod"
+	RoleService       = "service"
+	RoleEndpointSlice = "endpointslice"
+	RoleNode          = "node"
+	RoleIngress       = "ingress"
+	RoleCustom        = "custom"
 )
 
-func isRoleValid(role string) bool { return role == RolePod || role == RoleService }
+var roles = []string{RolePod, RoleService, RoleEndpointSlice, RoleNode, RoleIngress, RoleCustom}
+
+func isRoleValid(role string) bool {
+	for _, r := range roles {
+		if role == r {
+			return true
+		}
+	}
+	return false
+}
 
 const (
 	envNodeName = "MY_NODE_NAME"
 )
 
+// reClusterName matches cluster names that are safe to embed in group sources and target TUIDs,
+// which use '/' and '_' as separators.
+var reClusterName = regexp.MustCompile(`^[a-zA-Z0-9]([-a-zA-Z0-9.]*[a-zA-Z0-9])?$`)
+
 type Config struct {
-	APIServer  string   `yaml:"api_server"`
-	Tags       string   `yaml:"tags"`
-	Namespaces []string `yaml:"namespaces"`
-	Role       string   `yaml:"role"`
-	LocalMode  bool     `yaml:"local_mode"`
-	Selector   struct {
+	k8s.ClientConfig `yaml:",inline"`
+	Cluster          string   `yaml:"cluster"`
+	Tags             string   `yaml:"tags"`
+	Namespaces       []string `yaml:"namespaces"`
+	Role             string   `yaml:"role"`
+	LocalMode        bool     `yaml:"local_mode"`
+	Selector         struct {
 		Label string `yaml:"label"`
 		Field string `yaml:"field"`
 	} `yaml:"selector"`
+	NamespaceSelector struct {
+		Label string `yaml:"label"`
+		Field string `yaml:"field"`
+	} `yaml:"namespace_selector"`
+	Pod     PodConfig     `yaml:"pod"`
+	Service ServiceConfig `yaml:"service"`
+	Custom  CustomConfig  `yaml:"custom"`
+}
+
+// PodConfig holds the 'pod' role specific options.
+type PodConfig struct {
+	// ReadyOnly makes the discoverer emit targets only for containers that pass their readiness probe.
+	ReadyOnly bool `yaml:"ready_only"`
+	// InitContainers, SidecarContainers and EphemeralContainers enable targets for the corresponding container kinds,
+	// only regular containers are discovered by default.
+	InitContainers      bool `yaml:"init_containers"`
+	SidecarContainers   bool `yaml:"sidecar_containers"`
+	EphemeralContainers bool `yaml:"ephemeral_containers"`
+	// EnvSources sets the sources watched to resolve containers env values:
+	// 'off', 'configmaps' or 'both' (ConfigMaps and Secrets, the default).
+	EnvSources string `yaml:"env_sources"`
+	// RedactSecrets exposes only the names of env variables from Secrets, their values are empty.
+	// ConfigMap values are not redacted. It requires Secrets watching ('both' env sources).
+	RedactSecrets bool `yaml:"redact_secrets"`
+	// ResolveWorkloads enables ReplicaSets and Jobs watching to resolve pods workloads (Deployments and CronJobs).
+	ResolveWorkloads bool `yaml:"resolve_workloads"`
+	// NodeMetadata enables nodes watching to add the pods node labels, annotations, topology and addresses.
+	NodeMetadata bool `yaml:"node_metadata"`
+}
+
+// ServiceConfig holds the 'service' role specific options.
+type ServiceConfig struct {
+	// Endpoints enables EndpointSlices watching to expand headless services into endpoint targets
+	// and to add the endpoints counts and the resolved target ports.
+	Endpoints bool `yaml:"endpoints"`
+}
+
+// CustomConfig holds the 'custom' role options: the group, version and resource of the discovered objects.
+type CustomConfig struct {
+	Group    string `yaml:"group"`
+	Version  string `yaml:"version"`
+	Resource string `yaml:"resource"`
+}
+
+func (c CustomConfig) gvr() schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: c.Group, Version: c.Version, Resource: c.Resource}
 }
 
+const (
+	EnvSourcesOff        = "off"
+	EnvSourcesConfigMaps = "configmaps"
+	EnvSourcesBoth       = "both"
+)
+
 func validateConfig(cfg Config) error {
 	if !isRoleValid(cfg.Role) {
-		return fmt.Errorf("invalid role '%s', valid roles: '%s', '%s'", cfg.Role, RolePod, RoleService)
+		return fmt.Errorf("invalid role '%s', valid roles: '%s'", cfg.Role, strings.Join(roles, "', '"))
 	}
 	if cfg.Tags == "" {
 		return fmt.Errorf("no tags set for '%s' role", cfg.Role)
 	}
+	if cfg.Cluster != "" && !reClusterName.MatchString(cfg.Cluster) {
+		return fmt.Errorf("invalid cluster name '%s', it may contain only letters, digits, '-' and '.'", cfg.Cluster)
+	}
+	if cfg.Role == RoleNode && len(cfg.Namespaces) > 0 {
+		// nodes are cluster-scoped objects
+		return fmt.Errorf("namespaces can't be used with '%s' role", cfg.Role)
+	}
+	if sr := cfg.NamespaceSelector; sr.Label != "" || sr.Field != "" {
+		if cfg.Role == RoleNode {
+			return fmt.Errorf("namespace_selector can't be used with '%s' role", cfg.Role)
+		}
+		if len(cfg.Namespaces) > 0 {
+			return errors.New("namespaces and namespace_selector can't be used together")
+		}
+		if _, err := labels.Parse(sr.Label); err != nil {
+			return fmt.Errorf("invalid namespace_selector label: %v", err)
+		}
+		if _, err := fields.ParseSelector(sr.Field); err != nil {
+			return fmt.Errorf("invalid namespace_selector field: %v", err)
+		}
+	}
+	if cfg.Role == RoleCustom && (cfg.Custom.Version == "" || cfg.Custom.Resource == "") {
+		return fmt.Errorf("'%s' role requires custom version and resource", cfg.Role)
+	}
+	switch cfg.Pod.EnvSources {
+	case "", EnvSourcesOff, EnvSourcesConfigMaps, EnvSourcesBoth:
+	default:
+		return fmt.Errorf("invalid pod env_sources '%s', valid values: '%s', '%s', '%s'",
+			cfg.Pod.EnvSources, EnvSourcesOff, EnvSourcesConfigMaps, EnvSourcesBoth)
+	}
+	if cfg.Pod.RedactSecrets && cfg.Pod.EnvSources != "" && cfg.Pod.EnvSources != EnvSourcesBoth {
+		// Secrets are not watched, only ConfigMap values (never redacted) are resolved
+		return fmt.Errorf("pod redact_secrets requires env_sources '%s', got '%s'", EnvSourcesBoth, cfg.Pod.EnvSources)
+	}
 	return nil
 }
 
@@ -62,16 +178,32 @@ type (
 	discoverer interface {
 		Discover(ctx context.Context, ch chan<- []model.Group)
 	}
+	healthReporter interface {
+		Health() []health.Status
+		// OnHealthChange registers a function that is called after every health state change.
+		OnHealthChange(fn func())
+	}
 	Discovery struct {
-		tags          model.Tags
-		namespaces    []string
-		role          string
-		selectorLabel string
-		selectorField string
-		client        kubernetes.Interface
-		discoverers   []discoverer
-		started       chan struct{}
-		log           zerolog.Logger
+		cluster         string
+		tags            model.Tags
+		namespaces      []string
+		role            string
+		selectorLabel   string
+		selectorField   string
+		nsSelectorLabel string
+		nsSelectorField string
+		podConfig       PodConfig
+		serviceConfig   ServiceConfig
+		nodeName        string
+		customGVR       schema.GroupVersionResource
+		client          kubernetes.Interface
+		dynClient       dynamic.Interface
+		informers       *k8s.Informers
+		mu              sync.Mutex
+		discoverers     []discoverer
+		started         chan struct{}
+		health          *health.Reporter
+		log             zerolog.Logger
 	}
 )
 
@@ -92,32 +224,54 @@ func initDiscovery(cfg Config) (*Discovery, error) {
 	if err != nil {
 		return nil, fmt.Errorf("parse config->tags: %v", err)
 	}
-	client, err := k8s.Clientset()
+	client, err := k8s.Clientset(cfg.ClientConfig)
 	if err != nil {
 		return nil, fmt.Errorf("create clientset: %v", err)
 	}
+	var dynClient dynamic.Interface
+	if cfg.Role == RoleCustom {
+		if dynClient, err = k8s.DynamicClient(cfg.ClientConfig); err != nil {
+			return nil, fmt.Errorf("create dynamic client: %v", err)
+		}
+	}
 	namespaces := cfg.Namespaces
 	if len(namespaces) == 0 {
 		namespaces = []string{apiv1.NamespaceAll}
 	}
-	if cfg.LocalMode && cfg.Role == RolePod {
-		if name := os.Getenv(envNodeName); name != "" {
+	var nodeName string
+	if cfg.LocalMode && (cfg.Role == RolePod || cfg.Role == RoleNode) {
+		name := os.Getenv(envNodeName)
+		if name == "" {
+			return nil, fmt.Errorf("local_mode is enabled, but env '%s' not set", envNodeName)
+		}
+		nodeName = name
+		if cfg.Role == RolePod {
 			cfg.Selector.Field = joinSelectors(cfg.Selector.Field, "spec.nodeName="+name)
 		} else {
-			return nil, fmt.Errorf("local_mode is enabled, but env '%s' not set", envNodeName)
+			cfg.Selector.Field = joinSelectors(cfg.Selector.Field, "metadata.name="+name)
 		}
 	}
 
 	d := &Discovery{
-		tags:          tags,
-		namespaces:    namespaces,
-		role:          cfg.Role,
-		selectorLabel: cfg.Selector.Label,
-		selectorField: cfg.Selector.Field,
-		client:        client,
-		discoverers:   make([]discoverer, 0, len(namespaces)),
-		started:       make(chan struct{}),
-		log:           log.New("k8s discovery manager"),
+		cluster:         cfg.Cluster,
+		tags:            tags,
+		namespaces:      namespaces,
+		role:            cfg.Role,
+		selectorLabel:   cfg.Selector.Label,
+		selectorField:   cfg.Selector.Field,
+		nsSelectorLabel: cfg.NamespaceSelector.Label,
+		nsSelectorField: cfg.NamespaceSelector.Field,
+		podConfig:       cfg.Pod,
+		serviceConfig:   cfg.Service,
+		nodeName:        nodeName,
+		customGVR:       cfg.Custom.gvr(),
+		client:          client,
+		dynClient:       dynClient,
+		informers:       k8s.SharedInformers,
+		discoverers:     make([]discoverer, 0, len(namespaces)),
+		started:         make(chan struct{}),
+		health:          health.NewReporter("k8s discovery manager"),
+		log:             log.New("k8s discovery manager"),
 	}
 	return d, nil
 }
@@ -128,25 +282,51 @@ func (d *Discovery) String() string {
 
 const resyncPeriod = 10 * time.Minute
 
+// Health returns the status of the discovery followed by the statuses of the discoverers. The discovery is synced
+// once all the discoverers are synced and the groups they sent before are passed on.
+func (d *Discovery) Health() []health.Status {
+	return append([]health.Status{d.health.Status()}, d.discoverersHealth()...)
+}
+
+func (d *Discovery) discoverersHealth() []health.Status {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var statuses []health.Status
+	for _, dd := range d.discoverers {
+		if v, ok := dd.(healthReporter); ok {
+			statuses = append(statuses, v.Health()...)
+		}
+	}
+	return statuses
+}
+
+// OnHealthChange registers the function with the discovery reporter, it is synced after all the discoverers.
+func (d *Discovery) OnHealthChange(fn func()) { d.health.OnChange(fn) }
+
 func (d *Discovery) Discover(ctx context.Context, in chan<- []model.Group) {
-	for _, namespace := range d.namespaces {
-		var dd discoverer
-		switch d.role {
-		case RolePod:
-			dd = d.setupPodDiscoverer(ctx, namespace)
-		case RoleService:
-			dd = d.setupServiceDiscoverer(ctx, namespace)
-		default:
-			panic(fmt.Sprintf("unknown k8 discovery role: '%s'", d.role))
+	d.mu.Lock()
+	if d.nsSelectorLabel != "" || d.nsSelectorField != "" {
+		d.discoverers = append(d.discoverers, d.setupNamespaceDiscoverer())
+	} else {
+		for _, namespace := range d.namespaces {
+			d.discoverers = append(d.discoverers, d.newDiscoverer(namespace))
 		}
-		d.discoverers = append(d.discoverers, dd)
 	}
+	d.mu.Unlock()
 	if len(d.discoverers) == 0 {
 		panic("k8s cant run discovery: zero discoverers")
 	}
 
 	d.log.Info().Msgf("registered: %v", d.discoverers)
 
+	waiter := health.NewSyncWaiter(d.discoverersHealth)
+	for _, dd := range d.discoverers {
+		if v, ok := dd.(healthReporter); ok {
+			v.OnHealthChange(waiter.Notify)
+		}
+	}
+
 	var wg sync.WaitGroup
 	updates := make(chan []model.Group)
 
@@ -156,7 +336,7 @@ func (d *Discovery) Discover(ctx context.Context, in chan<- []model.Group) {
 	}
 
 	wg.Add(1)
-	go func() { defer wg.Done(); d.run(ctx, updates, in) }()
+	go func() { defer wg.Done(); d.run(ctx, waiter, updates, in) }()
 
 	close(d.started)
 
@@ -164,11 +344,40 @@ func (d *Discovery) Discover(ctx context.Context, in chan<- []model.Group) {
 	<-ctx.Done()
 }
 
-func (d *Discovery) run(ctx context.Context, updates chan []model.Group, in chan<- []model.Group) {
+func (d *Discovery) newDiscoverer(namespace string) discoverer {
+	switch d.role {
+	case RolePod:
+		return d.setupPodDiscoverer(namespace)
+	case RoleService:
+		return d.setupServiceDiscoverer(namespace)
+	case RoleEndpointSlice:
+		return d.setupEndpointSliceDiscoverer(namespace)
+	case RoleNode:
+		return d.setupNodeDiscoverer()
+	case RoleIngress:
+		return d.setupIngressDiscoverer(namespace)
+	case RoleCustom:
+		return d.setupCustomDiscoverer(namespace)
+	default:
+		panic(fmt.Sprintf("unknown k8 discovery role: '%s'", d.role))
+	}
+}
+
+func (d *Discovery) run(
+	ctx context.Context,
+	waiter *health.SyncWaiter,
+	updates chan []model.Group,
+	in chan<- []model.Group,
+) {
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case <-waiter.Changed():
+			// discoverers are synced after their initial groups are received, they are passed on at this point
+			if waiter.Check() {
+				d.health.Synced()
+			}
 		case groups := <-updates:
 			for _, group := range groups {
 				for _, t := range group.Targets() {
@@ -184,64 +393,334 @@ func (d *Discovery) run(ctx context.Context, updates chan []model.Group, in chan
 	}
 }
 
-func (d *Discovery) setupPodDiscoverer(ctx context.Context, namespace string) *Pod {
-	pod := d.client.CoreV1().Pods(namespace)
-	podLW := &cache.ListWatch{
-		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
-			options.FieldSelector = d.selectorField
-			options.LabelSelector = d.selectorLabel
-			return pod.List(ctx, options)
-		},
-		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
-			options.FieldSelector = d.selectorField
-			options.LabelSelector = d.selectorLabel
-			return pod.Watch(ctx, options)
-		},
-	}
-
-	cmap := d.client.CoreV1().ConfigMaps(namespace)
-	cmapLW := &cache.ListWatch{
-		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
-			return cmap.List(ctx, options)
-		},
-		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
-			return cmap.Watch(ctx, options)
-		},
-	}
-
-	secret := d.client.CoreV1().Secrets(namespace)
-	secretLW := &cache.ListWatch{
-		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
-			return secret.List(ctx, options)
-		},
-		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
-			return secret.Watch(ctx, options)
-		},
-	}
-
-	return NewPod(
-		cache.NewSharedInformer(podLW, &apiv1.Pod{}, resyncPeriod),
-		cache.NewSharedInformer(cmapLW, &apiv1.ConfigMap{}, resyncPeriod),
-		cache.NewSharedInformer(secretLW, &apiv1.Secret{}, resyncPeriod),
-	)
-}
-
-func (d *Discovery) setupServiceDiscoverer(ctx context.Context, namespace string) *Service {
-	svc := d.client.CoreV1().Services(namespace)
-	clw := &cache.ListWatch{
-		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
-			options.FieldSelector = d.selectorField
-			options.LabelSelector = d.selectorLabel
-			return svc.List(ctx, options)
-		},
-		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
-			options.FieldSelector = d.selectorField
-			options.LabelSelector = d.selectorLabel
-			return svc.Watch(ctx, options)
-		},
-	}
-	inf := cache.NewSharedInformer(clw, &apiv1.Service{}, resyncPeriod)
-	return NewService(inf)
+func (d *Discovery) setupNamespaceDiscoverer() *Namespace {
+	key := k8s.InformerKey{Resource: "namespaces", LabelSelector: d.nsSelectorLabel, FieldSelector: d.nsSelectorField}
+	inf := d.sharedInformer(key, &apiv1.Namespace{},
+		func(ctx context.Context) cache.ListerWatcher {
+			ns := d.client.CoreV1().Namespaces()
+			return &cache.ListWatch{
+				ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+					options.FieldSelector = d.nsSelectorField
+					options.LabelSelector = d.nsSelectorLabel
+					return ns.List(ctx, options)
+				},
+				WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+					options.FieldSelector = d.nsSelectorField
+					options.LabelSelector = d.nsSelectorLabel
+					return ns.Watch(ctx, options)
+				},
+			}
+		})
+
+	dd := NewNamespace(inf, d.newDiscoverer)
+	// selectors are validated with the config
+	dd.selectorLabel, _ = labels.Parse(d.nsSelectorLabel)
+	dd.selectorField, _ = fields.ParseSelector(d.nsSelectorField)
+	return dd
+}
+
+func (d *Discovery) setupPodDiscoverer(namespace string) *Pod {
+	podInformer := d.sharedInformer(d.selectedKey("pods", namespace), &apiv1.Pod{},
+		func(ctx context.Context) cache.ListerWatcher {
+			pod := d.client.CoreV1().Pods(namespace)
+			return &cache.ListWatch{
+				ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+					options.FieldSelector = d.selectorField
+					options.LabelSelector = d.selectorLabel
+					return pod.List(ctx, options)
+				},
+				WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+					options.FieldSelector = d.selectorField
+					options.LabelSelector = d.selectorLabel
+					return pod.Watch(ctx, options)
+				},
+			}
+		})
+
+	// ConfigMaps and Secrets are watched only if they are used to resolve env values
+	var cmapInformer, secretInformer cache.SharedInformer
+	sources := d.podConfig.EnvSources
+
+	if sources != EnvSourcesOff {
+		cmapInformer = d.sharedInformer(k8s.InformerKey{Resource: "configmaps", Namespace: namespace}, &apiv1.ConfigMap{},
+			func(ctx context.Context) cache.ListerWatcher {
+				cmap := d.client.CoreV1().ConfigMaps(namespace)
+				return &cache.ListWatch{
+					ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+						return cmap.List(ctx, options)
+					},
+					WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+						return cmap.Watch(ctx, options)
+					},
+				}
+			})
+	}
+
+	if sources == "" || sources == EnvSourcesBoth {
+		secretInformer = d.sharedInformer(k8s.InformerKey{Resource: "secrets", Namespace: namespace}, &apiv1.Secret{},
+			func(ctx context.Context) cache.ListerWatcher {
+				secret := d.client.CoreV1().Secrets(namespace)
+				return &cache.ListWatch{
+					ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+						return secret.List(ctx, options)
+					},
+					WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+						return secret.Watch(ctx, options)
+					},
+				}
+			})
+	}
+
+	dd := NewPod(podInformer, cmapInformer, secretInformer)
+	if d.podConfig.ResolveWorkloads {
+		dd.setWorkloadInformers(d.setupReplicaSetInformer(namespace), d.setupJobInformer(namespace))
+	}
+	if d.podConfig.NodeMetadata {
+		dd.setNodeInformer(d.setupPodNodeInformer())
+	}
+	dd.cluster = d.cluster
+	dd.readyOnly = d.podConfig.ReadyOnly
+	dd.redactSecrets = d.podConfig.RedactSecrets
+	dd.initConts = d.podConfig.InitContainers
+	dd.sidecarConts = d.podConfig.SidecarContainers
+	dd.ephemeralConts = d.podConfig.EphemeralContainers
+	return dd
+}
+
+func (d *Discovery) setupReplicaSetInformer(namespace string) cache.SharedInformer {
+	return d.sharedInformer(k8s.InformerKey{Resource: "replicasets", Namespace: namespace}, &appsv1.ReplicaSet{},
+		func(ctx context.Context) cache.ListerWatcher {
+			rs := d.client.AppsV1().ReplicaSets(namespace)
+			return &cache.ListWatch{
+				ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+					return rs.List(ctx, options)
+				},
+				WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+					return rs.Watch(ctx, options)
+				},
+			}
+		})
+}
+
+func (d *Discovery) setupJobInformer(namespace string) cache.SharedInformer {
+	return d.sharedInformer(k8s.InformerKey{Resource: "jobs", Namespace: namespace}, &batchv1.Job{},
+		func(ctx context.Context) cache.ListerWatcher {
+			job := d.client.BatchV1().Jobs(namespace)
+			return &cache.ListWatch{
+				ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+					return job.List(ctx, options)
+				},
+				WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+					return job.Watch(ctx, options)
+				},
+			}
+		})
+}
+
+// setupPodNodeInformer creates an informer of the nodes, the discovery selectors are applied to pods.
+// In local mode only the local node is watched.
+func (d *Discovery) setupPodNodeInformer() cache.SharedInformer {
+	var selector string
+	if d.nodeName != "" {
+		selector = "metadata.name=" + d.nodeName
+	}
+	return d.sharedInformer(k8s.InformerKey{Resource: "nodes", FieldSelector: selector}, &apiv1.Node{},
+		func(ctx context.Context) cache.ListerWatcher {
+			node := d.client.CoreV1().Nodes()
+			return &cache.ListWatch{
+				ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+					options.FieldSelector = selector
+					return node.List(ctx, options)
+				},
+				WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+					options.FieldSelector = selector
+					return node.Watch(ctx, options)
+				},
+			}
+		})
+}
+
+func (d *Discovery) setupServiceDiscoverer(namespace string) *Service {
+	svcInformer := d.sharedInformer(d.selectedKey("services", namespace), &apiv1.Service{},
+		func(ctx context.Context) cache.ListerWatcher {
+			svc := d.client.CoreV1().Services(namespace)
+			return &cache.ListWatch{
+				ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+					options.FieldSelector = d.selectorField
+					options.LabelSelector = d.selectorLabel
+					return svc.List(ctx, options)
+				},
+				WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+					options.FieldSelector = d.selectorField
+					options.LabelSelector = d.selectorLabel
+					return svc.Watch(ctx, options)
+				},
+			}
+		})
+
+	// EndpointSlices are watched only if enabled, they need additional RBAC permissions
+	var esInformer cache.SharedInformer
+	if d.serviceConfig.Endpoints {
+		esKey := k8s.InformerKey{Resource: "endpointslices", Namespace: namespace}
+		esInformer = d.sharedInformer(esKey, &discoveryv1.EndpointSlice{},
+			func(ctx context.Context) cache.ListerWatcher {
+				es := d.client.DiscoveryV1().EndpointSlices(namespace)
+				return &cache.ListWatch{
+					ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+						return es.List(ctx, options)
+					},
+					WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+						return es.Watch(ctx, options)
+					},
+				}
+			})
+	}
+
+	dd := NewService(svcInformer, esInformer)
+	dd.cluster = d.cluster
+	return dd
+}
+
+func (d *Discovery) setupEndpointSliceDiscoverer(namespace string) *EndpointSlice {
+	esInformer := d.sharedInformer(d.selectedKey("endpointslices", namespace), &discoveryv1.EndpointSlice{},
+		func(ctx context.Context) cache.ListerWatcher {
+			es := d.client.DiscoveryV1().EndpointSlices(namespace)
+			return &cache.ListWatch{
+				ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+					options.FieldSelector = d.selectorField
+					options.LabelSelector = d.selectorLabel
+					return es.List(ctx, options)
+				},
+				WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+					options.FieldSelector = d.selectorField
+					options.LabelSelector = d.selectorLabel
+					return es.Watch(ctx, options)
+				},
+			}
+		})
+
+	podInformer := d.sharedInformer(k8s.InformerKey{Resource: "pods", Namespace: namespace}, &apiv1.Pod{},
+		func(ctx context.Context) cache.ListerWatcher {
+			pod := d.client.CoreV1().Pods(namespace)
+			return &cache.ListWatch{
+				ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+					return pod.List(ctx, options)
+				},
+				WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+					return pod.Watch(ctx, options)
+				},
+			}
+		})
+
+	dd := NewEndpointSlice(esInformer, podInformer)
+	dd.cluster = d.cluster
+	return dd
+}
+
+func (d *Discovery) setupNodeDiscoverer() *Node {
+	inf := d.sharedInformer(d.selectedKey("nodes", ""), &apiv1.Node{},
+		func(ctx context.Context) cache.ListerWatcher {
+			node := d.client.CoreV1().Nodes()
+			return &cache.ListWatch{
+				ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+					options.FieldSelector = d.selectorField
+					options.LabelSelector = d.selectorLabel
+					return node.List(ctx, options)
+				},
+				WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+					options.FieldSelector = d.selectorField
+					options.LabelSelector = d.selectorLabel
+					return node.Watch(ctx, options)
+				},
+			}
+		})
+
+	dd := NewNode(inf)
+	dd.cluster = d.cluster
+	return dd
+}
+
+func (d *Discovery) setupIngressDiscoverer(namespace string) *Ingress {
+	inf := d.sharedInformer(d.selectedKey("ingresses", namespace), &networkingv1.Ingress{},
+		func(ctx context.Context) cache.ListerWatcher {
+			ing := d.client.NetworkingV1().Ingresses(namespace)
+			return &cache.ListWatch{
+				ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+					options.FieldSelector = d.selectorField
+					options.LabelSelector = d.selectorLabel
+					return ing.List(ctx, options)
+				},
+				WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+					options.FieldSelector = d.selectorField
+					options.LabelSelector = d.selectorLabel
+					return ing.Watch(ctx, options)
+				},
+			}
+		})
+
+	dd := NewIngress(inf)
+	dd.cluster = d.cluster
+	return dd
+}
+
+func (d *Discovery) setupCustomDiscoverer(namespace string) *Custom {
+	key := d.selectedKey(d.customGVR.String(), namespace)
+	key.Client = d.dynClient
+	inf := d.sharedInformer(key, &unstructured.Unstructured{},
+		func(ctx context.Context) cache.ListerWatcher {
+			res := d.dynClient.Resource(d.customGVR).Namespace(namespace)
+			return &cache.ListWatch{
+				ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+					options.FieldSelector = d.selectorField
+					options.LabelSelector = d.selectorLabel
+					return res.List(ctx, options)
+				},
+				WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+					options.FieldSelector = d.selectorField
+					options.LabelSelector = d.selectorLabel
+					return res.Watch(ctx, options)
+				},
+			}
+		})
+
+	dd := NewCustom(inf, d.customGVR)
+	dd.cluster = d.cluster
+	return dd
+}
+
+// selectedKey returns the informer key of the resource objects restricted by the discovery selectors.
+func (d *Discovery) selectedKey(resource, namespace string) k8s.InformerKey {
+	return k8s.InformerKey{
+		Resource:      resource,
+		Namespace:     namespace,
+		LabelSelector: d.selectorLabel,
+		FieldSelector: d.selectorField,
+	}
+}
+
+// sharedInformer returns the informer from the shared registry, discovery instances using the same client reuse it.
+// The key client defaults to the discovery clientset.
+func (d *Discovery) sharedInformer(
+	key k8s.InformerKey,
+	objType runtime.Object,
+	newLW func(ctx context.Context) cache.ListerWatcher,
+) cache.SharedInformer {
+	if key.Client == nil {
+		key.Client = d.client
+	}
+	indexers := cache.Indexers{}
+	// informers are shared by the roles, so all the informers of a type get the same indexers
+	switch objType.(type) {
+	case *apiv1.Pod:
+		indexers[podNodeIndex] = podNodeIndexFunc
+		indexers[podControllerIndex] = podControllerIndexFunc
+	case *discoveryv1.EndpointSlice:
+		indexers[endpointSlicePodIndex] = endpointSlicePodIndexFunc
+		indexers[endpointSliceServiceIndex] = endpointSliceServiceIndexFunc
+	}
+	return d.informers.Get(key, func(ctx context.Context) cache.SharedIndexInformer {
+		return cache.NewSharedIndexInformer(newLW(ctx), objType, resyncPeriod, indexers)
+	})
 }
 
 func enqueue(queue *workqueue.Type, obj interface{}) {
@@ -252,6 +731,30 @@ func enqueue(queue *workqueue.Type, obj interface{}) {
 	queue.Add(key)
 }
 
+// initialSync marks a discoverer synced once the items queued before its caches synced are processed, so the synced
+// state means the initial groups are sent.
+type initialSync struct {
+	left   int
+	health *health.Reporter
+}
+
+func newInitialSync(queue *workqueue.Type, rep *health.Reporter) *initialSync {
+	s := &initialSync{left: queue.Len(), health: rep}
+	if s.left == 0 {
+		rep.Synced()
+	}
+	return s
+}
+
+func (s *initialSync) itemDone() {
+	if s.left == 0 {
+		return
+	}
+	if s.left--; s.left == 0 {
+		s.health.Synced()
+	}
+}
+
 func send(ctx context.Context, in chan<- []model.Group, group model.Group) {
 	if group == nil {
 		return
@@ -266,6 +769,22 @@ func calcHash(obj interface{}) (uint64, error) {
 	return hashstructure.Hash(obj, nil)
 }
 
+// clusterSource prefixes a group source with the cluster name ('k8s/pod/ns/name' => 'k8s/cluster/pod/ns/name').
+func clusterSource(cluster, source string) string {
+	if cluster == "" {
+		return source
+	}
+	return "k8s/" + cluster + "/" + strings.TrimPrefix(source, "k8s/")
+}
+
+// clusterTUID prefixes a target TUID with the cluster name.
+func clusterTUID(cluster, tuid string) string {
+	if cluster == "" {
+		return tuid
+	}
+	return cluster + "_" + tuid
+}
+
 func joinSelectors(srs ...string) string {
 	var i int
 	for _, v := range srs {
@@ -1,19 +1,25 @@
 package kubernetes
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/netdata/sd/pipeline/model"
+	"github.com/netdata/sd/pkg/health"
 	"github.com/netdata/sd/pkg/k8s"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	apiv1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
 )
 
 func TestMain(m *testing.M) {
@@ -32,9 +38,59 @@ func TestNewDiscovery(t *testing.T) {
 	}{
 		"role pod and local mode":     {cfg: Config{Role: RolePod, Tags: "k8s", LocalMode: true}},
 		"role service and local mode": {cfg: Config{Role: RoleService, Tags: "k8s", LocalMode: true}},
+		"role endpointslice":          {cfg: Config{Role: RoleEndpointSlice, Tags: "k8s"}},
+		"role node and local mode":    {cfg: Config{Role: RoleNode, Tags: "k8s", LocalMode: true}},
+		"role ingress":                {cfg: Config{Role: RoleIngress, Tags: "k8s"}},
+		"pod env sources":             {cfg: Config{Role: RolePod, Tags: "k8s", Pod: PodConfig{EnvSources: EnvSourcesConfigMaps}}},
+		"namespace selector":          {cfg: withNamespaceSelector(Config{Role: RolePod, Tags: "k8s"}, "team=a", "")},
 		"empty config":                {wantErr: true},
-		"invalid role":                {cfg: Config{Role: "invalid"}, wantErr: true},
-		"lack of tags":                {cfg: Config{Role: RolePod}, wantErr: true},
+		"pod redact secrets": {
+			cfg: Config{Role: RolePod, Tags: "k8s", Pod: PodConfig{EnvSources: EnvSourcesBoth, RedactSecrets: true}},
+		},
+		"pod redact secrets and configmaps env sources": {
+			cfg:     Config{Role: RolePod, Tags: "k8s", Pod: PodConfig{EnvSources: EnvSourcesConfigMaps, RedactSecrets: true}},
+			wantErr: true,
+		},
+		"pod redact secrets and off env sources": {
+			cfg:     Config{Role: RolePod, Tags: "k8s", Pod: PodConfig{EnvSources: EnvSourcesOff, RedactSecrets: true}},
+			wantErr: true,
+		},
+		"namespace selector and namespaces": {
+			cfg:     withNamespaceSelector(Config{Role: RolePod, Tags: "k8s", Namespaces: []string{"prod"}}, "team=a", ""),
+			wantErr: true,
+		},
+		"namespace selector and role node": {
+			cfg:     withNamespaceSelector(Config{Role: RoleNode, Tags: "k8s"}, "team=a", ""),
+			wantErr: true,
+		},
+		"cluster name": {
+			cfg: Config{Role: RolePod, Tags: "k8s", Cluster: "prod-eu.1"},
+		},
+		"cluster name with slash": {
+			cfg:     Config{Role: RolePod, Tags: "k8s", Cluster: "prod/eu"},
+			wantErr: true,
+		},
+		"cluster name with underscore": {
+			cfg:     Config{Role: RolePod, Tags: "k8s", Cluster: "prod_eu"},
+			wantErr: true,
+		},
+		"namespaces and role node": {
+			cfg:     Config{Role: RoleNode, Tags: "k8s", Namespaces: []string{"prod"}},
+			wantErr: true,
+		},
+		"invalid namespace selector": {
+			cfg:     withNamespaceSelector(Config{Role: RolePod, Tags: "k8s"}, "", "metadata.name"),
+			wantErr: true,
+		},
+		"role custom": {
+			cfg: Config{Role: RoleCustom, Tags: "k8s", Custom: CustomConfig{Version: "v1", Resource: "servicemonitors"}},
+		},
+		"role custom without resource": {
+			cfg:     Config{Role: RoleCustom, Tags: "k8s", Custom: CustomConfig{Version: "v1"}},
+			wantErr: true,
+		},
+		"invalid role": {cfg: Config{Role: "invalid"}, wantErr: true},
+		"lack of tags": {cfg: Config{Role: RolePod}, wantErr: true},
 	}
 	for name, test := range tests {
 		t.Run(name, func(t *testing.T) {
@@ -49,7 +105,10 @@ func TestNewDiscovery(t *testing.T) {
 				if test.cfg.LocalMode && test.cfg.Role == RolePod {
 					assert.Contains(t, discovery.selectorField, "spec.nodeName=m01")
 				}
-				if test.cfg.LocalMode && test.cfg.Role != RolePod {
+				if test.cfg.LocalMode && test.cfg.Role == RoleNode {
+					assert.Contains(t, discovery.selectorField, "metadata.name=m01")
+				}
+				if test.cfg.LocalMode && test.cfg.Role != RolePod && test.cfg.Role != RoleNode {
 					assert.Empty(t, discovery.selectorField)
 				}
 			}
@@ -90,6 +149,21 @@ func TestDiscovery_Discover(t *testing.T) {
 			}
 			return sim
 		},
+		"pod discovery with cluster name": func() discoverySim {
+			httpd, nginx := newHTTPDPod(), newNGINXPod()
+			discovery, _ := prepareAllNsDiscovery(RolePod, httpd, nginx)
+			discovery.cluster = "prod-eu"
+
+			sim := discoverySim{
+				discovery:        discovery,
+				sortBeforeVerify: true,
+				expectedGroups: []model.Group{
+					preparePodGroupWithCluster(httpd, "prod-eu"),
+					preparePodGroupWithCluster(nginx, "prod-eu"),
+				},
+			}
+			return sim
+		},
 		"multiple namespaces ClusterIP service discovery": func() discoverySim {
 			httpdProd, nginxProd := newHTTPDClusterIPService(), newNGINXClusterIPService()
 			httpdProd.Namespace = prod
@@ -138,12 +212,170 @@ func prepareDiscovery(role string, namespaces []string, objects ...runtime.Objec
 		selectorLabel: "",
 		selectorField: "",
 		client:        clientset,
+		informers:     k8s.NewInformers(),
 		discoverers:   nil,
 		started:       make(chan struct{}),
+		health:        health.NewReporter("k8s discovery manager"),
 	}
 	return discovery, clientset
 }
 
+func TestDiscovery_SharedInformers(t *testing.T) {
+	httpd := newHTTPDPod()
+	discovery1, clientset := prepareAllNsDiscovery(RolePod, httpd)
+	discovery2, _ := prepareAllNsDiscovery(RolePod)
+	discovery2.client = clientset
+	discovery2.informers = discovery1.informers
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	in1, in2 := make(chan []model.Group), make(chan []model.Group)
+	go discovery1.Discover(ctx, in1)
+	go discovery2.Discover(ctx, in2)
+
+	for _, in := range []chan []model.Group{in1, in2} {
+		select {
+		case groups := <-in:
+			assert.Equal(t, []model.Group{preparePodGroup(httpd)}, groups)
+		case <-ctx.Done():
+			t.Fatal("timeout waiting for groups")
+		}
+	}
+
+	// pods, configmaps and secrets informers are shared by both discoveries
+	pod1, pod2 := discovery1.discoverers[0].(*Pod), discovery2.discoverers[0].(*Pod)
+	assert.True(t, pod1.podInformer.GetStore() == pod2.podInformer.GetStore())
+	assert.True(t, pod1.cmapInformer.GetStore() == pod2.cmapInformer.GetStore())
+	assert.True(t, pod1.secretInformer.GetStore() == pod2.secretInformer.GetStore())
+}
+
+func TestDiscovery_PodInformers(t *testing.T) {
+	httpd := newHTTPDPod()
+	discovery, clientset := prepareAllNsDiscovery(RolePod, httpd, newNode("m01", "192.168.0.1"))
+	discovery.podConfig.NodeMetadata = true
+	discovery.nodeName = "m01"
+
+	var nodeSelectors []string
+	clientset.(*fake.Clientset).PrependReactor("list", "nodes",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			nodeSelectors = append(nodeSelectors, action.(k8stesting.ListAction).GetListRestrictions().Fields.String())
+			return false, nil, nil
+		})
+
+	pod := discovery.setupPodDiscoverer(apiv1.NamespaceAll)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	go pod.nodeInformer.Run(ctx.Done())
+	require.True(t, cache.WaitForCacheSync(ctx.Done(), pod.nodeInformer.HasSynced))
+
+	// in local mode only the local node is watched
+	assert.Equal(t, []string{"metadata.name=m01"}, nodeSelectors)
+
+	// node events look up the node pods using the index
+	podInformer, ok := pod.podInformer.(cache.SharedIndexInformer)
+	require.True(t, ok)
+	assert.Contains(t, podInformer.GetIndexer().GetIndexers(), podNodeIndex)
+	// workload events look up the owned pods using the index
+	assert.Contains(t, podInformer.GetIndexer().GetIndexers(), podControllerIndex)
+}
+
+func TestDiscovery_Health(t *testing.T) {
+	tests := map[string]struct {
+		prepare       func() *Discovery
+		expectedNames []string
+	}{
+		"namespaces": {
+			prepare: func() *Discovery {
+				discovery, _ := prepareDiscovery(RolePod, []string{"default", "prod"})
+				return discovery
+			},
+			expectedNames: []string{"k8s discovery manager", "k8s pod discovery", "k8s pod discovery"},
+		},
+		"namespace selector": {
+			prepare: func() *Discovery {
+				ns := newNamespace("dev")
+				ns.Labels = map[string]string{"team": "a"}
+				discovery, _ := prepareAllNsDiscovery(RoleService, ns)
+				discovery.nsSelectorLabel = "team=a"
+				return discovery
+			},
+			expectedNames: []string{"k8s discovery manager", "k8s namespace discovery", "k8s service discovery"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			discovery := test.prepare()
+			assert.Equal(t, []health.Status{discovery.health.Status()}, discovery.Health())
+			assert.Equal(t, health.StateStarting, discovery.health.Status().State)
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+			go discovery.Discover(ctx, make(chan []model.Group))
+
+			require.Eventually(t, func() bool {
+				statuses := discovery.Health()
+				return len(statuses) == len(test.expectedNames) && health.Synced(statuses)
+			}, time.Second*3, time.Millisecond*50)
+
+			var names []string
+			for _, status := range discovery.Health() {
+				names = append(names, status.Name)
+				assert.Empty(t, status.LastError)
+			}
+			assert.Equal(t, test.expectedNames, names)
+		})
+	}
+}
+
+func TestDiscovery_Health_InitialGroups(t *testing.T) {
+	httpd := newHTTPDPod()
+	discovery, _ := prepareAllNsDiscovery(RolePod, httpd)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	in := make(chan []model.Group)
+	go discovery.Discover(ctx, in)
+
+	<-discovery.started
+	require.True(t, cache.WaitForCacheSync(ctx.Done(), discovery.hasSynced))
+	assert.Never(t, func() bool { return discovery.health.Status().State != health.StateStarting },
+		time.Millisecond*300, time.Millisecond*10, "initial groups are not received yet")
+
+	select {
+	case groups := <-in:
+		assert.Equal(t, []model.Group{preparePodGroup(httpd)}, groups)
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for groups")
+	}
+	require.Eventually(t, func() bool { return health.Synced(discovery.Health()) }, time.Second*3, time.Millisecond*50)
+}
+
+func TestClusterSource(t *testing.T) {
+	tests := map[string]struct {
+		cluster  string
+		source   string
+		expected string
+	}{
+		"no cluster":   {source: "k8s/pod/default/httpd", expected: "k8s/pod/default/httpd"},
+		"with cluster": {cluster: "prod-eu", source: "k8s/pod/default/httpd", expected: "k8s/prod-eu/pod/default/httpd"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.expected, clusterSource(test.cluster, test.source))
+		})
+	}
+}
+
+func withNamespaceSelector(cfg Config, label, field string) Config {
+	cfg.NamespaceSelector.Label = label
+	cfg.NamespaceSelector.Field = field
+	return cfg
+}
+
 func newNamespace(name string) *apiv1.Namespace {
 	return &apiv1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
 }
@@ -0,0 +1,286 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/netdata/sd/pipeline/model"
+	"github.com/netdata/sd/pkg/health"
+	"github.com/netdata/sd/pkg/k8s"
+	"github.com/netdata/sd/pkg/log"
+
+	"github.com/rs/zerolog"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// staleGroup is a group without targets. It is sent for every source of a stopped namespace discoverer.
+type staleGroup struct {
+	source string
+}
+
+func (sg staleGroup) Source() string          { return sg.source }
+func (sg staleGroup) Targets() []model.Target { return nil }
+
+// Namespace watches namespaces and runs a discoverer for every namespace that matches the selectors.
+// When a namespace is deleted or stops matching, its discoverer is stopped and the sources it discovered become empty.
+type Namespace struct {
+	informer      cache.SharedInformer
+	queue         *workqueue.Type
+	selectorLabel labels.Selector
+	selectorField fields.Selector
+	newDiscoverer func(namespace string) discoverer
+
+	mu             sync.Mutex
+	running        map[string]*namespaceDiscoverer
+	onHealthChange []func()
+
+	health *health.Reporter
+	log    zerolog.Logger
+}
+
+type namespaceDiscoverer struct {
+	discoverer discoverer
+	cancel     context.CancelFunc
+	done       chan struct{}
+	sources    map[string]bool
+}
+
+func NewNamespace(inf cache.SharedInformer, newDiscoverer func(namespace string) discoverer) *Namespace {
+	if inf == nil || newDiscoverer == nil {
+		panic("nil namespace informer or discoverer factory")
+	}
+
+	queue := workqueue.NewWithConfig(workqueue.QueueConfig{Name: "namespace"})
+	inf.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { enqueue(queue, obj) },
+		UpdateFunc: func(_, obj interface{}) { enqueue(queue, obj) },
+		DeleteFunc: func(obj interface{}) { enqueue(queue, obj) },
+	})
+
+	return &Namespace{
+		informer:      inf,
+		queue:         queue,
+		selectorLabel: labels.Everything(),
+		selectorField: fields.Everything(),
+		newDiscoverer: newDiscoverer,
+		running:       make(map[string]*namespaceDiscoverer),
+		health:        health.NewReporter("k8s namespace discovery"),
+		log:           log.New("k8s namespace discovery"),
+	}
+}
+
+func (n *Namespace) String() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	namespaces := make([]string, 0, len(n.running))
+	for ns := range n.running {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	return fmt.Sprintf("k8s namespace discovery: %v", namespaces)
+}
+
+// Health returns the status of the namespace informer followed by the statuses of the running discoverers.
+func (n *Namespace) Health() []health.Status {
+	statuses := []health.Status{n.health.Status()}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	namespaces := make([]string, 0, len(n.running))
+	for ns := range n.running {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+
+	for _, ns := range namespaces {
+		if v, ok := n.running[ns].discoverer.(healthReporter); ok {
+			statuses = append(statuses, v.Health()...)
+		}
+	}
+	return statuses
+}
+
+// OnHealthChange registers the function with the namespace informer reporter and the running and future discoverers.
+func (n *Namespace) OnHealthChange(fn func()) {
+	n.health.OnChange(fn)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.onHealthChange = append(n.onHealthChange, fn)
+	for _, nd := range n.running {
+		if v, ok := nd.discoverer.(healthReporter); ok {
+			v.OnHealthChange(fn)
+		}
+	}
+}
+
+func (n *Namespace) Discover(ctx context.Context, in chan<- []model.Group) {
+	n.log.Info().Msg("instance is started")
+	defer n.log.Info().Msg("instance is stopped")
+	defer n.queue.ShutDown()
+
+	go n.informer.Run(ctx.Done())
+
+	if !k8s.DefaultSyncBackoff.WaitForCacheSync(ctx, n.log, n.health, n.informer) {
+		return
+	}
+
+	go n.run(ctx, in)
+	<-ctx.Done()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, nd := range n.running {
+		<-nd.done
+	}
+}
+
+func (n *Namespace) run(ctx context.Context, in chan<- []model.Group) {
+	initial := newInitialSync(n.queue, n.health)
+
+	for {
+		item, shutdown := n.queue.Get()
+		if shutdown {
+			return
+		}
+
+		func() {
+			defer n.queue.Done(item)
+
+			key := item.(string)
+			_, name, err := cache.SplitMetaNamespaceKey(key)
+			if err != nil {
+				return
+			}
+
+			item, exists, err := n.informer.GetStore().GetByKey(key)
+			if err != nil {
+				return
+			}
+
+			if exists {
+				ns, err := toNamespace(item)
+				if err != nil {
+					return
+				}
+				if n.matches(ns) {
+					n.start(ctx, in, name)
+					return
+				}
+			}
+			n.stop(ctx, in, name)
+		}()
+		initial.itemDone()
+	}
+}
+
+// matches checks the namespace against the selectors, the watch is already filtered by them,
+// but namespaces that stop matching must be stopped even if the API server doesn't report it.
+func (n *Namespace) matches(ns *apiv1.Namespace) bool {
+	return n.selectorLabel.Matches(labels.Set(ns.Labels)) &&
+		n.selectorField.Matches(fields.Set{"metadata.name": ns.Name, "status.phase": string(ns.Status.Phase)})
+}
+
+func (n *Namespace) start(ctx context.Context, in chan<- []model.Group, namespace string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if _, ok := n.running[namespace]; ok {
+		return
+	}
+
+	ddCtx, cancel := context.WithCancel(ctx)
+	nd := &namespaceDiscoverer{
+		discoverer: n.newDiscoverer(namespace),
+		cancel:     cancel,
+		done:       make(chan struct{}),
+		sources:    make(map[string]bool),
+	}
+	if v, ok := nd.discoverer.(healthReporter); ok {
+		for _, fn := range n.onHealthChange {
+			v.OnHealthChange(fn)
+		}
+	}
+	n.running[namespace] = nd
+	n.log.Info().Msgf("namespace '%s' is added, starting %s", namespace, nd.discoverer)
+
+	updates := make(chan []model.Group)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { defer wg.Done(); nd.discoverer.Discover(ddCtx, updates) }()
+	go func() { defer wg.Done(); nd.forward(ddCtx, updates, in) }()
+	go func() { wg.Wait(); close(nd.done) }()
+}
+
+func (n *Namespace) stop(ctx context.Context, in chan<- []model.Group, namespace string) {
+	n.mu.Lock()
+	nd, ok := n.running[namespace]
+	delete(n.running, namespace)
+	n.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	n.log.Info().Msgf("namespace '%s' is removed, stopping %s", namespace, nd.discoverer)
+	nd.cancel()
+	<-nd.done
+
+	if len(nd.sources) == 0 {
+		return
+	}
+	sources := make([]string, 0, len(nd.sources))
+	for source := range nd.sources {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	groups := make([]model.Group, 0, len(sources))
+	for _, source := range sources {
+		groups = append(groups, &staleGroup{source: source})
+	}
+	select {
+	case <-ctx.Done():
+	case in <- groups:
+	}
+}
+
+// forward sends the discoverer groups and keeps track of the sources that have targets.
+func (nd *namespaceDiscoverer) forward(ctx context.Context, updates chan []model.Group, in chan<- []model.Group) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case groups := <-updates:
+			for _, group := range groups {
+				if len(group.Targets()) > 0 {
+					nd.sources[group.Source()] = true
+				} else {
+					delete(nd.sources, group.Source())
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case in <- groups:
+			}
+		}
+	}
+}
+
+func toNamespace(item interface{}) (*apiv1.Namespace, error) {
+	ns, ok := item.(*apiv1.Namespace)
+	if !ok {
+		return nil, fmt.Errorf("received unexpected object type: %T", item)
+	}
+	return ns, nil
+}
@@ -0,0 +1,159 @@
+package kubernetes
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/netdata/sd/pipeline/model"
+
+	"github.com/stretchr/testify/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestNewNamespace(t *testing.T) {
+	newDiscoverer := func(string) discoverer { return nil }
+
+	tests := map[string]struct {
+		inf           cache.SharedInformer
+		newDiscoverer func(string) discoverer
+		wantPanic     bool
+	}{
+		"valid informer and factory": {
+			inf:           cache.NewSharedInformer(nil, &apiv1.Namespace{}, resyncPeriod),
+			newDiscoverer: newDiscoverer,
+		},
+		"nil informer": {newDiscoverer: newDiscoverer, wantPanic: true},
+		"nil factory": {
+			inf:       cache.NewSharedInformer(nil, &apiv1.Namespace{}, resyncPeriod),
+			wantPanic: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if test.wantPanic {
+				assert.Panics(t, func() { NewNamespace(test.inf, test.newDiscoverer) })
+			} else {
+				assert.IsType(t, &Namespace{}, NewNamespace(test.inf, test.newDiscoverer))
+			}
+		})
+	}
+}
+
+func TestNamespace_String(t *testing.T) {
+	ns := NewNamespace(cache.NewSharedInformer(nil, &apiv1.Namespace{}, resyncPeriod), func(string) discoverer { return nil })
+	assert.NotEmpty(t, ns.String())
+}
+
+func TestNamespace_Discover(t *testing.T) {
+	tests := map[string]func() discoverySim{
+		"ADD: matching namespaces exist before run": func() discoverySim {
+			prod, dev := newTeamNamespace("prod", "a"), newTeamNamespace("dev", "b")
+			httpd, nginx := newHTTPDPod(), newNGINXPod()
+			httpd.Namespace, nginx.Namespace = "prod", "dev"
+			discovery, _ := prepareAllNsDiscovery(RolePod, prod, dev, httpd, nginx)
+			discovery.nsSelectorLabel = "team=a"
+
+			sim := discoverySim{
+				discovery: discovery,
+				expectedGroups: []model.Group{
+					preparePodGroup(httpd),
+				},
+			}
+			return sim
+		},
+		"ADD: matching namespace added after sync": func() discoverySim {
+			prod, dev := newTeamNamespace("prod", "a"), newTeamNamespace("dev", "a")
+			httpd, nginx := newHTTPDPod(), newNGINXPod()
+			httpd.Namespace, nginx.Namespace = "prod", "dev"
+			discovery, clientset := prepareAllNsDiscovery(RolePod, prod, httpd, nginx)
+			discovery.nsSelectorLabel = "team=a"
+			nsClient := clientset.CoreV1().Namespaces()
+
+			sim := discoverySim{
+				discovery: discovery,
+				runAfterSync: func(ctx context.Context) {
+					time.Sleep(time.Millisecond * 50)
+					_, _ = nsClient.Create(ctx, dev, metav1.CreateOptions{})
+				},
+				expectedGroups: []model.Group{
+					preparePodGroup(httpd),
+					preparePodGroup(nginx),
+				},
+			}
+			return sim
+		},
+		"DELETE: namespace removed after sync": func() discoverySim {
+			prod := newTeamNamespace("prod", "a")
+			httpd, nginx := newHTTPDPod(), newNGINXPod()
+			httpd.Namespace, nginx.Namespace = "prod", "prod"
+			discovery, clientset := prepareAllNsDiscovery(RolePod, prod, httpd, nginx)
+			discovery.nsSelectorLabel = "team=a"
+			nsClient := clientset.CoreV1().Namespaces()
+
+			sim := discoverySim{
+				discovery: discovery,
+				runAfterSync: func(ctx context.Context) {
+					time.Sleep(time.Millisecond * 50)
+					_ = nsClient.Delete(ctx, prod.Name, metav1.DeleteOptions{})
+				},
+				expectedGroups: []model.Group{
+					preparePodGroup(httpd),
+					preparePodGroup(nginx),
+					&staleGroup{source: podSource(httpd)},
+					&staleGroup{source: podSource(nginx)},
+				},
+			}
+			return sim
+		},
+		"UPDATE: namespace stops matching after sync": func() discoverySim {
+			prod := newTeamNamespace("prod", "a")
+			httpd := newHTTPDPod()
+			httpd.Namespace = "prod"
+			discovery, clientset := prepareAllNsDiscovery(RolePod, prod, httpd)
+			discovery.nsSelectorLabel = "team=a"
+			nsClient := clientset.CoreV1().Namespaces()
+
+			sim := discoverySim{
+				discovery: discovery,
+				runAfterSync: func(ctx context.Context) {
+					time.Sleep(time.Millisecond * 50)
+					_, _ = nsClient.Update(ctx, newTeamNamespace("prod", "b"), metav1.UpdateOptions{})
+				},
+				expectedGroups: []model.Group{
+					preparePodGroup(httpd),
+					&staleGroup{source: podSource(httpd)},
+				},
+			}
+			return sim
+		},
+		"field selector": func() discoverySim {
+			prod, dev := newTeamNamespace("prod", "a"), newTeamNamespace("dev", "a")
+			httpd, nginx := newHTTPDPod(), newNGINXPod()
+			httpd.Namespace, nginx.Namespace = "prod", "dev"
+			discovery, _ := prepareAllNsDiscovery(RolePod, prod, dev, httpd, nginx)
+			discovery.nsSelectorField = "metadata.name=dev"
+
+			sim := discoverySim{
+				discovery: discovery,
+				expectedGroups: []model.Group{
+					preparePodGroup(nginx),
+				},
+			}
+			return sim
+		},
+	}
+
+	for name, sim := range tests {
+		t.Run(name, func(t *testing.T) { sim().run(t) })
+	}
+}
+
+func newTeamNamespace(name, team string) *apiv1.Namespace {
+	ns := newNamespace(name)
+	ns.Labels = map[string]string{"team": team}
+	return ns
+}
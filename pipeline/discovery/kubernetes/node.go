@@ -0,0 +1,266 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/netdata/sd/pipeline/model"
+	"github.com/netdata/sd/pkg/health"
+	"github.com/netdata/sd/pkg/k8s"
+	"github.com/netdata/sd/pkg/log"
+
+	"github.com/rs/zerolog"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+type (
+	nodeGroup struct {
+		targets []model.Target
+		source  string
+	}
+	NodeTarget struct {
+		model.Base `hash:"ignore"`
+		hash       uint64
+		tuid       string
+		Address    string
+
+		Cluster     string
+		Name        string
+		Annotations map[string]interface{}
+		Labels      map[string]interface{}
+		Taints      []NodeTaint
+		InternalIP  string
+		ExternalIP  string
+		Hostname    string
+		KubeletPort string
+		Ready       bool
+	}
+	NodeTaint struct {
+		Key    string
+		Value  string
+		Effect string
+	}
+)
+
+func (nt NodeTarget) Hash() uint64 { return nt.hash }
+func (nt NodeTarget) TUID() string { return nt.tuid }
+
+func (ng nodeGroup) Source() string          { return ng.source }
+func (ng nodeGroup) Targets() []model.Target { return ng.targets }
+
+type Node struct {
+	informer cache.SharedInformer
+	queue    *workqueue.Type
+	cluster  string
+	health   *health.Reporter
+	log      zerolog.Logger
+}
+
+func NewNode(inf cache.SharedInformer) *Node {
+	queue := workqueue.NewWithConfig(workqueue.QueueConfig{Name: "node"})
+	inf.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { enqueue(queue, obj) },
+		UpdateFunc: func(_, obj interface{}) { enqueue(queue, obj) },
+		DeleteFunc: func(obj interface{}) { enqueue(queue, obj) },
+	})
+
+	return &Node{
+		informer: inf,
+		queue:    queue,
+		health:   health.NewReporter("k8s node discovery"),
+		log:      log.New("k8s node discovery"),
+	}
+}
+
+func (n Node) String() string {
+	return fmt.Sprintf("k8s %s discovery", RoleNode)
+}
+
+func (n *Node) Health() []health.Status {
+	return []health.Status{n.health.Status()}
+}
+
+func (n *Node) OnHealthChange(fn func()) { n.health.OnChange(fn) }
+
+func (n *Node) Discover(ctx context.Context, in chan<- []model.Group) {
+	n.log.Info().Msg("instance is started")
+	defer n.log.Info().Msg("instance is stopped")
+	defer n.queue.ShutDown()
+
+	go n.informer.Run(ctx.Done())
+
+	if !k8s.DefaultSyncBackoff.WaitForCacheSync(ctx, n.log, n.health, n.informer) {
+		return
+	}
+
+	go n.run(ctx, in)
+	<-ctx.Done()
+}
+
+func (n *Node) run(ctx context.Context, in chan<- []model.Group) {
+	initial := newInitialSync(n.queue, n.health)
+
+	for {
+		item, shutdown := n.queue.Get()
+		if shutdown {
+			return
+		}
+
+		func() {
+			defer n.queue.Done(item)
+
+			key := item.(string)
+			_, name, err := cache.SplitMetaNamespaceKey(key)
+			if err != nil {
+				return
+			}
+
+			item, exists, err := n.informer.GetStore().GetByKey(key)
+			if err != nil {
+				return
+			}
+
+			if !exists {
+				group := &nodeGroup{source: clusterSource(n.cluster, nodeSourceFromName(name))}
+				send(ctx, in, group)
+				return
+			}
+
+			node, err := toNode(item)
+			if err != nil {
+				return
+			}
+
+			group := n.buildGroup(node)
+			send(ctx, in, group)
+		}()
+		initial.itemDone()
+	}
+}
+
+func (n Node) buildGroup(node *apiv1.Node) model.Group {
+	target := n.buildTarget(node)
+	if target == nil {
+		return &nodeGroup{
+			source: clusterSource(n.cluster, nodeSource(node)),
+		}
+	}
+	return &nodeGroup{
+		source:  clusterSource(n.cluster, nodeSource(node)),
+		targets: []model.Target{target},
+	}
+}
+
+func (n Node) buildTarget(node *apiv1.Node) model.Target {
+	internalIP, externalIP, hostname := nodeAddresses(node)
+
+	host := firstNotEmpty(internalIP, externalIP, hostname)
+	if host == "" {
+		return nil
+	}
+
+	var port string
+	if v := node.Status.DaemonEndpoints.KubeletEndpoint.Port; v > 0 {
+		port = strconv.FormatInt(int64(v), 10)
+	}
+
+	target := &NodeTarget{
+		tuid:        clusterTUID(n.cluster, nodeTUID(node)),
+		Address:     host,
+		Cluster:     n.cluster,
+		Name:        node.Name,
+		Annotations: toMapInterface(node.Annotations),
+		Labels:      toMapInterface(node.Labels),
+		Taints:      toNodeTaints(node.Spec.Taints),
+		InternalIP:  internalIP,
+		ExternalIP:  externalIP,
+		Hostname:    hostname,
+		KubeletPort: port,
+		Ready:       isNodeReady(node),
+	}
+	if port != "" {
+		target.Address = net.JoinHostPort(host, port)
+	}
+
+	hash, err := calcHash(target)
+	if err != nil {
+		return nil
+	}
+	target.hash = hash
+
+	return target
+}
+
+// nodeAddresses returns the first internal IP, external IP and hostname addresses of the node.
+func nodeAddresses(node *apiv1.Node) (internalIP, externalIP, hostname string) {
+	for _, addr := range node.Status.Addresses {
+		switch addr.Type {
+		case apiv1.NodeInternalIP:
+			if internalIP == "" {
+				internalIP = addr.Address
+			}
+		case apiv1.NodeExternalIP:
+			if externalIP == "" {
+				externalIP = addr.Address
+			}
+		case apiv1.NodeHostName:
+			if hostname == "" {
+				hostname = addr.Address
+			}
+		}
+	}
+	return internalIP, externalIP, hostname
+}
+
+func isNodeReady(node *apiv1.Node) bool {
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == apiv1.NodeReady {
+			return cond.Status == apiv1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func toNodeTaints(taints []apiv1.Taint) []NodeTaint {
+	if len(taints) == 0 {
+		return nil
+	}
+	v := make([]NodeTaint, 0, len(taints))
+	for _, taint := range taints {
+		v = append(v, NodeTaint{Key: taint.Key, Value: taint.Value, Effect: string(taint.Effect)})
+	}
+	return v
+}
+
+func nodeTUID(node *apiv1.Node) string {
+	return node.Name
+}
+
+func nodeSourceFromName(name string) string {
+	return "k8s/node/" + name
+}
+
+func nodeSource(node *apiv1.Node) string {
+	return nodeSourceFromName(node.Name)
+}
+
+func toNode(item interface{}) (*apiv1.Node, error) {
+	node, ok := item.(*apiv1.Node)
+	if !ok {
+		return nil, fmt.Errorf("received unexpected object type: %T", item)
+	}
+	return node, nil
+}
+
+func firstNotEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,251 @@
+package kubernetes
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/netdata/sd/pipeline/model"
+
+	"github.com/stretchr/testify/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestNodeGroup_Source(t *testing.T) {
+	tests := map[string]struct {
+		sim            func() discoverySim
+		expectedSource []string
+	}{
+		"multiple nodes": {
+			sim: func() discoverySim {
+				m01, m02 := newNode("m01", "192.168.0.1"), newNode("m02", "192.168.0.2")
+				discovery, _ := prepareAllNsDiscovery(RoleNode, m01, m02)
+
+				sim := discoverySim{
+					discovery: discovery,
+					expectedGroups: []model.Group{
+						prepareNodeGroup(m01),
+						prepareNodeGroup(m02),
+					},
+				}
+				return sim
+			},
+			expectedSource: []string{
+				"k8s/node/m01",
+				"k8s/node/m02",
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			sim := test.sim()
+			var actual []string
+			for _, group := range sim.run(t) {
+				actual = append(actual, group.Source())
+			}
+
+			assert.Equal(t, test.expectedSource, actual)
+		})
+	}
+}
+
+func TestNodeTarget_TUID(t *testing.T) {
+	tests := map[string]struct {
+		sim          func() discoverySim
+		expectedTUID []string
+	}{
+		"multiple nodes": {
+			sim: func() discoverySim {
+				m01, m02 := newNode("m01", "192.168.0.1"), newNode("m02", "192.168.0.2")
+				discovery, _ := prepareAllNsDiscovery(RoleNode, m01, m02)
+
+				sim := discoverySim{
+					discovery: discovery,
+					expectedGroups: []model.Group{
+						prepareNodeGroup(m01),
+						prepareNodeGroup(m02),
+					},
+				}
+				return sim
+			},
+			expectedTUID: []string{
+				"m01",
+				"m02",
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			sim := test.sim()
+			var actual []string
+			for _, group := range sim.run(t) {
+				for _, tg := range group.Targets() {
+					actual = append(actual, tg.TUID())
+				}
+			}
+
+			assert.Equal(t, test.expectedTUID, actual)
+		})
+	}
+}
+
+func TestNewNode(t *testing.T) {
+	tests := map[string]struct {
+		informer  cache.SharedInformer
+		wantPanic bool
+	}{
+		"valid informer": {informer: cache.NewSharedInformer(nil, &apiv1.Node{}, resyncPeriod)},
+		"nil informer":   {wantPanic: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if test.wantPanic {
+				assert.Panics(t, func() { NewNode(nil) })
+			} else {
+				assert.IsType(t, &Node{}, NewNode(test.informer))
+			}
+		})
+	}
+}
+
+func TestNode_String(t *testing.T) {
+	assert.NotEmpty(t, Node{}.String())
+}
+
+func TestNode_Discover(t *testing.T) {
+	tests := map[string]func() discoverySim{
+		"ADD: nodes exist before run": func() discoverySim {
+			m01, m02 := newNode("m01", "192.168.0.1"), newNode("m02", "192.168.0.2")
+			discovery, _ := prepareAllNsDiscovery(RoleNode, m01, m02)
+
+			sim := discoverySim{
+				discovery: discovery,
+				expectedGroups: []model.Group{
+					prepareNodeGroup(m01),
+					prepareNodeGroup(m02),
+				},
+			}
+			return sim
+		},
+		"DELETE: remove nodes after sync": func() discoverySim {
+			m01, m02 := newNode("m01", "192.168.0.1"), newNode("m02", "192.168.0.2")
+			discovery, clientset := prepareAllNsDiscovery(RoleNode, m01, m02)
+			nodeClient := clientset.CoreV1().Nodes()
+
+			sim := discoverySim{
+				discovery: discovery,
+				runAfterSync: func(ctx context.Context) {
+					time.Sleep(time.Millisecond * 50)
+					_ = nodeClient.Delete(ctx, m01.Name, metav1.DeleteOptions{})
+					_ = nodeClient.Delete(ctx, m02.Name, metav1.DeleteOptions{})
+				},
+				expectedGroups: []model.Group{
+					prepareNodeGroup(m01),
+					prepareNodeGroup(m02),
+					prepareEmptyNodeGroup(m01),
+					prepareEmptyNodeGroup(m02),
+				},
+			}
+			return sim
+		},
+		"UPDATE: node becomes not ready after sync": func() discoverySim {
+			m01 := newNode("m01", "192.168.0.1")
+			m01Upd := m01.DeepCopy()
+			m01Upd.Status.Conditions[0].Status = apiv1.ConditionFalse
+			discovery, clientset := prepareAllNsDiscovery(RoleNode, m01)
+			nodeClient := clientset.CoreV1().Nodes()
+
+			sim := discoverySim{
+				discovery: discovery,
+				runAfterSync: func(ctx context.Context) {
+					time.Sleep(time.Millisecond * 50)
+					_, _ = nodeClient.Update(ctx, m01Upd, metav1.UpdateOptions{})
+				},
+				expectedGroups: []model.Group{
+					prepareNodeGroup(m01),
+					prepareNodeGroup(m01Upd),
+				},
+			}
+			return sim
+		},
+		"ADD: nodes without addresses": func() discoverySim {
+			m01, m02 := newNode("m01", "192.168.0.1"), newNode("m02", "192.168.0.2")
+			m01.Status.Addresses = nil
+			m02.Status.Addresses = nil
+			discovery, _ := prepareAllNsDiscovery(RoleNode, m01, m02)
+
+			sim := discoverySim{
+				discovery: discovery,
+				expectedGroups: []model.Group{
+					prepareEmptyNodeGroup(m01),
+					prepareEmptyNodeGroup(m02),
+				},
+			}
+			return sim
+		},
+	}
+
+	for name, sim := range tests {
+		t.Run(name, func(t *testing.T) { sim().run(t) })
+	}
+}
+
+func newNode(name, internalIP string) *apiv1.Node {
+	return &apiv1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Annotations: map[string]string{"phase": "prod"},
+			Labels:      map[string]string{"kubernetes.io/hostname": name},
+		},
+		Spec: apiv1.NodeSpec{
+			Taints: []apiv1.Taint{
+				{Key: "node-role.kubernetes.io/control-plane", Effect: apiv1.TaintEffectNoSchedule},
+			},
+		},
+		Status: apiv1.NodeStatus{
+			Addresses: []apiv1.NodeAddress{
+				{Type: apiv1.NodeInternalIP, Address: internalIP},
+				{Type: apiv1.NodeHostName, Address: name},
+			},
+			DaemonEndpoints: apiv1.NodeDaemonEndpoints{
+				KubeletEndpoint: apiv1.DaemonEndpoint{Port: 10250},
+			},
+			Conditions: []apiv1.NodeCondition{
+				{Type: apiv1.NodeReady, Status: apiv1.ConditionTrue},
+			},
+		},
+	}
+}
+
+func prepareEmptyNodeGroup(node *apiv1.Node) *nodeGroup {
+	return &nodeGroup{source: nodeSource(node)}
+}
+
+func prepareNodeGroup(node *apiv1.Node) *nodeGroup {
+	group := prepareEmptyNodeGroup(node)
+	port := strconv.FormatInt(int64(node.Status.DaemonEndpoints.KubeletEndpoint.Port), 10)
+	internalIP := node.Status.Addresses[0].Address
+	target := &NodeTarget{
+		tuid:        nodeTUID(node),
+		Address:     net.JoinHostPort(internalIP, port),
+		Name:        node.Name,
+		Annotations: toMapInterface(node.Annotations),
+		Labels:      toMapInterface(node.Labels),
+		Taints:      toNodeTaints(node.Spec.Taints),
+		InternalIP:  internalIP,
+		Hostname:    node.Name,
+		KubeletPort: port,
+		Ready:       node.Status.Conditions[0].Status == apiv1.ConditionTrue,
+	}
+	target.hash = mustCalcHash(target)
+	target.Tags().Merge(discoveryTags)
+	group.targets = append(group.targets, target)
+	return group
+}
@@ -3,15 +3,25 @@ package kubernetes
 import (
 	"context"
 	"fmt"
+	"math"
 	"net"
+	"reflect"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/netdata/sd/pipeline/model"
+	"github.com/netdata/sd/pkg/health"
+	"github.com/netdata/sd/pkg/k8s"
 	"github.com/netdata/sd/pkg/log"
 
 	"github.com/rs/zerolog"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
 )
@@ -27,22 +37,47 @@ type (
 		tuid       string
 		Address    string
 
+		Cluster     string
 		Namespace   string
 		Name        string
 		Annotations map[string]interface{}
 		Labels      map[string]interface{}
 		NodeName    string
 		PodIP       string
+		Phase       string
+		Ready       bool
+		QOSClass    string
+		StartTime   string
 
 		ControllerName string
 		ControllerKind string
-
-		ContName     string
-		Image        string
-		Env          map[string]interface{}
-		Port         string
-		PortName     string
-		PortProtocol string
+		WorkloadName   string
+		WorkloadKind   string
+
+		Scrape        bool
+		MetricsPort   string
+		MetricsPath   string
+		MetricsScheme string
+
+		NodeLabels      map[string]interface{}
+		NodeAnnotations map[string]interface{}
+		NodeZone        string
+		NodeRegion      string
+		NodeInternalIP  string
+		NodeExternalIP  string
+		NodeHostname    string
+
+		ContName         string
+		ContainerKind    string
+		Image            string
+		Env              map[string]interface{}
+		ContReady        bool
+		ContRestartCount int
+		ContState        string
+		ContStateReason  string
+		Port             string
+		PortName         string
+		PortProtocol     string
 	}
 )
 
@@ -56,46 +91,114 @@ type Pod struct {
 	podInformer    cache.SharedInformer
 	cmapInformer   cache.SharedInformer
 	secretInformer cache.SharedInformer
+	rsInformer     cache.SharedInformer
+	jobInformer    cache.SharedInformer
+	nodeInformer   cache.SharedInformer
 	queue          *workqueue.Type
+	cluster        string
+	readyOnly      bool
+	redactSecrets  bool
+	initConts      bool
+	sidecarConts   bool
+	ephemeralConts bool
+	health         *health.Reporter
 	log            zerolog.Logger
 }
 
+const (
+	containerKindRegular   = "container"
+	containerKindInit      = "init"
+	containerKindSidecar   = "sidecar"
+	containerKindEphemeral = "ephemeral"
+)
+
+// NewPod creates a pod discoverer. ConfigMap and Secret informers are optional, env values from a source are not
+// resolved if its informer is nil.
 func NewPod(pod, cmap, secret cache.SharedInformer) *Pod {
+	if pod == nil {
+		panic("nil pod informer")
+	}
+
 	queue := workqueue.NewWithConfig(workqueue.QueueConfig{Name: "pod"})
 	pod.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc:    func(obj interface{}) { enqueue(queue, obj) },
 		UpdateFunc: func(_, obj interface{}) { enqueue(queue, obj) },
 		DeleteFunc: func(obj interface{}) { enqueue(queue, obj) },
 	})
-	if cmap == nil || secret == nil {
-		panic("nil cmap or secret informer")
-	}
 
 	return &Pod{
 		podInformer:    pod,
 		cmapInformer:   cmap,
 		secretInformer: secret,
 		queue:          queue,
+		health:         health.NewReporter("k8s pod discovery"),
 		log:            log.New("k8s pod discovery"),
 	}
 }
 
+// setWorkloadInformers sets ReplicaSet and Job informers used to resolve pods workloads (Deployment and CronJob).
+// Pods are re-emitted when their owner is added or its controller changes.
+func (p *Pod) setWorkloadInformers(rs, job cache.SharedInformer) {
+	for _, inf := range []cache.SharedInformer{rs, job} {
+		if inf == nil {
+			continue
+		}
+		inf.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) { p.enqueueOwnedPods(obj) },
+			UpdateFunc: func(oldObj, obj interface{}) {
+				if !sameController(oldObj, obj) {
+					p.enqueueOwnedPods(obj)
+				}
+			},
+		})
+	}
+	p.rsInformer, p.jobInformer = rs, job
+}
+
+// setNodeInformer sets the node informer used to add the pods node metadata to the targets.
+// Pods are re-emitted when their node is added or its labels, annotations or addresses change.
+func (p *Pod) setNodeInformer(inf cache.SharedInformer) {
+	if inf == nil {
+		return
+	}
+	inf.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) { p.enqueueNodePods(obj) },
+		UpdateFunc: func(oldObj, obj interface{}) {
+			if !sameNodeMetadata(oldObj, obj) {
+				p.enqueueNodePods(obj)
+			}
+		},
+	})
+	p.nodeInformer = inf
+}
+
 func (p Pod) String() string {
 	return fmt.Sprintf("k8s %s discovery", RolePod)
 }
 
+func (p *Pod) Health() []health.Status {
+	return []health.Status{p.health.Status()}
+}
+
+func (p *Pod) OnHealthChange(fn func()) { p.health.OnChange(fn) }
+
 func (p *Pod) Discover(ctx context.Context, in chan<- []model.Group) {
 	p.log.Info().Msg("instance is started")
 	defer p.log.Info().Msg("instance is stopped")
 	defer p.queue.ShutDown()
 
+	informers := []cache.SharedInformer{p.podInformer}
 	go p.podInformer.Run(ctx.Done())
-	go p.cmapInformer.Run(ctx.Done())
-	go p.secretInformer.Run(ctx.Done())
+	for _, inf := range []cache.SharedInformer{
+		p.cmapInformer, p.secretInformer, p.rsInformer, p.jobInformer, p.nodeInformer,
+	} {
+		if inf != nil {
+			informers = append(informers, inf)
+			go inf.Run(ctx.Done())
+		}
+	}
 
-	if !cache.WaitForCacheSync(ctx.Done(),
-		p.podInformer.HasSynced, p.cmapInformer.HasSynced, p.secretInformer.HasSynced) {
-		p.log.Error().Msg("failed to sync caches")
+	if !k8s.DefaultSyncBackoff.WaitForCacheSync(ctx, p.log, p.health, informers...) {
 		return
 	}
 
@@ -104,6 +207,8 @@ func (p *Pod) Discover(ctx context.Context, in chan<- []model.Group) {
 }
 
 func (p *Pod) run(ctx context.Context, in chan<- []model.Group) {
+	initial := newInitialSync(p.queue, p.health)
+
 	for {
 		item, shutdown := p.queue.Get()
 		if shutdown {
@@ -125,7 +230,7 @@ func (p *Pod) run(ctx context.Context, in chan<- []model.Group) {
 			}
 
 			if !exists {
-				group := &podGroup{source: podSourceFromNsName(namespace, name)}
+				group := &podGroup{source: clusterSource(p.cluster, podSourceFromNsName(namespace, name))}
 				send(ctx, in, group)
 				return
 			}
@@ -138,50 +243,63 @@ func (p *Pod) run(ctx context.Context, in chan<- []model.Group) {
 			group := p.buildGroup(pod)
 			send(ctx, in, group)
 		}()
+		initial.itemDone()
 	}
 }
 
 func (p Pod) buildGroup(pod *apiv1.Pod) model.Group {
-	if pod.Status.PodIP == "" || len(pod.Spec.Containers) == 0 {
+	if pod.Status.PodIP == "" {
 		return &podGroup{
-			source: podSource(pod),
+			source: clusterSource(p.cluster, podSource(pod)),
 		}
 	}
 	return &podGroup{
-		source:  podSource(pod),
+		source:  clusterSource(p.cluster, podSource(pod)),
 		targets: p.buildTargets(pod),
 	}
 }
 
 func (p Pod) buildTargets(pod *apiv1.Pod) (targets []model.Target) {
 	var name, kind string
+	var uid types.UID
 	for _, ref := range pod.OwnerReferences {
 		if ref.Controller != nil && *ref.Controller {
 			name = ref.Name
 			kind = ref.Kind
+			uid = ref.UID
 			break
 		}
 	}
 
-	for _, container := range pod.Spec.Containers {
-		env := p.collectEnv(pod.Namespace, container)
-
-		if len(container.Ports) == 0 {
-			target := &PodTarget{
-				tuid:           podTUID(pod, container),
-				Address:        pod.Status.PodIP,
-				Namespace:      pod.Namespace,
-				Name:           pod.Name,
-				Annotations:    toMapInterface(pod.Annotations),
-				Labels:         toMapInterface(pod.Labels),
-				NodeName:       pod.Spec.NodeName,
-				PodIP:          pod.Status.PodIP,
-				ControllerName: name,
-				ControllerKind: kind,
-				ContName:       container.Name,
-				Image:          container.Image,
-				Env:            toMapInterface(env),
-			}
+	wlName, wlKind := p.workload(pod.Namespace, name, kind, uid)
+	node := p.podNode(pod)
+
+	conts := p.podContainers(pod)
+	// a target is added for the annotated metrics port to the first regular container if no container declares it
+	metricsPort, addMetricsPort := undeclaredMetricsPort(conts, parseScrapeAnnotations(pod.Annotations))
+
+	for _, pc := range conts {
+		container := pc.container
+		if p.readyOnly && !pc.status.Ready {
+			continue
+		}
+		env := p.collectEnv(pod, container)
+
+		ports := container.Ports
+		if addMetricsPort && pc.kind == containerKindRegular {
+			ports = append(ports[:len(ports):len(ports)], metricsPort)
+			addMetricsPort = false
+		}
+
+		if len(ports) == 0 {
+			target := p.newTarget(pod, node, pc, env)
+			target.tuid = clusterTUID(p.cluster, podTUID(pod, container))
+			target.Address = pod.Status.PodIP
+			target.ControllerName = name
+			target.ControllerKind = kind
+			target.WorkloadName = wlName
+			target.WorkloadKind = wlKind
+
 			hash, err := calcHash(target)
 			if err != nil {
 				continue
@@ -190,26 +308,19 @@ func (p Pod) buildTargets(pod *apiv1.Pod) (targets []model.Target) {
 
 			targets = append(targets, target)
 		} else {
-			for _, port := range container.Ports {
+			for _, port := range ports {
 				portNum := strconv.FormatUint(uint64(port.ContainerPort), 10)
-				target := &PodTarget{
-					tuid:           podTUIDWithPort(pod, container, port),
-					Address:        net.JoinHostPort(pod.Status.PodIP, portNum),
-					Namespace:      pod.Namespace,
-					Name:           pod.Name,
-					Annotations:    toMapInterface(pod.Annotations),
-					Labels:         toMapInterface(pod.Labels),
-					NodeName:       pod.Spec.NodeName,
-					PodIP:          pod.Status.PodIP,
-					ControllerName: name,
-					ControllerKind: kind,
-					ContName:       container.Name,
-					Image:          container.Image,
-					Env:            toMapInterface(env),
-					Port:           portNum,
-					PortName:       port.Name,
-					PortProtocol:   string(port.Protocol),
-				}
+				target := p.newTarget(pod, node, pc, env)
+				target.tuid = clusterTUID(p.cluster, podTUIDWithPort(pod, container, port))
+				target.Address = net.JoinHostPort(pod.Status.PodIP, portNum)
+				target.ControllerName = name
+				target.ControllerKind = kind
+				target.WorkloadName = wlName
+				target.WorkloadKind = wlKind
+				target.Port = portNum
+				target.PortName = port.Name
+				target.PortProtocol = string(port.Protocol)
+
 				hash, err := calcHash(target)
 				if err != nil {
 					continue
@@ -223,8 +334,50 @@ func (p Pod) buildTargets(pod *apiv1.Pod) (targets []model.Target) {
 	return targets
 }
 
-func (p Pod) collectEnv(ns string, container apiv1.Container) map[string]string {
+func (p Pod) newTarget(pod *apiv1.Pod, node *apiv1.Node, pc podContainer, env map[string]string) *PodTarget {
+	container, status := pc.container, pc.status
+	state, reason := containerState(status.State)
+	sa := parseScrapeAnnotations(pod.Annotations)
+	target := &PodTarget{
+		Cluster:          p.cluster,
+		Namespace:        pod.Namespace,
+		Name:             pod.Name,
+		Annotations:      toMapInterface(pod.Annotations),
+		Labels:           toMapInterface(pod.Labels),
+		NodeName:         pod.Spec.NodeName,
+		PodIP:            pod.Status.PodIP,
+		Phase:            string(pod.Status.Phase),
+		Ready:            isPodReady(pod),
+		QOSClass:         string(pod.Status.QOSClass),
+		StartTime:        formatTime(pod.Status.StartTime),
+		Scrape:           sa.scrape,
+		MetricsPort:      sa.port,
+		MetricsPath:      sa.path,
+		MetricsScheme:    sa.scheme,
+		ContName:         container.Name,
+		ContainerKind:    pc.kind,
+		Image:            container.Image,
+		Env:              toMapInterface(env),
+		ContReady:        status.Ready,
+		ContRestartCount: int(status.RestartCount),
+		ContState:        state,
+		ContStateReason:  reason,
+	}
+	if node != nil {
+		target.NodeLabels = toMapInterface(node.Labels)
+		target.NodeAnnotations = toMapInterface(node.Annotations)
+		target.NodeZone = firstNotEmpty(
+			node.Labels[apiv1.LabelTopologyZone], node.Labels[apiv1.LabelFailureDomainBetaZone])
+		target.NodeRegion = firstNotEmpty(
+			node.Labels[apiv1.LabelTopologyRegion], node.Labels[apiv1.LabelFailureDomainBetaRegion])
+		target.NodeInternalIP, target.NodeExternalIP, target.NodeHostname = nodeAddresses(node)
+	}
+	return target
+}
+
+func (p Pod) collectEnv(pod *apiv1.Pod, container apiv1.Container) map[string]string {
 	vars := make(map[string]string)
+	ns := pod.Namespace
 
 	// When a key exists in multiple sources,
 	// the value associated with the last source will take precedence.
@@ -244,15 +397,24 @@ func (p Pod) collectEnv(ns string, container apiv1.Container) map[string]string
 	}
 
 	for _, env := range container.Env {
-		if env.Name == "" || isVar(env.Name) {
+		if env.Name == "" {
 			continue
 		}
 		switch {
-		case env.Value != "":
-			vars[env.Name] = env.Value
-		case env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil:
+		case env.ValueFrom == nil:
+			// like the kubelet, expand references to the previously defined variables
+			vars[env.Name] = expandVars(env.Value, vars)
+		case env.ValueFrom.FieldRef != nil:
+			if v, ok := podFieldValue(pod, env.ValueFrom.FieldRef.FieldPath); ok {
+				vars[env.Name] = v
+			}
+		case env.ValueFrom.ResourceFieldRef != nil:
+			if v, ok := containerResourceValue(pod, container, env.ValueFrom.ResourceFieldRef); ok {
+				vars[env.Name] = v
+			}
+		case env.ValueFrom.SecretKeyRef != nil:
 			p.valueFromSecret(vars, ns, env)
-		case env.ValueFrom != nil && env.ValueFrom.ConfigMapKeyRef != nil:
+		case env.ValueFrom.ConfigMapKeyRef != nil:
 			p.valueFromConfigMap(vars, ns, env)
 		}
 	}
@@ -263,7 +425,7 @@ func (p Pod) collectEnv(ns string, container apiv1.Container) map[string]string
 }
 
 func (p Pod) valueFromConfigMap(vars map[string]string, ns string, env apiv1.EnvVar) {
-	if env.ValueFrom.ConfigMapKeyRef.Name == "" || env.ValueFrom.ConfigMapKeyRef.Key == "" {
+	if env.ValueFrom.ConfigMapKeyRef.Name == "" || env.ValueFrom.ConfigMapKeyRef.Key == "" || p.cmapInformer == nil {
 		return
 	}
 
@@ -283,7 +445,7 @@ func (p Pod) valueFromConfigMap(vars map[string]string, ns string, env apiv1.Env
 }
 
 func (p Pod) valueFromSecret(vars map[string]string, ns string, env apiv1.EnvVar) {
-	if env.ValueFrom.SecretKeyRef.Name == "" || env.ValueFrom.SecretKeyRef.Key == "" {
+	if env.ValueFrom.SecretKeyRef.Name == "" || env.ValueFrom.SecretKeyRef.Key == "" || p.secretInformer == nil {
 		return
 	}
 
@@ -298,12 +460,12 @@ func (p Pod) valueFromSecret(vars map[string]string, ns string, env apiv1.EnvVar
 		return
 	}
 	if v, ok := secret.Data[sr.Key]; ok {
-		vars[env.Name] = string(v)
+		vars[env.Name] = p.secretValue(v)
 	}
 }
 
 func (p Pod) envFromConfigMap(vars map[string]string, ns string, src apiv1.EnvFromSource) {
-	if src.ConfigMapRef.Name == "" {
+	if src.ConfigMapRef.Name == "" || p.cmapInformer == nil {
 		return
 	}
 	key := ns + "/" + src.ConfigMapRef.Name
@@ -321,7 +483,7 @@ func (p Pod) envFromConfigMap(vars map[string]string, ns string, src apiv1.EnvFr
 }
 
 func (p Pod) envFromSecret(vars map[string]string, ns string, src apiv1.EnvFromSource) {
-	if src.SecretRef.Name == "" {
+	if src.SecretRef.Name == "" || p.secretInformer == nil {
 		return
 	}
 	key := ns + "/" + src.SecretRef.Name
@@ -334,10 +496,265 @@ func (p Pod) envFromSecret(vars map[string]string, ns string, src apiv1.EnvFromS
 		return
 	}
 	for k, v := range secret.Data {
-		vars[src.Prefix+k] = string(v)
+		vars[src.Prefix+k] = p.secretValue(v)
 	}
 }
 
+// secretValue returns the secret value, or an empty string if secrets are redacted and only their keys are exposed.
+func (p Pod) secretValue(v []byte) string {
+	if p.redactSecrets {
+		return ""
+	}
+	return string(v)
+}
+
+// workload returns the top-level owner of a pod controlled by a ReplicaSet or a Job: their controller (Deployment,
+// CronJob, ...) if they have one. Otherwise, or if the owner is not found in the cache, it returns the pod controller.
+func (p Pod) workload(namespace, name, kind string, uid types.UID) (string, string) {
+	var inf cache.SharedInformer
+	switch kind {
+	case "ReplicaSet":
+		inf = p.rsInformer
+	case "Job":
+		inf = p.jobInformer
+	}
+	if inf == nil {
+		return name, kind
+	}
+
+	item, exists, err := inf.GetStore().GetByKey(namespace + "/" + name)
+	if err != nil || !exists {
+		return name, kind
+	}
+	owner, ok := item.(metav1.Object)
+	if !ok || owner.GetUID() != uid {
+		// an owner with another UID is a recreated object, not the pod controller
+		return name, kind
+	}
+	if ref := metav1.GetControllerOf(owner); ref != nil {
+		return ref.Name, ref.Kind
+	}
+	return name, kind
+}
+
+func (p *Pod) enqueueOwnedPods(obj interface{}) {
+	owner, ok := obj.(metav1.Object)
+	if !ok {
+		return
+	}
+	kind := ownerKind(obj)
+	for _, item := range p.ownedPods(owner) {
+		pod, ok := item.(*apiv1.Pod)
+		if !ok || pod.Namespace != owner.GetNamespace() {
+			continue
+		}
+		if ref := metav1.GetControllerOf(pod); ref != nil && isRefTo(ref, kind, owner) {
+			enqueue(p.queue, pod)
+		}
+	}
+}
+
+// ownedPods returns the pods controlled by the owner using the pod informer controller index,
+// all the pods are returned if the informer has no such index.
+func (p *Pod) ownedPods(owner metav1.Object) []interface{} {
+	if inf, ok := p.podInformer.(cache.SharedIndexInformer); ok {
+		if items, err := inf.GetIndexer().ByIndex(podControllerIndex, string(owner.GetUID())); err == nil {
+			return items
+		}
+	}
+	return p.podInformer.GetStore().List()
+}
+
+// podControllerIndex is the name of the pod informers index by the UID of the pods controller.
+const podControllerIndex = "metadata.ownerReferences.controller.uid"
+
+func podControllerIndexFunc(obj interface{}) ([]string, error) {
+	pod, err := toPod(obj)
+	if err != nil {
+		return nil, nil
+	}
+	ref := metav1.GetControllerOf(pod)
+	if ref == nil || ref.UID == "" {
+		return nil, nil
+	}
+	return []string{string(ref.UID)}, nil
+}
+
+// ownerKind returns the kind of the workload informers objects, objects from informers have no TypeMeta set.
+func ownerKind(obj interface{}) string {
+	switch obj.(type) {
+	case *appsv1.ReplicaSet:
+		return "ReplicaSet"
+	case *batchv1.Job:
+		return "Job"
+	default:
+		return ""
+	}
+}
+
+// isRefTo reports whether the reference points to the object, a recreated object with the same name has another UID.
+func isRefTo(ref *metav1.OwnerReference, kind string, obj metav1.Object) bool {
+	return ref.Kind == kind && ref.Name == obj.GetName() && ref.UID == obj.GetUID()
+}
+
+func sameController(oldObj, obj interface{}) bool {
+	o1, ok1 := oldObj.(metav1.Object)
+	o2, ok2 := obj.(metav1.Object)
+	if !ok1 || !ok2 {
+		return false
+	}
+	ref1, ref2 := metav1.GetControllerOf(o1), metav1.GetControllerOf(o2)
+	if ref1 == nil || ref2 == nil {
+		return ref1 == ref2
+	}
+	return ref1.Kind == ref2.Kind && ref1.Name == ref2.Name && ref1.UID == ref2.UID
+}
+
+// podNode returns the node the pod is scheduled to, nil if there is no node informer or the node is not found.
+func (p Pod) podNode(pod *apiv1.Pod) *apiv1.Node {
+	if p.nodeInformer == nil || pod.Spec.NodeName == "" {
+		return nil
+	}
+	item, exists, err := p.nodeInformer.GetStore().GetByKey(pod.Spec.NodeName)
+	if err != nil || !exists {
+		return nil
+	}
+	node, err := toNode(item)
+	if err != nil {
+		return nil
+	}
+	return node
+}
+
+func (p *Pod) enqueueNodePods(obj interface{}) {
+	node, err := toNode(obj)
+	if err != nil {
+		return
+	}
+	for _, item := range p.nodePods(node.Name) {
+		if pod, ok := item.(*apiv1.Pod); ok && pod.Spec.NodeName == node.Name {
+			enqueue(p.queue, pod)
+		}
+	}
+}
+
+// nodePods returns the pods scheduled to the node using the pod informer node index,
+// all the pods are returned if the informer has no such index.
+func (p *Pod) nodePods(nodeName string) []interface{} {
+	if inf, ok := p.podInformer.(cache.SharedIndexInformer); ok {
+		if items, err := inf.GetIndexer().ByIndex(podNodeIndex, nodeName); err == nil {
+			return items
+		}
+	}
+	return p.podInformer.GetStore().List()
+}
+
+// podNodeIndex is the name of the pod informers index by the node the pods are scheduled to.
+const podNodeIndex = "spec.nodeName"
+
+func podNodeIndexFunc(obj interface{}) ([]string, error) {
+	pod, err := toPod(obj)
+	if err != nil || pod.Spec.NodeName == "" {
+		return nil, nil
+	}
+	return []string{pod.Spec.NodeName}, nil
+}
+
+// sameNodeMetadata reports whether the node fields exposed on pod targets are unchanged.
+// Node status is updated periodically, other changes are ignored to avoid rebuilding the node pods.
+func sameNodeMetadata(oldObj, obj interface{}) bool {
+	n1, err1 := toNode(oldObj)
+	n2, err2 := toNode(obj)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	return reflect.DeepEqual(n1.Labels, n2.Labels) &&
+		reflect.DeepEqual(n1.Annotations, n2.Annotations) &&
+		reflect.DeepEqual(n1.Status.Addresses, n2.Status.Addresses)
+}
+
+type podContainer struct {
+	kind      string
+	container apiv1.Container
+	status    apiv1.ContainerStatus
+}
+
+// podContainers returns the pod regular containers followed by the enabled init, sidecar and ephemeral ones.
+// Sidecars are init containers with 'Always' restart policy, they keep running alongside the regular containers.
+func (p Pod) podContainers(pod *apiv1.Pod) []podContainer {
+	var conts []podContainer
+
+	statuses := toStatusMap(pod.Status.ContainerStatuses)
+	for _, c := range pod.Spec.Containers {
+		conts = append(conts, podContainer{kind: containerKindRegular, container: c, status: statuses[c.Name]})
+	}
+
+	if p.initConts || p.sidecarConts {
+		statuses = toStatusMap(pod.Status.InitContainerStatuses)
+		for _, c := range pod.Spec.InitContainers {
+			kind := containerKindInit
+			if c.RestartPolicy != nil && *c.RestartPolicy == apiv1.ContainerRestartPolicyAlways {
+				kind = containerKindSidecar
+			}
+			if (kind == containerKindInit && !p.initConts) || (kind == containerKindSidecar && !p.sidecarConts) {
+				continue
+			}
+			conts = append(conts, podContainer{kind: kind, container: c, status: statuses[c.Name]})
+		}
+	}
+
+	if p.ephemeralConts {
+		statuses = toStatusMap(pod.Status.EphemeralContainerStatuses)
+		for _, c := range pod.Spec.EphemeralContainers {
+			conts = append(conts, podContainer{
+				kind:      containerKindEphemeral,
+				container: apiv1.Container(c.EphemeralContainerCommon),
+				status:    statuses[c.Name],
+			})
+		}
+	}
+
+	return conts
+}
+
+func toStatusMap(statuses []apiv1.ContainerStatus) map[string]apiv1.ContainerStatus {
+	m := make(map[string]apiv1.ContainerStatus, len(statuses))
+	for _, status := range statuses {
+		m[status.Name] = status
+	}
+	return m
+}
+
+func isPodReady(pod *apiv1.Pod) bool {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == apiv1.PodReady {
+			return cond.Status == apiv1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// containerState returns the container state ('waiting', 'running' or 'terminated') and its reason.
+// Both are empty if the container has no status yet.
+func containerState(state apiv1.ContainerState) (string, string) {
+	switch {
+	case state.Running != nil:
+		return "running", ""
+	case state.Waiting != nil:
+		return "waiting", state.Waiting.Reason
+	case state.Terminated != nil:
+		return "terminated", state.Terminated.Reason
+	}
+	return "", ""
+}
+
+func formatTime(t *metav1.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
 func podTUID(pod *apiv1.Pod, container apiv1.Container) string {
 	return fmt.Sprintf("%s_%s_%s",
 		pod.Namespace,
@@ -388,11 +805,154 @@ func toSecret(item interface{}) (*apiv1.Secret, error) {
 	return secret, nil
 }
 
-func isVar(name string) bool {
-	// Variable references $(VAR_NAME) are expanded using the previous defined
-	// environment variables in the container and any service environment
-	// variables.
-	return strings.IndexByte(name, '$') != -1
+// podFieldValue resolves downward API 'fieldRef' paths supported for environment variables.
+func podFieldValue(pod *apiv1.Pod, fieldPath string) (string, bool) {
+	if path, key, ok := parseSubscript(fieldPath); ok {
+		switch path {
+		case "metadata.labels":
+			return pod.Labels[key], true
+		case "metadata.annotations":
+			return pod.Annotations[key], true
+		}
+		return "", false
+	}
+
+	switch fieldPath {
+	case "metadata.name":
+		return pod.Name, true
+	case "metadata.namespace":
+		return pod.Namespace, true
+	case "metadata.uid":
+		return string(pod.UID), true
+	case "spec.nodeName":
+		return pod.Spec.NodeName, true
+	case "spec.serviceAccountName":
+		return pod.Spec.ServiceAccountName, true
+	case "status.hostIP":
+		return pod.Status.HostIP, true
+	case "status.hostIPs":
+		ips := make([]string, 0, len(pod.Status.HostIPs))
+		for _, ip := range pod.Status.HostIPs {
+			ips = append(ips, ip.IP)
+		}
+		return strings.Join(ips, ","), true
+	case "status.podIP":
+		return pod.Status.PodIP, true
+	case "status.podIPs":
+		ips := make([]string, 0, len(pod.Status.PodIPs))
+		for _, ip := range pod.Status.PodIPs {
+			ips = append(ips, ip.IP)
+		}
+		return strings.Join(ips, ","), true
+	}
+	return "", false
+}
+
+// parseSubscript splits "metadata.labels['key']" into "metadata.labels" and "key".
+func parseSubscript(fieldPath string) (path, key string, ok bool) {
+	i := strings.Index(fieldPath, "['")
+	if i == -1 || !strings.HasSuffix(fieldPath, "']") {
+		return "", "", false
+	}
+	return fieldPath[:i], fieldPath[i+2 : len(fieldPath)-2], true
+}
+
+// containerResourceValue resolves downward API 'resourceFieldRef' the same way the kubelet does, values are rounded up
+// to the divisor. Unlike the kubelet, it doesn't fall back to the node allocatable resources for unset limits.
+func containerResourceValue(pod *apiv1.Pod, container apiv1.Container, ref *apiv1.ResourceFieldSelector) (string, bool) {
+	if ref.ContainerName != "" && ref.ContainerName != container.Name {
+		c, ok := findContainer(pod, ref.ContainerName)
+		if !ok {
+			return "", false
+		}
+		container = c
+	}
+
+	var q resource.Quantity
+	var found bool
+	switch ref.Resource {
+	case "limits.cpu":
+		q, found = container.Resources.Limits[apiv1.ResourceCPU]
+	case "limits.memory":
+		q, found = container.Resources.Limits[apiv1.ResourceMemory]
+	case "limits.ephemeral-storage":
+		q, found = container.Resources.Limits[apiv1.ResourceEphemeralStorage]
+	case "requests.cpu":
+		q, found = container.Resources.Requests[apiv1.ResourceCPU]
+	case "requests.memory":
+		q, found = container.Resources.Requests[apiv1.ResourceMemory]
+	case "requests.ephemeral-storage":
+		q, found = container.Resources.Requests[apiv1.ResourceEphemeralStorage]
+	}
+	if !found {
+		return "", false
+	}
+
+	divisor := ref.Divisor
+	if divisor.IsZero() {
+		divisor = resource.MustParse("1")
+	}
+	var v int64
+	if strings.HasSuffix(ref.Resource, ".cpu") {
+		v = int64(math.Ceil(float64(q.MilliValue()) / float64(divisor.MilliValue())))
+	} else {
+		v = int64(math.Ceil(float64(q.Value()) / float64(divisor.Value())))
+	}
+	return strconv.FormatInt(v, 10), true
+}
+
+func findContainer(pod *apiv1.Pod, name string) (apiv1.Container, bool) {
+	for _, containers := range [][]apiv1.Container{pod.Spec.Containers, pod.Spec.InitContainers} {
+		for _, c := range containers {
+			if c.Name == name {
+				return c, true
+			}
+		}
+	}
+	return apiv1.Container{}, false
+}
+
+// expandVars expands variable references $(VAR_NAME) using the vars, it follows the kubelet expansion rules:
+// references to undefined variables are left unchanged and '$$' is an escaped '$'.
+func expandVars(input string, vars map[string]string) string {
+	var sb strings.Builder
+	checkpoint := 0
+	for cursor := 0; cursor < len(input); cursor++ {
+		if input[cursor] != '$' || cursor+1 >= len(input) {
+			continue
+		}
+		sb.WriteString(input[checkpoint:cursor])
+
+		read, isVar, advance := readVarName(input[cursor+1:])
+		if !isVar {
+			sb.WriteString(read)
+		} else if v, ok := vars[read]; ok {
+			sb.WriteString(v)
+		} else {
+			sb.WriteString("$(" + read + ")")
+		}
+		cursor += advance
+		checkpoint = cursor + 1
+	}
+	return sb.String() + input[checkpoint:]
+}
+
+// readVarName reads a variable name from the input that follows the '$' operator. It returns the name (or the text to
+// write as is), whether it is a variable reference and the number of consumed bytes.
+func readVarName(input string) (string, bool, int) {
+	switch input[0] {
+	case '$':
+		return "$", false, 1
+	case '(':
+		for i := 1; i < len(input); i++ {
+			if input[i] == ')' {
+				return input[1:i], true, i + 1
+			}
+		}
+		return "$(", false, 1
+	default:
+		return "$" + input[:1], false, 1
+	}
 }
 
 func toMapInterface(src map[string]string) map[string]interface{} {
@@ -10,7 +10,10 @@ import (
 	"github.com/netdata/sd/pipeline/model"
 
 	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/cache"
@@ -111,10 +114,10 @@ func TestPodTarget_Hash(t *testing.T) {
 				return sim
 			},
 			expectedHash: []uint64{
-				12703169414253998055,
-				13351713096133918928,
-				8241692333761256175,
-				11562466355572729519,
+				830102940179842902,
+				181682395062495329,
+				14533108284497097310,
+				1997775919607664158,
 			},
 		},
 	}
@@ -189,13 +192,20 @@ func TestNewPod(t *testing.T) {
 			cmapInf:   cache.NewSharedInformer(nil, &apiv1.ConfigMap{}, resyncPeriod),
 			secretInf: cache.NewSharedInformer(nil, &apiv1.Secret{}, resyncPeriod),
 		},
-		"nil informers": {wantPanic: true},
+		"without cmap and secret informers": {
+			podInf: cache.NewSharedInformer(nil, &apiv1.Pod{}, resyncPeriod),
+		},
+		"nil pod informer": {
+			cmapInf:   cache.NewSharedInformer(nil, &apiv1.ConfigMap{}, resyncPeriod),
+			secretInf: cache.NewSharedInformer(nil, &apiv1.Secret{}, resyncPeriod),
+			wantPanic: true,
+		},
 	}
 
 	for name, test := range tests {
 		t.Run(name, func(t *testing.T) {
 			if test.wantPanic {
-				assert.Panics(t, func() { NewPod(nil, nil, nil) })
+				assert.Panics(t, func() { NewPod(test.podInf, test.cmapInf, test.secretInf) })
 			} else {
 				assert.IsType(t, &Pod{}, NewPod(test.podInf, test.cmapInf, test.secretInf))
 			}
@@ -333,6 +343,314 @@ func TestPod_Discover(t *testing.T) {
 			}
 			return sim
 		},
+		"UPDATE: container restarts after sync": func() discoverySim {
+			httpd := newHTTPDPod()
+			discovery, clientset := prepareAllNsDiscovery(RolePod, httpd)
+			podClient := clientset.CoreV1().Pods("default")
+
+			crashing := newHTTPDPod()
+			setContainerWaiting(crashing, "CrashLoopBackOff", 3)
+
+			sim := discoverySim{
+				discovery: discovery,
+				runAfterSync: func(ctx context.Context) {
+					time.Sleep(time.Millisecond * 50)
+					_, _ = podClient.Update(ctx, crashing, metav1.UpdateOptions{})
+				},
+				expectedGroups: []model.Group{
+					preparePodGroup(httpd),
+					preparePodGroupWithContainerStatus(crashing, false, 3, "waiting", "CrashLoopBackOff"),
+				},
+			}
+			return sim
+		},
+		"ReadyOnly: pods with not ready containers": func() discoverySim {
+			httpd, nginx := newHTTPDPod(), newNGINXPod()
+			setContainerWaiting(nginx, "ContainerCreating", 0)
+			discovery, _ := prepareAllNsDiscovery(RolePod, httpd, nginx)
+			discovery.podConfig.ReadyOnly = true
+
+			sim := discoverySim{
+				discovery:        discovery,
+				sortBeforeVerify: true,
+				expectedGroups: []model.Group{
+					preparePodGroup(httpd),
+					prepareEmptyPodGroup(nginx),
+				},
+			}
+			return sim
+		},
+		"ReadyOnly: container becomes ready after sync": func() discoverySim {
+			httpd := newHTTPDPod()
+			setContainerWaiting(httpd, "ContainerCreating", 0)
+			discovery, clientset := prepareAllNsDiscovery(RolePod, httpd)
+			discovery.podConfig.ReadyOnly = true
+			podClient := clientset.CoreV1().Pods("default")
+
+			sim := discoverySim{
+				discovery: discovery,
+				runAfterSync: func(ctx context.Context) {
+					time.Sleep(time.Millisecond * 50)
+					_, _ = podClient.Update(ctx, newHTTPDPod(), metav1.UpdateOptions{})
+				},
+				expectedGroups: []model.Group{
+					prepareEmptyPodGroup(httpd),
+					preparePodGroup(newHTTPDPod()),
+				},
+			}
+			return sim
+		},
+		"Workload: resolution is disabled": func() discoverySim {
+			httpd := newHTTPDPod()
+			setPodController(httpd, "ReplicaSet", "httpd-dd95c4d68")
+			rs := newReplicaSet("httpd-dd95c4d68", "Deployment", "httpd")
+			discovery, _ := prepareAllNsDiscovery(RolePod, httpd, rs)
+
+			sim := discoverySim{
+				discovery: discovery,
+				expectedGroups: []model.Group{
+					preparePodGroupWithWorkload(httpd, "ReplicaSet", "httpd-dd95c4d68", "ReplicaSet", "httpd-dd95c4d68"),
+				},
+			}
+			return sim
+		},
+		"Workload: Deployment and CronJob": func() discoverySim {
+			httpd, nginx := newHTTPDPod(), newNGINXPod()
+			setPodController(httpd, "ReplicaSet", "httpd-dd95c4d68")
+			setPodController(nginx, "Job", "backup-27935040")
+			rs := newReplicaSet("httpd-dd95c4d68", "Deployment", "httpd")
+			job := newJob("backup-27935040", "CronJob", "backup")
+			discovery, _ := prepareAllNsDiscovery(RolePod, httpd, nginx, rs, job)
+			discovery.podConfig.ResolveWorkloads = true
+
+			sim := discoverySim{
+				discovery: discovery,
+				expectedGroups: []model.Group{
+					preparePodGroupWithWorkload(httpd, "ReplicaSet", "httpd-dd95c4d68", "Deployment", "httpd"),
+					preparePodGroupWithWorkload(nginx, "Job", "backup-27935040", "CronJob", "backup"),
+				},
+			}
+			return sim
+		},
+		"Workload: owner without controller": func() discoverySim {
+			httpd := newHTTPDPod()
+			setPodController(httpd, "ReplicaSet", "httpd-dd95c4d68")
+			rs := newReplicaSet("httpd-dd95c4d68", "", "")
+			discovery, _ := prepareAllNsDiscovery(RolePod, httpd, rs)
+			discovery.podConfig.ResolveWorkloads = true
+
+			sim := discoverySim{
+				discovery: discovery,
+				expectedGroups: []model.Group{
+					preparePodGroupWithWorkload(httpd, "ReplicaSet", "httpd-dd95c4d68", "ReplicaSet", "httpd-dd95c4d68"),
+				},
+			}
+			return sim
+		},
+		"Workload: owner added after sync": func() discoverySim {
+			httpd := newHTTPDPod()
+			setPodController(httpd, "ReplicaSet", "httpd-dd95c4d68")
+			rs := newReplicaSet("httpd-dd95c4d68", "Deployment", "httpd")
+			discovery, clientset := prepareAllNsDiscovery(RolePod, httpd)
+			discovery.podConfig.ResolveWorkloads = true
+			rsClient := clientset.AppsV1().ReplicaSets("default")
+
+			sim := discoverySim{
+				discovery: discovery,
+				runAfterSync: func(ctx context.Context) {
+					time.Sleep(time.Millisecond * 50)
+					_, _ = rsClient.Create(ctx, rs, metav1.CreateOptions{})
+				},
+				expectedGroups: []model.Group{
+					preparePodGroupWithWorkload(httpd, "ReplicaSet", "httpd-dd95c4d68", "ReplicaSet", "httpd-dd95c4d68"),
+					preparePodGroupWithWorkload(httpd, "ReplicaSet", "httpd-dd95c4d68", "Deployment", "httpd"),
+				},
+			}
+			return sim
+		},
+		"Workload: owner of another kind with the same name": func() discoverySim {
+			httpd := newHTTPDPod()
+			setPodController(httpd, "ReplicaSet", "httpd-dd95c4d68")
+			job := newJob("httpd-dd95c4d68", "CronJob", "backup")
+			rs := newReplicaSet("httpd-dd95c4d68", "Deployment", "httpd")
+			discovery, clientset := prepareAllNsDiscovery(RolePod, httpd)
+			discovery.podConfig.ResolveWorkloads = true
+
+			sim := discoverySim{
+				discovery: discovery,
+				runAfterSync: func(ctx context.Context) {
+					time.Sleep(time.Millisecond * 50)
+					_, _ = clientset.BatchV1().Jobs("default").Create(ctx, job, metav1.CreateOptions{})
+					time.Sleep(time.Millisecond * 50)
+					_, _ = clientset.AppsV1().ReplicaSets("default").Create(ctx, rs, metav1.CreateOptions{})
+				},
+				expectedGroups: []model.Group{
+					preparePodGroupWithWorkload(httpd, "ReplicaSet", "httpd-dd95c4d68", "ReplicaSet", "httpd-dd95c4d68"),
+					preparePodGroupWithWorkload(httpd, "ReplicaSet", "httpd-dd95c4d68", "Deployment", "httpd"),
+				},
+			}
+			return sim
+		},
+		"Workload: recreated owner": func() discoverySim {
+			httpd := newHTTPDPod()
+			setPodController(httpd, "ReplicaSet", "httpd-dd95c4d68")
+			rs := newReplicaSet("httpd-dd95c4d68", "Deployment", "httpd")
+			rs.UID = "recreated"
+			discovery, _ := prepareAllNsDiscovery(RolePod, httpd, rs)
+			discovery.podConfig.ResolveWorkloads = true
+
+			sim := discoverySim{
+				discovery: discovery,
+				expectedGroups: []model.Group{
+					preparePodGroupWithWorkload(httpd, "ReplicaSet", "httpd-dd95c4d68", "ReplicaSet", "httpd-dd95c4d68"),
+				},
+			}
+			return sim
+		},
+		"NodeMetadata: node labels, topology and addresses": func() discoverySim {
+			httpd, nginx := newHTTPDPod(), newNGINXPod()
+			node := newZonedNode("m01", "192.168.0.1")
+			discovery, _ := prepareAllNsDiscovery(RolePod, httpd, nginx, node)
+			discovery.podConfig.NodeMetadata = true
+
+			sim := discoverySim{
+				discovery: discovery,
+				expectedGroups: []model.Group{
+					preparePodGroupWithNode(httpd, node),
+					preparePodGroupWithNode(nginx, node),
+				},
+			}
+			return sim
+		},
+		"NodeMetadata: node labels changed": func() discoverySim {
+			httpd := newHTTPDPod()
+			node := newZonedNode("m01", "192.168.0.1")
+			notReady := node.DeepCopy()
+			notReady.Status.Conditions = []apiv1.NodeCondition{{Type: apiv1.NodeReady, Status: apiv1.ConditionFalse}}
+			relabeled := notReady.DeepCopy()
+			relabeled.Labels["node.kubernetes.io/instance-type"] = "m5.xlarge"
+			discovery, clientset := prepareAllNsDiscovery(RolePod, httpd, node)
+			discovery.podConfig.NodeMetadata = true
+			nodeClient := clientset.CoreV1().Nodes()
+
+			sim := discoverySim{
+				discovery: discovery,
+				runAfterSync: func(ctx context.Context) {
+					time.Sleep(time.Millisecond * 50)
+					_, _ = nodeClient.Update(ctx, notReady, metav1.UpdateOptions{})
+					time.Sleep(time.Millisecond * 50)
+					_, _ = nodeClient.Update(ctx, relabeled, metav1.UpdateOptions{})
+				},
+				expectedGroups: []model.Group{
+					preparePodGroupWithNode(httpd, node),
+					preparePodGroupWithNode(httpd, relabeled),
+				},
+			}
+			return sim
+		},
+		"NodeMetadata: node is not found": func() discoverySim {
+			httpd := newHTTPDPod()
+			discovery, _ := prepareAllNsDiscovery(RolePod, httpd)
+			discovery.podConfig.NodeMetadata = true
+
+			sim := discoverySim{
+				discovery: discovery,
+				expectedGroups: []model.Group{
+					preparePodGroup(httpd),
+				},
+			}
+			return sim
+		},
+		"Prometheus annotations: declared port": func() discoverySim {
+			httpd := newHTTPDPod()
+			httpd.Annotations[annotationScrape] = "true"
+			httpd.Annotations[annotationPort] = "80"
+			httpd.Annotations[annotationPath] = "/server-status"
+			discovery, _ := prepareAllNsDiscovery(RolePod, httpd)
+
+			sim := discoverySim{
+				discovery: discovery,
+				expectedGroups: []model.Group{
+					preparePodGroupWithScrape(httpd, "80", "/server-status", "http", nil),
+				},
+			}
+			return sim
+		},
+		"Prometheus annotations: undeclared port": func() discoverySim {
+			httpd := newHTTPDPod()
+			httpd.Annotations[annotationScrape] = "true"
+			httpd.Annotations[annotationPort] = "9117"
+			discovery, _ := prepareAllNsDiscovery(RolePod, httpd)
+			metricsPort := apiv1.ContainerPort{ContainerPort: 9117, Protocol: apiv1.ProtocolTCP}
+
+			sim := discoverySim{
+				discovery: discovery,
+				expectedGroups: []model.Group{
+					preparePodGroupWithScrape(httpd, "9117", "/metrics", "http", &metricsPort),
+				},
+			}
+			return sim
+		},
+		"Prometheus annotations: scraping is disabled": func() discoverySim {
+			httpd := newHTTPDPod()
+			httpd.Annotations[annotationScrape] = "false"
+			httpd.Annotations[annotationPort] = "9117"
+			discovery, _ := prepareAllNsDiscovery(RolePod, httpd)
+
+			group := preparePodGroup(httpd)
+			for _, target := range group.Targets() {
+				target.(*PodTarget).MetricsPort = "9117"
+				target.(*PodTarget).hash = mustCalcHash(target)
+			}
+			sim := discoverySim{
+				discovery:      discovery,
+				expectedGroups: []model.Group{group},
+			}
+			return sim
+		},
+		"ContainerKind: init, sidecar and ephemeral containers are disabled": func() discoverySim {
+			httpd := newHTTPDPod()
+			addInitSidecarEphemeralContainers(httpd)
+			discovery, _ := prepareAllNsDiscovery(RolePod, httpd)
+
+			sim := discoverySim{
+				discovery: discovery,
+				expectedGroups: []model.Group{
+					preparePodGroup(httpd),
+				},
+			}
+			return sim
+		},
+		"ContainerKind: all container kinds enabled": func() discoverySim {
+			httpd := newHTTPDPod()
+			addInitSidecarEphemeralContainers(httpd)
+			discovery, _ := prepareAllNsDiscovery(RolePod, httpd)
+			discovery.podConfig.InitContainers = true
+			discovery.podConfig.SidecarContainers = true
+			discovery.podConfig.EphemeralContainers = true
+
+			sim := discoverySim{
+				discovery: discovery,
+				expectedGroups: []model.Group{
+					preparePodGroupWithContainerKinds(httpd, "init", "sidecar", "ephemeral"),
+				},
+			}
+			return sim
+		},
+		"ContainerKind: sidecar containers enabled": func() discoverySim {
+			httpd := newHTTPDPod()
+			addInitSidecarEphemeralContainers(httpd)
+			discovery, _ := prepareAllNsDiscovery(RolePod, httpd)
+			discovery.podConfig.SidecarContainers = true
+
+			sim := discoverySim{
+				discovery: discovery,
+				expectedGroups: []model.Group{
+					preparePodGroupWithContainerKinds(httpd, "sidecar"),
+				},
+			}
+			return sim
+		},
 		"Env: from value": func() discoverySim {
 			httpd := newHTTPDPod()
 			mangle := func(c *apiv1.Container) {
@@ -353,6 +671,178 @@ func TestPod_Discover(t *testing.T) {
 			}
 			return sim
 		},
+		"EnvSources: off": func() discoverySim {
+			httpd := newHTTPDPod()
+			mangleContainers(httpd.Spec.Containers, setEnvFromConfigMapAndSecret)
+			cmap := prepareConfigMap("my-cmap", map[string]string{"cmap_key": "cmap_value"})
+			secret := prepareSecret("my-secret", map[string]string{"secret_key": "secret_value"})
+
+			discovery, _ := prepareAllNsDiscovery(RolePod, httpd, cmap, secret)
+			discovery.podConfig.EnvSources = EnvSourcesOff
+
+			sim := discoverySim{
+				discovery: discovery,
+				expectedGroups: []model.Group{
+					preparePodGroupWithEnv(httpd, map[string]string{"key": "value"}),
+				},
+			}
+			return sim
+		},
+		"EnvSources: configmaps": func() discoverySim {
+			httpd := newHTTPDPod()
+			mangleContainers(httpd.Spec.Containers, setEnvFromConfigMapAndSecret)
+			cmap := prepareConfigMap("my-cmap", map[string]string{"cmap_key": "cmap_value"})
+			secret := prepareSecret("my-secret", map[string]string{"secret_key": "secret_value"})
+
+			discovery, _ := prepareAllNsDiscovery(RolePod, httpd, cmap, secret)
+			discovery.podConfig.EnvSources = EnvSourcesConfigMaps
+
+			sim := discoverySim{
+				discovery: discovery,
+				expectedGroups: []model.Group{
+					preparePodGroupWithEnv(httpd, map[string]string{"key": "value", "cmap_key": "cmap_value"}),
+				},
+			}
+			return sim
+		},
+		"EnvSources: both with redacted secrets": func() discoverySim {
+			httpd := newHTTPDPod()
+			mangleContainers(httpd.Spec.Containers, setEnvFromConfigMapAndSecret)
+			cmap := prepareConfigMap("my-cmap", map[string]string{"cmap_key": "cmap_value"})
+			secret := prepareSecret("my-secret", map[string]string{"secret_key": "secret_value"})
+
+			discovery, _ := prepareAllNsDiscovery(RolePod, httpd, cmap, secret)
+			discovery.podConfig.EnvSources = EnvSourcesBoth
+			discovery.podConfig.RedactSecrets = true
+
+			sim := discoverySim{
+				discovery: discovery,
+				expectedGroups: []model.Group{
+					preparePodGroupWithEnv(httpd, map[string]string{
+						"key":        "value",
+						"cmap_key":   "cmap_value",
+						"secret_key": "",
+						"password":   "",
+					}),
+				},
+			}
+			return sim
+		},
+		"Env: variable references expansion": func() discoverySim {
+			httpd := newHTTPDPod()
+			mangle := func(c *apiv1.Container) {
+				c.EnvFrom = []apiv1.EnvFromSource{
+					{ConfigMapRef: &apiv1.ConfigMapEnvSource{
+						LocalObjectReference: apiv1.LocalObjectReference{Name: "my-cmap"}},
+					},
+				}
+				c.Env = []apiv1.EnvVar{
+					{Name: "POD_IP", ValueFrom: &apiv1.EnvVarSource{FieldRef: &apiv1.ObjectFieldSelector{FieldPath: "status.podIP"}}},
+					{Name: "ADDRESS", Value: "$(POD_IP):$(PORT)"},
+					{Name: "URL", Value: "http://$(ADDRESS)/$(UNDEFINED)/$$(POD_IP)"},
+				}
+			}
+			mangleContainers(httpd.Spec.Containers, mangle)
+			cmap := prepareConfigMap("my-cmap", map[string]string{"PORT": "9100"})
+
+			discovery, _ := prepareAllNsDiscovery(RolePod, httpd, cmap)
+
+			sim := discoverySim{
+				discovery: discovery,
+				expectedGroups: []model.Group{
+					preparePodGroupWithEnv(httpd, map[string]string{
+						"PORT":    "9100",
+						"POD_IP":  "172.17.0.1",
+						"ADDRESS": "172.17.0.1:9100",
+						"URL":     "http://172.17.0.1:9100/$(UNDEFINED)/$(POD_IP)",
+					}),
+				},
+			}
+			return sim
+		},
+		"Env: from fieldRef": func() discoverySim {
+			httpd := newHTTPDPod()
+			httpd.Spec.ServiceAccountName = "httpd"
+			httpd.Status.HostIP = "192.168.0.1"
+			mangle := func(c *apiv1.Container) {
+				fieldRef := func(path string) *apiv1.EnvVarSource {
+					return &apiv1.EnvVarSource{FieldRef: &apiv1.ObjectFieldSelector{FieldPath: path}}
+				}
+				c.Env = []apiv1.EnvVar{
+					{Name: "POD_NAME", ValueFrom: fieldRef("metadata.name")},
+					{Name: "POD_NAMESPACE", ValueFrom: fieldRef("metadata.namespace")},
+					{Name: "POD_UID", ValueFrom: fieldRef("metadata.uid")},
+					{Name: "APP", ValueFrom: fieldRef("metadata.labels['app']")},
+					{Name: "PHASE", ValueFrom: fieldRef("metadata.annotations['phase']")},
+					{Name: "NODE_NAME", ValueFrom: fieldRef("spec.nodeName")},
+					{Name: "SERVICE_ACCOUNT", ValueFrom: fieldRef("spec.serviceAccountName")},
+					{Name: "HOST_IP", ValueFrom: fieldRef("status.hostIP")},
+					{Name: "UNKNOWN", ValueFrom: fieldRef("status.unknown")},
+				}
+			}
+			mangleContainers(httpd.Spec.Containers, mangle)
+
+			discovery, _ := prepareAllNsDiscovery(RolePod, httpd)
+
+			sim := discoverySim{
+				discovery: discovery,
+				expectedGroups: []model.Group{
+					preparePodGroupWithEnv(httpd, map[string]string{
+						"POD_NAME":        "httpd-dd95c4d68-5bkwl",
+						"POD_NAMESPACE":   "default",
+						"POD_UID":         "1cebb6eb-0c1e-495b-8131-8fa3e6668dc8",
+						"APP":             "httpd",
+						"PHASE":           "prod",
+						"NODE_NAME":       "m01",
+						"SERVICE_ACCOUNT": "httpd",
+						"HOST_IP":         "192.168.0.1",
+					}),
+				},
+			}
+			return sim
+		},
+		"Env: from resourceFieldRef": func() discoverySim {
+			httpd := newHTTPDPod()
+			mangle := func(c *apiv1.Container) {
+				resourceRef := func(res, divisor string) *apiv1.EnvVarSource {
+					ref := &apiv1.ResourceFieldSelector{Resource: res}
+					if divisor != "" {
+						ref.Divisor = resource.MustParse(divisor)
+					}
+					return &apiv1.EnvVarSource{ResourceFieldRef: ref}
+				}
+				c.Resources = apiv1.ResourceRequirements{
+					Limits: apiv1.ResourceList{
+						apiv1.ResourceCPU:    resource.MustParse("500m"),
+						apiv1.ResourceMemory: resource.MustParse("128Mi"),
+					},
+					Requests: apiv1.ResourceList{
+						apiv1.ResourceCPU: resource.MustParse("250m"),
+					},
+				}
+				c.Env = []apiv1.EnvVar{
+					{Name: "CPU_LIMIT", ValueFrom: resourceRef("limits.cpu", "")},
+					{Name: "CPU_REQUEST_MILLI", ValueFrom: resourceRef("requests.cpu", "1m")},
+					{Name: "MEM_LIMIT_MI", ValueFrom: resourceRef("limits.memory", "1Mi")},
+					{Name: "MEM_REQUEST", ValueFrom: resourceRef("requests.memory", "")},
+				}
+			}
+			mangleContainers(httpd.Spec.Containers, mangle)
+
+			discovery, _ := prepareAllNsDiscovery(RolePod, httpd)
+
+			sim := discoverySim{
+				discovery: discovery,
+				expectedGroups: []model.Group{
+					preparePodGroupWithEnv(httpd, map[string]string{
+						"CPU_LIMIT":         "1",
+						"CPU_REQUEST_MILLI": "250",
+						"MEM_LIMIT_MI":      "128",
+					}),
+				},
+			}
+			return sim
+		},
 		"Env: from Secret": func() discoverySim {
 			httpd := newHTTPDPod()
 			mangle := func(c *apiv1.Container) {
@@ -462,13 +952,134 @@ func TestPod_Discover(t *testing.T) {
 	}
 }
 
+func TestExpandVars(t *testing.T) {
+	vars := map[string]string{"HOST": "10.0.0.1", "PORT": "9100", "EMPTY": ""}
+
+	tests := map[string]struct {
+		input    string
+		expected string
+	}{
+		"no references":         {input: "value", expected: "value"},
+		"references":            {input: "$(HOST):$(PORT)", expected: "10.0.0.1:9100"},
+		"empty variable":        {input: "a$(EMPTY)b", expected: "ab"},
+		"undefined variable":    {input: "$(UNDEFINED):$(PORT)", expected: "$(UNDEFINED):9100"},
+		"escaped operator":      {input: "$$(HOST)", expected: "$(HOST)"},
+		"double escape":         {input: "$$$$", expected: "$$"},
+		"not a reference":       {input: "$HOST", expected: "$HOST"},
+		"unclosed reference":    {input: "$(HOST", expected: "$(HOST"},
+		"trailing operator":     {input: "value$", expected: "value$"},
+		"empty reference":       {input: "$()", expected: "$()"},
+		"operator before a ref": {input: "$$$(PORT)", expected: "$9100"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.expected, expandVars(test.input, vars))
+		})
+	}
+}
+
+func setEnvFromConfigMapAndSecret(c *apiv1.Container) {
+	c.EnvFrom = []apiv1.EnvFromSource{
+		{ConfigMapRef: &apiv1.ConfigMapEnvSource{LocalObjectReference: apiv1.LocalObjectReference{Name: "my-cmap"}}},
+		{SecretRef: &apiv1.SecretEnvSource{LocalObjectReference: apiv1.LocalObjectReference{Name: "my-secret"}}},
+	}
+	c.Env = []apiv1.EnvVar{
+		{Name: "key", Value: "value"},
+		{
+			Name: "password",
+			ValueFrom: &apiv1.EnvVarSource{SecretKeyRef: &apiv1.SecretKeySelector{
+				LocalObjectReference: apiv1.LocalObjectReference{Name: "my-secret"},
+				Key:                  "secret_key",
+			}},
+		},
+	}
+}
+
 func mangleContainers(containers []apiv1.Container, m func(container *apiv1.Container)) {
 	for i := range containers {
 		m(&containers[i])
 	}
 }
 
-var controllerTrue = true
+func newZonedNode(name, internalIP string) *apiv1.Node {
+	node := newNode(name, internalIP)
+	node.Labels[apiv1.LabelTopologyZone] = "eu-west-1a"
+	node.Labels[apiv1.LabelTopologyRegion] = "eu-west-1"
+	return node
+}
+
+func setPodController(pod *apiv1.Pod, kind, name string) {
+	pod.OwnerReferences = []metav1.OwnerReference{
+		{Name: name, Kind: kind, UID: types.UID(name), Controller: &controllerTrue},
+	}
+}
+
+func newReplicaSet(name, ownerKind, ownerName string) *appsv1.ReplicaSet {
+	return &appsv1.ReplicaSet{ObjectMeta: newOwnedObjectMeta(name, ownerKind, ownerName)}
+}
+
+func newJob(name, ownerKind, ownerName string) *batchv1.Job {
+	return &batchv1.Job{ObjectMeta: newOwnedObjectMeta(name, ownerKind, ownerName)}
+}
+
+func newOwnedObjectMeta(name, ownerKind, ownerName string) metav1.ObjectMeta {
+	meta := metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID(name)}
+	if ownerKind != "" {
+		meta.OwnerReferences = []metav1.OwnerReference{
+			{Name: ownerName, Kind: ownerKind, UID: types.UID(ownerName), Controller: &controllerTrue},
+		}
+	}
+	return meta
+}
+
+func setContainerWaiting(pod *apiv1.Pod, reason string, restarts int32) {
+	pod.Status.Conditions = []apiv1.PodCondition{{Type: apiv1.PodReady, Status: apiv1.ConditionFalse}}
+	for i := range pod.Status.ContainerStatuses {
+		status := &pod.Status.ContainerStatuses[i]
+		status.Ready = false
+		status.RestartCount = restarts
+		status.State = apiv1.ContainerState{Waiting: &apiv1.ContainerStateWaiting{Reason: reason}}
+	}
+}
+
+func addInitSidecarEphemeralContainers(pod *apiv1.Pod) {
+	always := apiv1.ContainerRestartPolicyAlways
+	pod.Spec.InitContainers = []apiv1.Container{
+		{Name: "init-config", Image: "busybox"},
+		{
+			Name:          "exporter",
+			Image:         "prom/apache-exporter",
+			RestartPolicy: &always,
+			Ports:         []apiv1.ContainerPort{{Name: "metrics", Protocol: apiv1.ProtocolTCP, ContainerPort: 9117}},
+		},
+	}
+	pod.Spec.EphemeralContainers = []apiv1.EphemeralContainer{
+		{EphemeralContainerCommon: apiv1.EphemeralContainerCommon{Name: "debugger", Image: "busybox"}},
+	}
+	pod.Status.InitContainerStatuses = []apiv1.ContainerStatus{
+		{
+			Name:  "init-config",
+			State: apiv1.ContainerState{Terminated: &apiv1.ContainerStateTerminated{Reason: "Completed"}},
+		},
+		{
+			Name:  "exporter",
+			Ready: true,
+			State: apiv1.ContainerState{Running: &apiv1.ContainerStateRunning{StartedAt: podStartTime}},
+		},
+	}
+	pod.Status.EphemeralContainerStatuses = []apiv1.ContainerStatus{
+		{
+			Name:  "debugger",
+			State: apiv1.ContainerState{Running: &apiv1.ContainerStateRunning{StartedAt: podStartTime}},
+		},
+	}
+}
+
+var (
+	controllerTrue = true
+	podStartTime   = metav1.NewTime(time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC))
+)
 
 func newHTTPDPod() *apiv1.Pod {
 	return &apiv1.Pod{
@@ -496,7 +1107,18 @@ func newHTTPDPod() *apiv1.Pod {
 			},
 		},
 		Status: apiv1.PodStatus{
-			PodIP: "172.17.0.1",
+			Phase:      apiv1.PodRunning,
+			PodIP:      "172.17.0.1",
+			QOSClass:   apiv1.PodQOSBestEffort,
+			StartTime:  &podStartTime,
+			Conditions: []apiv1.PodCondition{{Type: apiv1.PodReady, Status: apiv1.ConditionTrue}},
+			ContainerStatuses: []apiv1.ContainerStatus{
+				{
+					Name:  "httpd",
+					Ready: true,
+					State: apiv1.ContainerState{Running: &apiv1.ContainerStateRunning{StartedAt: podStartTime}},
+				},
+			},
 		},
 	}
 }
@@ -527,7 +1149,18 @@ func newNGINXPod() *apiv1.Pod {
 			},
 		},
 		Status: apiv1.PodStatus{
-			PodIP: "172.17.0.2",
+			Phase:      apiv1.PodRunning,
+			PodIP:      "172.17.0.2",
+			QOSClass:   apiv1.PodQOSBestEffort,
+			StartTime:  &podStartTime,
+			Conditions: []apiv1.PodCondition{{Type: apiv1.PodReady, Status: apiv1.ConditionTrue}},
+			ContainerStatuses: []apiv1.ContainerStatus{
+				{
+					Name:  "nginx",
+					Ready: true,
+					State: apiv1.ContainerState{Running: &apiv1.ContainerStateRunning{StartedAt: podStartTime}},
+				},
+			},
 		},
 	}
 }
@@ -566,25 +1199,7 @@ func preparePodGroup(pod *apiv1.Pod) *podGroup {
 	group := prepareEmptyPodGroup(pod)
 	for _, container := range pod.Spec.Containers {
 		for _, port := range container.Ports {
-			portNum := strconv.FormatUint(uint64(port.ContainerPort), 10)
-			target := &PodTarget{
-				tuid:           podTUIDWithPort(pod, container, port),
-				Address:        net.JoinHostPort(pod.Status.PodIP, portNum),
-				Namespace:      pod.Namespace,
-				Name:           pod.Name,
-				Annotations:    toMapInterface(pod.Annotations),
-				Labels:         toMapInterface(pod.Labels),
-				NodeName:       pod.Spec.NodeName,
-				PodIP:          pod.Status.PodIP,
-				ControllerName: "netdata-test",
-				ControllerKind: "DaemonSet",
-				ContName:       container.Name,
-				Image:          container.Image,
-				Env:            nil,
-				Port:           portNum,
-				PortName:       port.Name,
-				PortProtocol:   string(port.Protocol),
-			}
+			target := preparePodTarget(pod, container, port)
 			target.hash = mustCalcHash(target)
 			target.Tags().Merge(discoveryTags)
 			group.targets = append(group.targets, target)
@@ -593,6 +1208,81 @@ func preparePodGroup(pod *apiv1.Pod) *podGroup {
 	return group
 }
 
+func preparePodTarget(pod *apiv1.Pod, container apiv1.Container, port apiv1.ContainerPort) *PodTarget {
+	portNum := strconv.FormatUint(uint64(port.ContainerPort), 10)
+	return &PodTarget{
+		tuid:             podTUIDWithPort(pod, container, port),
+		Address:          net.JoinHostPort(pod.Status.PodIP, portNum),
+		Namespace:        pod.Namespace,
+		Name:             pod.Name,
+		Annotations:      toMapInterface(pod.Annotations),
+		Labels:           toMapInterface(pod.Labels),
+		NodeName:         pod.Spec.NodeName,
+		PodIP:            pod.Status.PodIP,
+		Phase:            "Running",
+		Ready:            true,
+		QOSClass:         "BestEffort",
+		StartTime:        "2021-03-01T10:00:00Z",
+		ControllerName:   "netdata-test",
+		ControllerKind:   "DaemonSet",
+		WorkloadName:     "netdata-test",
+		WorkloadKind:     "DaemonSet",
+		ContName:         container.Name,
+		ContainerKind:    "container",
+		Image:            container.Image,
+		Env:              nil,
+		ContReady:        true,
+		ContRestartCount: 0,
+		ContState:        "running",
+		Port:             portNum,
+		PortName:         port.Name,
+		PortProtocol:     string(port.Protocol),
+	}
+}
+
+func preparePodGroupWithContainerKinds(pod *apiv1.Pod, kinds ...string) *podGroup {
+	var targets []*PodTarget
+	enabled := make(map[string]bool)
+	for _, kind := range kinds {
+		enabled[kind] = true
+	}
+
+	if enabled["init"] {
+		target := preparePodTarget(pod, pod.Spec.InitContainers[0], apiv1.ContainerPort{})
+		target.tuid = podTUID(pod, pod.Spec.InitContainers[0])
+		target.Address = pod.Status.PodIP
+		target.Port, target.PortProtocol = "", ""
+		target.ContainerKind = "init"
+		target.ContReady = false
+		target.ContState, target.ContStateReason = "terminated", "Completed"
+		targets = append(targets, target)
+	}
+	if enabled["sidecar"] {
+		sidecar := pod.Spec.InitContainers[1]
+		target := preparePodTarget(pod, sidecar, sidecar.Ports[0])
+		target.ContainerKind = "sidecar"
+		targets = append(targets, target)
+	}
+	if enabled["ephemeral"] {
+		debugger := apiv1.Container(pod.Spec.EphemeralContainers[0].EphemeralContainerCommon)
+		target := preparePodTarget(pod, debugger, apiv1.ContainerPort{})
+		target.tuid = podTUID(pod, debugger)
+		target.Address = pod.Status.PodIP
+		target.Port, target.PortProtocol = "", ""
+		target.ContainerKind = "ephemeral"
+		target.ContReady = false
+		targets = append(targets, target)
+	}
+
+	group := preparePodGroup(pod)
+	for _, target := range targets {
+		target.hash = mustCalcHash(target)
+		target.Tags().Merge(discoveryTags)
+		group.targets = append(group.targets, target)
+	}
+	return group
+}
+
 func preparePodGroupWithEnv(pod *apiv1.Pod, env map[string]string) *podGroup {
 	group := preparePodGroup(pod)
 	for _, target := range group.Targets() {
@@ -601,3 +1291,70 @@ func preparePodGroupWithEnv(pod *apiv1.Pod, env map[string]string) *podGroup {
 	}
 	return group
 }
+
+func preparePodGroupWithWorkload(pod *apiv1.Pod, ctrlKind, ctrlName, wlKind, wlName string) *podGroup {
+	group := preparePodGroup(pod)
+	for _, target := range group.Targets() {
+		target.(*PodTarget).ControllerKind = ctrlKind
+		target.(*PodTarget).ControllerName = ctrlName
+		target.(*PodTarget).WorkloadKind = wlKind
+		target.(*PodTarget).WorkloadName = wlName
+		target.(*PodTarget).hash = mustCalcHash(target)
+	}
+	return group
+}
+
+func preparePodGroupWithScrape(pod *apiv1.Pod, port, path, scheme string, extra *apiv1.ContainerPort) *podGroup {
+	group := preparePodGroup(pod)
+	if extra != nil {
+		target := preparePodTarget(pod, pod.Spec.Containers[0], *extra)
+		target.Tags().Merge(discoveryTags)
+		group.targets = append(group.targets, target)
+	}
+	for _, target := range group.Targets() {
+		target.(*PodTarget).Scrape = true
+		target.(*PodTarget).MetricsPort = port
+		target.(*PodTarget).MetricsPath = path
+		target.(*PodTarget).MetricsScheme = scheme
+		target.(*PodTarget).hash = mustCalcHash(target)
+	}
+	return group
+}
+
+func preparePodGroupWithNode(pod *apiv1.Pod, node *apiv1.Node) *podGroup {
+	group := preparePodGroup(pod)
+	for _, target := range group.Targets() {
+		target.(*PodTarget).NodeLabels = toMapInterface(node.Labels)
+		target.(*PodTarget).NodeAnnotations = toMapInterface(node.Annotations)
+		target.(*PodTarget).NodeZone = node.Labels[apiv1.LabelTopologyZone]
+		target.(*PodTarget).NodeRegion = node.Labels[apiv1.LabelTopologyRegion]
+		target.(*PodTarget).NodeInternalIP = node.Status.Addresses[0].Address
+		target.(*PodTarget).NodeHostname = node.Name
+		target.(*PodTarget).hash = mustCalcHash(target)
+	}
+	return group
+}
+
+func preparePodGroupWithContainerStatus(pod *apiv1.Pod, ready bool, restarts int, state, reason string) *podGroup {
+	group := preparePodGroup(pod)
+	for _, target := range group.Targets() {
+		target.(*PodTarget).Ready = ready
+		target.(*PodTarget).ContReady = ready
+		target.(*PodTarget).ContRestartCount = restarts
+		target.(*PodTarget).ContState = state
+		target.(*PodTarget).ContStateReason = reason
+		target.(*PodTarget).hash = mustCalcHash(target)
+	}
+	return group
+}
+
+func preparePodGroupWithCluster(pod *apiv1.Pod, cluster string) *podGroup {
+	group := preparePodGroup(pod)
+	group.source = clusterSource(cluster, group.source)
+	for _, target := range group.Targets() {
+		target.(*PodTarget).Cluster = cluster
+		target.(*PodTarget).tuid = clusterTUID(cluster, target.TUID())
+		target.(*PodTarget).hash = mustCalcHash(target)
+	}
+	return group
+}
@@ -0,0 +1,70 @@
+package kubernetes
+
+import (
+	"strconv"
+	"strings"
+
+	apiv1 "k8s.io/api/core/v1"
+)
+
+const (
+	annotationScrape = "prometheus.io/scrape"
+	annotationPort   = "prometheus.io/port"
+	annotationPath   = "prometheus.io/path"
+	annotationScheme = "prometheus.io/scheme"
+
+	defaultMetricsPath   = "/metrics"
+	defaultMetricsScheme = "http"
+)
+
+// scrapeAnnotations holds the values of the Prometheus 'prometheus.io/*' annotations convention.
+type scrapeAnnotations struct {
+	scrape bool
+	port   string
+	path   string
+	scheme string
+}
+
+// parseScrapeAnnotations parses the Prometheus annotations. Invalid port and scheme values are ignored.
+// The path and scheme defaults ('/metrics' and 'http') are used only if scraping is enabled.
+func parseScrapeAnnotations(annotations map[string]string) scrapeAnnotations {
+	var sa scrapeAnnotations
+
+	sa.scrape, _ = strconv.ParseBool(strings.TrimSpace(annotations[annotationScrape]))
+
+	if v := strings.TrimSpace(annotations[annotationPort]); v != "" {
+		if n, err := strconv.ParseUint(v, 10, 16); err == nil && n > 0 {
+			sa.port = strconv.FormatUint(n, 10)
+		}
+	}
+
+	sa.path = strings.TrimSpace(annotations[annotationPath])
+
+	switch v := strings.ToLower(strings.TrimSpace(annotations[annotationScheme])); v {
+	case "http", "https":
+		sa.scheme = v
+	}
+
+	if sa.scrape {
+		sa.path = firstNotEmpty(sa.path, defaultMetricsPath)
+		sa.scheme = firstNotEmpty(sa.scheme, defaultMetricsScheme)
+	}
+	return sa
+}
+
+// undeclaredMetricsPort returns the annotated metrics port if scraping is enabled and none of the containers
+// declares it.
+func undeclaredMetricsPort(conts []podContainer, sa scrapeAnnotations) (apiv1.ContainerPort, bool) {
+	if !sa.scrape || sa.port == "" {
+		return apiv1.ContainerPort{}, false
+	}
+	n, _ := strconv.ParseInt(sa.port, 10, 32)
+	for _, pc := range conts {
+		for _, port := range pc.container.Ports {
+			if int64(port.ContainerPort) == n {
+				return apiv1.ContainerPort{}, false
+			}
+		}
+	}
+	return apiv1.ContainerPort{ContainerPort: int32(n), Protocol: apiv1.ProtocolTCP}, true
+}
@@ -0,0 +1,50 @@
+package kubernetes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseScrapeAnnotations(t *testing.T) {
+	tests := map[string]struct {
+		annotations map[string]string
+		expected    scrapeAnnotations
+	}{
+		"no annotations": {},
+		"scrape with defaults": {
+			annotations: map[string]string{annotationScrape: "true"},
+			expected:    scrapeAnnotations{scrape: true, path: "/metrics", scheme: "http"},
+		},
+		"all annotations": {
+			annotations: map[string]string{
+				annotationScrape: "true",
+				annotationPort:   "9090",
+				annotationPath:   "/stats",
+				annotationScheme: "HTTPS",
+			},
+			expected: scrapeAnnotations{scrape: true, port: "9090", path: "/stats", scheme: "https"},
+		},
+		"scrape is disabled": {
+			annotations: map[string]string{annotationScrape: "false", annotationPort: "9090"},
+			expected:    scrapeAnnotations{port: "9090"},
+		},
+		"bad values": {
+			annotations: map[string]string{
+				annotationScrape: "yes",
+				annotationPort:   "http",
+				annotationScheme: "tcp",
+			},
+		},
+		"port out of range": {
+			annotations: map[string]string{annotationScrape: "true", annotationPort: "70000"},
+			expected:    scrapeAnnotations{scrape: true, path: "/metrics", scheme: "http"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.expected, parseScrapeAnnotations(test.annotations))
+		})
+	}
+}
@@ -4,14 +4,20 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/netdata/sd/pipeline/model"
+	"github.com/netdata/sd/pkg/health"
+	"github.com/netdata/sd/pkg/k8s"
 	"github.com/netdata/sd/pkg/log"
 
 	"github.com/rs/zerolog"
 	apiv1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
 )
@@ -27,17 +33,35 @@ type (
 		tuid       string
 		Address    string
 
+		Cluster     string
 		Namespace   string
 		Name        string
 		Annotations map[string]interface{}
 		Labels      map[string]interface{}
+		Selector    map[string]interface{}
 
-		Port         string
-		PortName     string
-		PortProtocol string
-		ClusterIP    string
-		ExternalName string
-		Type         string
+		ReadyEndpoints    int
+		NotReadyEndpoints int
+
+		Scrape        bool
+		MetricsPort   string
+		MetricsPath   string
+		MetricsScheme string
+
+		Port           string
+		PortName       string
+		PortProtocol   string
+		TargetPort     string
+		TargetPortName string
+		ClusterIP      string
+		ExternalName   string
+		Type           string
+
+		Headless      bool
+		EndpointIP    string
+		EndpointReady bool
+		Hostname      string
+		PodName       string
 	}
 )
 
@@ -48,12 +72,21 @@ func (sg serviceGroup) Source() string          { return sg.source }
 func (sg serviceGroup) Targets() []model.Target { return sg.targets }
 
 type Service struct {
-	informer cache.SharedInformer
-	queue    *workqueue.Type
-	log      zerolog.Logger
+	informer   cache.SharedInformer
+	esInformer cache.SharedInformer
+	queue      *workqueue.Type
+	cluster    string
+	health     *health.Reporter
+	log        zerolog.Logger
 }
 
-func NewService(inf cache.SharedInformer) *Service {
+// NewService creates a service discoverer. The endpointslice informer is optional: without it headless services
+// are not expanded and service targets have no endpoints counts and named target ports.
+func NewService(inf, es cache.SharedInformer) *Service {
+	if inf == nil {
+		panic("nil service informer")
+	}
+
 	queue := workqueue.NewWithConfig(workqueue.QueueConfig{Name: "service"})
 	inf.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc:    func(obj interface{}) { enqueue(queue, obj) },
@@ -61,26 +94,51 @@ func NewService(inf cache.SharedInformer) *Service {
 		DeleteFunc: func(obj interface{}) { enqueue(queue, obj) },
 	})
 
-	return &Service{
-		informer: inf,
-		queue:    queue,
-		log:      log.New("k8s service discovery"),
+	s := &Service{
+		informer:   inf,
+		esInformer: es,
+		queue:      queue,
+		health:     health.NewReporter("k8s service discovery"),
+		log:        log.New("k8s service discovery"),
+	}
+	if es == nil {
+		return s
 	}
+	es.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) { s.enqueueSliceService(obj) },
+		UpdateFunc: func(oldObj, obj interface{}) {
+			if !sameSliceEndpoints(oldObj, obj) {
+				s.enqueueSliceService(obj)
+			}
+		},
+		DeleteFunc: func(obj interface{}) { s.enqueueSliceService(obj) },
+	})
+	return s
 }
 
 func (s Service) String() string {
 	return fmt.Sprintf("k8s %s discovery", RoleService)
 }
 
+func (s *Service) Health() []health.Status {
+	return []health.Status{s.health.Status()}
+}
+
+func (s *Service) OnHealthChange(fn func()) { s.health.OnChange(fn) }
+
 func (s *Service) Discover(ctx context.Context, ch chan<- []model.Group) {
 	s.log.Info().Msg("instance is started")
 	defer s.log.Info().Msg("instance is stopped")
 	defer s.queue.ShutDown()
 
+	informers := []cache.SharedInformer{s.informer}
 	go s.informer.Run(ctx.Done())
+	if s.esInformer != nil {
+		informers = append(informers, s.esInformer)
+		go s.esInformer.Run(ctx.Done())
+	}
 
-	if !cache.WaitForCacheSync(ctx.Done(), s.informer.HasSynced) {
-		s.log.Error().Msg("failed to sync caches")
+	if !k8s.DefaultSyncBackoff.WaitForCacheSync(ctx, s.log, s.health, informers...) {
 		return
 	}
 
@@ -89,6 +147,8 @@ func (s *Service) Discover(ctx context.Context, ch chan<- []model.Group) {
 }
 
 func (s *Service) run(ctx context.Context, ch chan<- []model.Group) {
+	initial := newInitialSync(s.queue, s.health)
+
 	for {
 		item, shutdown := s.queue.Get()
 		if shutdown {
@@ -110,7 +170,7 @@ func (s *Service) run(ctx context.Context, ch chan<- []model.Group) {
 			}
 
 			if !exists {
-				group := &serviceGroup{source: serviceSourceFromNsName(namespace, name)}
+				group := &serviceGroup{source: clusterSource(s.cluster, serviceSourceFromNsName(namespace, name))}
 				send(ctx, ch, group)
 				return
 			}
@@ -123,38 +183,58 @@ func (s *Service) run(ctx context.Context, ch chan<- []model.Group) {
 			group := s.buildGroup(svc)
 			send(ctx, ch, group)
 		}()
+		initial.itemDone()
 	}
 }
 
 func (s Service) buildGroup(svc *apiv1.Service) model.Group {
-	// TODO: headless service?
+	slices := s.serviceSlices(svc)
+	if isHeadless(svc) {
+		return &serviceGroup{
+			source:  clusterSource(s.cluster, serviceSource(svc)),
+			targets: s.buildHeadlessTargets(svc, slices),
+		}
+	}
 	if svc.Spec.ClusterIP == "" || len(svc.Spec.Ports) == 0 {
 		return &serviceGroup{
-			source: serviceSource(svc),
+			source: clusterSource(s.cluster, serviceSource(svc)),
 		}
 	}
 	return &serviceGroup{
-		source:  serviceSource(svc),
-		targets: s.buildTargets(svc),
+		source:  clusterSource(s.cluster, serviceSource(svc)),
+		targets: s.buildTargets(svc, slices),
 	}
 }
 
-func (s Service) buildTargets(svc *apiv1.Service) (targets []model.Target) {
+func (s Service) buildTargets(svc *apiv1.Service, slices []*discoveryv1.EndpointSlice) (targets []model.Target) {
+	sa := parseScrapeAnnotations(svc.Annotations)
+	readyEps, notReadyEps := countEndpoints(slices)
 	for _, port := range svc.Spec.Ports {
 		portNum := strconv.FormatInt(int64(port.Port), 10)
+		targetPort, targetPortName := resolveTargetPort(port, slices)
 		target := &ServiceTarget{
-			tuid:         serviceTUID(svc, port),
-			Address:      net.JoinHostPort(svc.Name+"."+svc.Namespace+".svc", portNum),
-			Namespace:    svc.Namespace,
-			Name:         svc.Name,
-			Annotations:  toMapInterface(svc.Annotations),
-			Labels:       toMapInterface(svc.Labels),
-			Port:         portNum,
-			PortName:     port.Name,
-			PortProtocol: string(port.Protocol),
-			ClusterIP:    svc.Spec.ClusterIP,
-			ExternalName: svc.Spec.ExternalName,
-			Type:         string(svc.Spec.Type),
+			tuid:              clusterTUID(s.cluster, serviceTUID(svc, port)),
+			Address:           net.JoinHostPort(svc.Name+"."+svc.Namespace+".svc", portNum),
+			Cluster:           s.cluster,
+			Namespace:         svc.Namespace,
+			Name:              svc.Name,
+			Annotations:       toMapInterface(svc.Annotations),
+			Labels:            toMapInterface(svc.Labels),
+			Selector:          toMapInterface(svc.Spec.Selector),
+			ReadyEndpoints:    readyEps,
+			NotReadyEndpoints: notReadyEps,
+			Scrape:            sa.scrape,
+			MetricsPort:       sa.port,
+			MetricsPath:       sa.path,
+			MetricsScheme:     sa.scheme,
+			Port:              portNum,
+			PortName:          port.Name,
+			PortProtocol:      string(port.Protocol),
+			TargetPort:        targetPort,
+			TargetPortName:    targetPortName,
+			ClusterIP:         svc.Spec.ClusterIP,
+			ExternalName:      svc.Spec.ExternalName,
+			Type:              string(svc.Spec.Type),
 		}
 		hash, err := calcHash(target)
 		if err != nil {
@@ -167,6 +247,257 @@ func (s Service) buildTargets(svc *apiv1.Service) (targets []model.Target) {
 	return targets
 }
 
+// buildHeadlessTargets expands a headless service into a target per endpoint address and port.
+// Only ready endpoints are published in DNS, unless the service tolerates unready endpoints.
+// Endpoint targets have no service endpoints counts, so a readiness change of an endpoint doesn't change the others.
+func (s Service) buildHeadlessTargets(
+	svc *apiv1.Service,
+	slices []*discoveryv1.EndpointSlice,
+) (targets []model.Target) {
+	sa := parseScrapeAnnotations(svc.Annotations)
+	for _, es := range slices {
+		for _, ep := range es.Endpoints {
+			ready := ep.Conditions.Ready == nil || *ep.Conditions.Ready
+			if !ready && !svc.Spec.PublishNotReadyAddresses {
+				continue
+			}
+
+			hostname := derefString(ep.Hostname)
+			var podName string
+			if ep.TargetRef != nil && ep.TargetRef.Kind == "Pod" {
+				podName = ep.TargetRef.Name
+			}
+
+			for _, addr := range ep.Addresses {
+				host := addr
+				if hostname != "" {
+					host = hostname + "." + svc.Name + "." + svc.Namespace + ".svc"
+				}
+
+				base := ServiceTarget{
+					tuid:          clusterTUID(s.cluster, headlessServiceEndpointTUID(svc, addr)),
+					Address:       host,
+					Cluster:       s.cluster,
+					Namespace:     svc.Namespace,
+					Name:          svc.Name,
+					Annotations:   toMapInterface(svc.Annotations),
+					Labels:        toMapInterface(svc.Labels),
+					Selector:      toMapInterface(svc.Spec.Selector),
+					Scrape:        sa.scrape,
+					MetricsPort:   sa.port,
+					MetricsPath:   sa.path,
+					MetricsScheme: sa.scheme,
+					ClusterIP:     svc.Spec.ClusterIP,
+					ExternalName:  svc.Spec.ExternalName,
+					Type:          string(svc.Spec.Type),
+					Headless:      true,
+					EndpointIP:    addr,
+					EndpointReady: ready,
+					Hostname:      hostname,
+					PodName:       podName,
+				}
+
+				ports := slicePorts(es)
+				if len(ports) == 0 {
+					// a headless service may have no ports, the endpoint is still a target (like a container without ports)
+					target := base
+					if hash, err := calcHash(&target); err == nil {
+						target.hash = hash
+						targets = append(targets, &target)
+					}
+					continue
+				}
+
+				for _, port := range ports {
+					portNum := strconv.FormatInt(int64(*port.Port), 10)
+					protocol := derefProtocol(port.Protocol)
+
+					target := base
+					target.tuid = clusterTUID(s.cluster, headlessServiceTUID(svc, addr, protocol, portNum))
+					target.Address = net.JoinHostPort(host, portNum)
+					target.Port = portNum
+					target.PortName = derefString(port.Name)
+					target.PortProtocol = string(protocol)
+					target.TargetPort = portNum
+					target.TargetPortName = targetPortName(svc, derefString(port.Name))
+
+					hash, err := calcHash(&target)
+					if err != nil {
+						continue
+					}
+					target.hash = hash
+
+					targets = append(targets, &target)
+				}
+			}
+		}
+	}
+	return targets
+}
+
+// slicePorts returns the endpoint slice ports with a port number.
+func slicePorts(es *discoveryv1.EndpointSlice) (ports []discoveryv1.EndpointPort) {
+	for _, port := range es.Ports {
+		if port.Port != nil {
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
+
+func (s Service) serviceSlices(svc *apiv1.Service) (slices []*discoveryv1.EndpointSlice) {
+	if s.esInformer == nil {
+		return nil
+	}
+	for _, item := range s.serviceSliceItems(svc) {
+		es, err := toEndpointSlice(item)
+		if err != nil {
+			continue
+		}
+		if es.Namespace == svc.Namespace && es.Labels[discoveryv1.LabelServiceName] == svc.Name {
+			slices = append(slices, es)
+		}
+	}
+	sort.Slice(slices, func(i, j int) bool { return slices[i].Name < slices[j].Name })
+	return slices
+}
+
+// serviceSliceItems returns the service slices using the slice informer service index,
+// all the slices are returned if the informer has no such index.
+func (s Service) serviceSliceItems(svc *apiv1.Service) []interface{} {
+	if inf, ok := s.esInformer.(cache.SharedIndexInformer); ok {
+		if items, err := inf.GetIndexer().ByIndex(endpointSliceServiceIndex, svc.Namespace+"/"+svc.Name); err == nil {
+			return items
+		}
+	}
+	return s.esInformer.GetStore().List()
+}
+
+// endpointSliceServiceIndex is the name of the slice informers index by the service ('namespace/name') they belong to.
+const endpointSliceServiceIndex = "metadata.labels.service-name"
+
+func endpointSliceServiceIndexFunc(obj interface{}) ([]string, error) {
+	es, err := toEndpointSlice(obj)
+	if err != nil {
+		return nil, nil
+	}
+	name := es.Labels[discoveryv1.LabelServiceName]
+	if name == "" {
+		return nil, nil
+	}
+	return []string{es.Namespace + "/" + name}, nil
+}
+
+// enqueueSliceService enqueues the service that owns the endpoint slice.
+func (s *Service) enqueueSliceService(obj interface{}) {
+	if d, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = d.Obj
+	}
+	es, err := toEndpointSlice(obj)
+	if err != nil {
+		return
+	}
+	name := es.Labels[discoveryv1.LabelServiceName]
+	if name == "" {
+		return
+	}
+	key := es.Namespace + "/" + name
+	if _, exists, err := s.informer.GetStore().GetByKey(key); err == nil && exists {
+		s.queue.Add(key)
+	}
+}
+
+// sameSliceEndpoints reports whether the endpoint slice fields used by service targets are unchanged.
+func sameSliceEndpoints(oldObj, obj interface{}) bool {
+	es1, err1 := toEndpointSlice(oldObj)
+	es2, err2 := toEndpointSlice(obj)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	return reflect.DeepEqual(es1.Endpoints, es2.Endpoints) && reflect.DeepEqual(es1.Ports, es2.Ports)
+}
+
+// countEndpoints counts the ready and not ready endpoints of the service slices. Endpoints referencing the same
+// object (dual-stack services have a slice per address family) are counted once.
+func countEndpoints(slices []*discoveryv1.EndpointSlice) (ready, notReady int) {
+	seen := make(map[string]bool)
+	for _, es := range slices {
+		for _, ep := range es.Endpoints {
+			id := strings.Join(ep.Addresses, ",")
+			if ep.TargetRef != nil {
+				id = ep.TargetRef.Kind + "/" + ep.TargetRef.Namespace + "/" + ep.TargetRef.Name
+			}
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			if ep.Conditions.Ready == nil || *ep.Conditions.Ready {
+				ready++
+			} else {
+				notReady++
+			}
+		}
+	}
+	return ready, notReady
+}
+
+// resolveTargetPort returns the service port target port number and name (if it is a named port). Named ports are
+// resolved using the endpoint slices: the EndpointSlice controller resolves them against the backing pods.
+func resolveTargetPort(port apiv1.ServicePort, slices []*discoveryv1.EndpointSlice) (number, name string) {
+	switch {
+	case port.TargetPort.Type == intstr.String:
+		name = port.TargetPort.StrVal
+	case port.TargetPort.IntVal > 0:
+		return strconv.FormatInt(int64(port.TargetPort.IntVal), 10), ""
+	default:
+		// target port defaults to the service port
+		return strconv.FormatInt(int64(port.Port), 10), ""
+	}
+	for _, es := range slices {
+		for _, p := range es.Ports {
+			if derefString(p.Name) == port.Name && p.Port != nil {
+				return strconv.FormatInt(int64(*p.Port), 10), name
+			}
+		}
+	}
+	return "", name
+}
+
+// targetPortName returns the named target port of the service port.
+func targetPortName(svc *apiv1.Service, portName string) string {
+	for _, port := range svc.Spec.Ports {
+		if port.Name == portName && port.TargetPort.Type == intstr.String {
+			return port.TargetPort.StrVal
+		}
+	}
+	return ""
+}
+
+func isHeadless(svc *apiv1.Service) bool {
+	if svc.Spec.Type == apiv1.ServiceTypeExternalName {
+		return false
+	}
+	return svc.Spec.ClusterIP == apiv1.ClusterIPNone || svc.Spec.ClusterIP == ""
+}
+
+func headlessServiceEndpointTUID(svc *apiv1.Service, addr string) string {
+	return fmt.Sprintf("%s_%s_%s",
+		svc.Namespace,
+		svc.Name,
+		addr,
+	)
+}
+
+func headlessServiceTUID(svc *apiv1.Service, addr string, protocol apiv1.Protocol, port string) string {
+	return fmt.Sprintf("%s_%s_%s_%s_%s",
+		svc.Namespace,
+		svc.Name,
+		addr,
+		strings.ToLower(string(protocol)),
+		port,
+	)
+}
+
 func serviceTUID(svc *apiv1.Service, port apiv1.ServicePort) string {
 	return fmt.Sprintf("%s_%s_%s_%s",
 		svc.Namespace,
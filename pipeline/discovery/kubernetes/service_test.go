@@ -10,8 +10,13 @@ import (
 	"github.com/netdata/sd/pipeline/model"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	apiv1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
 )
 
@@ -110,10 +115,10 @@ func TestServiceTarget_Hash(t *testing.T) {
 				return sim
 			},
 			expectedHash: []uint64{
-				17611803477081780974,
-				6019985892433421258,
-				4151907287549842238,
-				5757608926096186119,
+				6478120742016657359,
+				5267649847440976591,
+				14016735569529231985,
+				279991363960016492,
 			},
 		},
 	}
@@ -178,19 +183,26 @@ func TestServiceTarget_TUID(t *testing.T) {
 
 func TestNewService(t *testing.T) {
 	tests := map[string]struct {
-		informer  cache.SharedInformer
+		svcInf    cache.SharedInformer
+		esInf     cache.SharedInformer
 		wantPanic bool
 	}{
-		"valid informer": {informer: cache.NewSharedInformer(nil, &apiv1.Service{}, resyncPeriod)},
-		"nil informer":   {wantPanic: true},
+		"valid informers": {
+			svcInf: cache.NewSharedInformer(nil, &apiv1.Service{}, resyncPeriod),
+			esInf:  cache.NewSharedInformer(nil, &discoveryv1.EndpointSlice{}, resyncPeriod),
+		},
+		"nil endpointslice informer": {
+			svcInf: cache.NewSharedInformer(nil, &apiv1.Service{}, resyncPeriod),
+		},
+		"nil informers": {wantPanic: true},
 	}
 
 	for name, test := range tests {
 		t.Run(name, func(t *testing.T) {
 			if test.wantPanic {
-				assert.Panics(t, func() { NewService(nil) })
+				assert.Panics(t, func() { NewService(nil, nil) })
 			} else {
-				assert.IsType(t, &Service{}, NewService(test.informer))
+				assert.IsType(t, &Service{}, NewService(test.svcInf, test.esInf))
 			}
 		})
 	}
@@ -286,6 +298,103 @@ func TestService_Discover(t *testing.T) {
 			}
 			return sim
 		},
+		"ADD: Headless svc with endpoints exist before run": func() discoverySim {
+			httpd := newHTTPDHeadlessService()
+			httpdPod := newHTTPDPod()
+			es := newEndpointSlice("httpd-headless-service-abcde", httpd.Name, httpdPod)
+			hostname := "httpd-0"
+			es.Endpoints[0].Hostname = &hostname
+			discovery, _ := prepareEndpointsSvcDiscovery(httpd, es)
+
+			sim := discoverySim{
+				discovery: discovery,
+				expectedGroups: []model.Group{
+					prepareHeadlessSvcGroup(httpd, es),
+				},
+			}
+			return sim
+		},
+		"ADD: Headless svc without ports": func() discoverySim {
+			httpd := newHTTPDHeadlessService()
+			httpd.Spec.Ports = nil
+			httpdPod := newHTTPDPod()
+			es := newEndpointSlice("httpd-headless-service-abcde", httpd.Name, httpdPod)
+			es.Ports = nil
+			discovery, _ := prepareEndpointsSvcDiscovery(httpd, es)
+
+			addr := es.Endpoints[0].Addresses[0]
+			target := &ServiceTarget{
+				tuid:          headlessServiceEndpointTUID(httpd, addr),
+				Address:       addr,
+				Namespace:     httpd.Namespace,
+				Name:          httpd.Name,
+				Annotations:   toMapInterface(httpd.Annotations),
+				Labels:        toMapInterface(httpd.Labels),
+				Selector:      toMapInterface(httpd.Spec.Selector),
+				Type:          string(httpd.Spec.Type),
+				Headless:      true,
+				EndpointIP:    addr,
+				EndpointReady: true,
+				PodName:       httpdPod.Name,
+			}
+			target.hash = mustCalcHash(target)
+			target.Tags().Merge(discoveryTags)
+
+			sim := discoverySim{
+				discovery: discovery,
+				expectedGroups: []model.Group{
+					&serviceGroup{source: serviceSource(httpd), targets: []model.Target{target}},
+				},
+			}
+			return sim
+		},
+		"ADD: Headless svc with endpoints and endpoints watching disabled": func() discoverySim {
+			httpd := newHTTPDHeadlessService()
+			es := newEndpointSlice("httpd-headless-service-abcde", httpd.Name, newHTTPDPod())
+			discovery, _ := prepareAllNsDiscovery(RoleService, httpd, es)
+
+			sim := discoverySim{
+				discovery: discovery,
+				expectedGroups: []model.Group{
+					prepareEmptySvcGroup(httpd),
+				},
+			}
+			return sim
+		},
+		"UPDATE: Headless svc endpoints added after sync": func() discoverySim {
+			httpd := newHTTPDHeadlessService()
+			es := newEndpointSlice("httpd-headless-service-abcde", httpd.Name, newHTTPDPod())
+			discovery, clientset := prepareEndpointsSvcDiscovery(httpd)
+			esClient := clientset.DiscoveryV1().EndpointSlices("default")
+
+			sim := discoverySim{
+				discovery: discovery,
+				runAfterSync: func(ctx context.Context) {
+					time.Sleep(time.Millisecond * 50)
+					_, _ = esClient.Create(ctx, es, metav1.CreateOptions{})
+				},
+				expectedGroups: []model.Group{
+					prepareEmptySvcGroup(httpd),
+					prepareHeadlessSvcGroup(httpd, es),
+				},
+			}
+			return sim
+		},
+		"ADD: Headless svc with not ready endpoints": func() discoverySim {
+			httpd := newHTTPDHeadlessService()
+			es := newEndpointSlice("httpd-headless-service-abcde", httpd.Name, newHTTPDPod())
+			notReady := false
+			es.Endpoints[0].Conditions.Ready = &notReady
+			discovery, _ := prepareEndpointsSvcDiscovery(httpd, es)
+
+			sim := discoverySim{
+				discovery: discovery,
+				expectedGroups: []model.Group{
+					prepareEmptySvcGroup(httpd),
+				},
+			}
+			return sim
+		},
 		"UPDATE: Headless => ClusterIP svc after sync": func() discoverySim {
 			httpd, nginx := newHTTPDHeadlessService(), newNGINXHeadlessService()
 			httpdUpd, nginxUpd := *httpd, *nginx
@@ -310,6 +419,81 @@ func TestService_Discover(t *testing.T) {
 			}
 			return sim
 		},
+		"ADD: ClusterIP svc with Prometheus annotations": func() discoverySim {
+			httpd := newHTTPDClusterIPService()
+			httpd.Annotations[annotationScrape] = "true"
+			httpd.Annotations[annotationPort] = "9090"
+			httpd.Annotations[annotationScheme] = "HTTPS"
+			discovery, _ := prepareAllNsDiscovery(RoleService, httpd)
+
+			sim := discoverySim{
+				discovery: discovery,
+				expectedGroups: []model.Group{
+					prepareSvcGroupWithScrape(httpd, "9090", "/metrics", "https"),
+				},
+			}
+			return sim
+		},
+		"ADD: ClusterIP svc with endpoints and named target port": func() discoverySim {
+			httpd := newHTTPDClusterIPService()
+			httpd.Spec.Ports[0].TargetPort = intstr.FromString("web")
+			httpd.Spec.Ports[1].TargetPort = intstr.FromInt32(8443)
+			es := newEndpointSlice("httpd-cluster-ip-service-abcde", httpd.Name, newHTTPDPod())
+			webPort := int32(8080)
+			es.Ports[0].Port = &webPort
+			addNotReadyEndpoint(es, newNGINXPod())
+			discovery, _ := prepareEndpointsSvcDiscovery(httpd, es)
+
+			sim := discoverySim{
+				discovery: discovery,
+				expectedGroups: []model.Group{
+					prepareSvcGroupWithEndpoints(httpd, 1, 1, [][2]string{{"8080", "web"}, {"8443", ""}}),
+				},
+			}
+			return sim
+		},
+		"ADD: ClusterIP svc with endpoints and endpoints watching disabled": func() discoverySim {
+			httpd := newHTTPDClusterIPService()
+			httpd.Spec.Ports[0].TargetPort = intstr.FromString("web")
+			httpd.Spec.Ports[1].TargetPort = intstr.FromInt32(8443)
+			es := newEndpointSlice("httpd-cluster-ip-service-abcde", httpd.Name, newHTTPDPod())
+			discovery, _ := prepareAllNsDiscovery(RoleService, httpd, es)
+
+			sim := discoverySim{
+				discovery: discovery,
+				expectedGroups: []model.Group{
+					prepareSvcGroupWithEndpoints(httpd, 0, 0, [][2]string{{"", "web"}, {"8443", ""}}),
+				},
+			}
+			return sim
+		},
+		"UPDATE: ClusterIP svc endpoint becomes ready after sync": func() discoverySim {
+			httpd := newHTTPDClusterIPService()
+			es := newEndpointSlice("httpd-cluster-ip-service-abcde", httpd.Name, newHTTPDPod())
+			notReady := false
+			es.Endpoints[0].Conditions.Ready = &notReady
+			relabeled := es.DeepCopy()
+			relabeled.Annotations["phase"] = "dev"
+			ready := relabeled.DeepCopy()
+			ready.Endpoints[0].Conditions.Ready = nil
+			discovery, clientset := prepareEndpointsSvcDiscovery(httpd, es)
+			esClient := clientset.DiscoveryV1().EndpointSlices("default")
+
+			sim := discoverySim{
+				discovery: discovery,
+				runAfterSync: func(ctx context.Context) {
+					time.Sleep(time.Millisecond * 50)
+					_, _ = esClient.Update(ctx, relabeled, metav1.UpdateOptions{})
+					time.Sleep(time.Millisecond * 50)
+					_, _ = esClient.Update(ctx, ready, metav1.UpdateOptions{})
+				},
+				expectedGroups: []model.Group{
+					prepareSvcGroupWithEndpoints(httpd, 0, 1, [][2]string{{"80", ""}, {"443", ""}}),
+					prepareSvcGroupWithEndpoints(httpd, 1, 0, [][2]string{{"80", ""}, {"443", ""}}),
+				},
+			}
+			return sim
+		},
 		"ADD: ClusterIP svc with zero exposed ports": func() discoverySim {
 			httpd, nginx := newHTTPDClusterIPService(), newNGINXClusterIPService()
 			httpd.Spec.Ports = httpd.Spec.Ports[:0]
@@ -333,6 +517,53 @@ func TestService_Discover(t *testing.T) {
 
 }
 
+func TestService_buildHeadlessTargets_ReadinessChange(t *testing.T) {
+	var s Service
+	httpd := newHTTPDHeadlessService()
+	httpd.Spec.PublishNotReadyAddresses = true
+	es := newEndpointSlice("httpd-headless-service-abcde", httpd.Name, newHTTPDPod())
+	nginx := newEndpointSlice("", httpd.Name, newNGINXPod()).Endpoints[0]
+	es.Endpoints = append(es.Endpoints, nginx)
+
+	flipped := es.DeepCopy()
+	notReady := false
+	flipped.Endpoints[1].Conditions.Ready = &notReady
+
+	before := s.buildHeadlessTargets(httpd, []*discoveryv1.EndpointSlice{es})
+	after := s.buildHeadlessTargets(httpd, []*discoveryv1.EndpointSlice{flipped})
+	require.Len(t, before, 4)
+	require.Len(t, after, 4)
+
+	for i, target := range before {
+		if target.(*ServiceTarget).EndpointIP == nginx.Addresses[0] {
+			assert.NotEqual(t, target.Hash(), after[i].Hash(), "flipped endpoint target hash must change")
+			assert.False(t, after[i].(*ServiceTarget).EndpointReady)
+		} else {
+			assert.Equal(t, target.Hash(), after[i].Hash(), "other endpoints targets hashes must not change")
+		}
+	}
+}
+
+func TestCountEndpoints(t *testing.T) {
+	ipv4 := newEndpointSlice("httpd-cluster-ip-service-abcde", "httpd-cluster-ip-service", newHTTPDPod())
+	addNotReadyEndpoint(ipv4, newNGINXPod())
+	ipv6 := ipv4.DeepCopy()
+	ipv6.Name = "httpd-cluster-ip-service-fghij"
+	ipv6.AddressType = discoveryv1.AddressTypeIPv6
+	ipv6.Endpoints[0].Addresses = []string{"fd00::1"}
+	ipv6.Endpoints[1].Addresses = []string{"fd00::2"}
+
+	ready, notReady := countEndpoints([]*discoveryv1.EndpointSlice{ipv4, ipv6})
+	assert.Equal(t, 1, ready)
+	assert.Equal(t, 1, notReady)
+}
+
+func prepareEndpointsSvcDiscovery(objects ...runtime.Object) (*Discovery, kubernetes.Interface) {
+	discovery, clientset := prepareAllNsDiscovery(RoleService, objects...)
+	discovery.serviceConfig.Endpoints = true
+	return discovery, clientset
+}
+
 func newHTTPDClusterIPService() *apiv1.Service {
 	return &apiv1.Service{
 		ObjectMeta: metav1.ObjectMeta{
@@ -391,6 +622,47 @@ func prepareEmptySvcGroup(svc *apiv1.Service) *serviceGroup {
 	return &serviceGroup{source: serviceSource(svc)}
 }
 
+func prepareHeadlessSvcGroup(svc *apiv1.Service, es *discoveryv1.EndpointSlice) *serviceGroup {
+	group := prepareEmptySvcGroup(svc)
+	for _, ep := range es.Endpoints {
+		for _, addr := range ep.Addresses {
+			host := addr
+			var hostname string
+			if ep.Hostname != nil {
+				hostname = *ep.Hostname
+				host = hostname + "." + svc.Name + "." + svc.Namespace + ".svc"
+			}
+			for _, port := range es.Ports {
+				portNum := strconv.FormatInt(int64(*port.Port), 10)
+				target := &ServiceTarget{
+					tuid:          headlessServiceTUID(svc, addr, *port.Protocol, portNum),
+					Address:       net.JoinHostPort(host, portNum),
+					Namespace:     svc.Namespace,
+					Name:          svc.Name,
+					Annotations:   toMapInterface(svc.Annotations),
+					Labels:        toMapInterface(svc.Labels),
+					Selector:      toMapInterface(svc.Spec.Selector),
+					Port:          portNum,
+					PortName:      *port.Name,
+					PortProtocol:  string(*port.Protocol),
+					TargetPort:    portNum,
+					ClusterIP:     svc.Spec.ClusterIP,
+					Type:          string(svc.Spec.Type),
+					Headless:      true,
+					EndpointIP:    addr,
+					EndpointReady: ep.Conditions.Ready == nil || *ep.Conditions.Ready,
+					Hostname:      hostname,
+					PodName:       ep.TargetRef.Name,
+				}
+				target.hash = mustCalcHash(target)
+				target.Tags().Merge(discoveryTags)
+				group.targets = append(group.targets, target)
+			}
+		}
+	}
+	return group
+}
+
 func prepareSvcGroup(svc *apiv1.Service) *serviceGroup {
 	group := prepareEmptySvcGroup(svc)
 	for _, port := range svc.Spec.Ports {
@@ -402,9 +674,11 @@ func prepareSvcGroup(svc *apiv1.Service) *serviceGroup {
 			Name:         svc.Name,
 			Annotations:  toMapInterface(svc.Annotations),
 			Labels:       toMapInterface(svc.Labels),
+			Selector:     toMapInterface(svc.Spec.Selector),
 			Port:         portNum,
 			PortName:     port.Name,
 			PortProtocol: string(port.Protocol),
+			TargetPort:   portNum,
 			ClusterIP:    svc.Spec.ClusterIP,
 			ExternalName: svc.Spec.ExternalName,
 			Type:         string(svc.Spec.Type),
@@ -415,3 +689,36 @@ func prepareSvcGroup(svc *apiv1.Service) *serviceGroup {
 	}
 	return group
 }
+
+func prepareSvcGroupWithScrape(svc *apiv1.Service, port, path, scheme string) *serviceGroup {
+	group := prepareSvcGroup(svc)
+	for _, target := range group.Targets() {
+		target.(*ServiceTarget).Scrape = true
+		target.(*ServiceTarget).MetricsPort = port
+		target.(*ServiceTarget).MetricsPath = path
+		target.(*ServiceTarget).MetricsScheme = scheme
+		target.(*ServiceTarget).hash = mustCalcHash(target)
+	}
+	return group
+}
+
+func prepareSvcGroupWithEndpoints(svc *apiv1.Service, readyEps, notReadyEps int, targetPorts [][2]string) *serviceGroup {
+	group := prepareSvcGroup(svc)
+	for i, target := range group.Targets() {
+		target.(*ServiceTarget).ReadyEndpoints = readyEps
+		target.(*ServiceTarget).NotReadyEndpoints = notReadyEps
+		target.(*ServiceTarget).TargetPort = targetPorts[i][0]
+		target.(*ServiceTarget).TargetPortName = targetPorts[i][1]
+		target.(*ServiceTarget).hash = mustCalcHash(target)
+	}
+	return group
+}
+
+func addNotReadyEndpoint(es *discoveryv1.EndpointSlice, pod *apiv1.Pod) {
+	notReady := false
+	es.Endpoints = append(es.Endpoints, discoveryv1.Endpoint{
+		Addresses:  []string{pod.Status.PodIP},
+		Conditions: discoveryv1.EndpointConditions{Ready: &notReady},
+		TargetRef:  &apiv1.ObjectReference{Kind: "Pod", Name: pod.Name, Namespace: pod.Namespace},
+	})
+}
@@ -107,6 +107,11 @@ var (
 	_ hasSynced = &Discovery{}
 	_ hasSynced = &Pod{}
 	_ hasSynced = &Service{}
+	_ hasSynced = &EndpointSlice{}
+	_ hasSynced = &Node{}
+	_ hasSynced = &Ingress{}
+	_ hasSynced = &Namespace{}
+	_ hasSynced = &Custom{}
 )
 
 func (d *Discovery) hasSynced() bool {
@@ -120,11 +125,49 @@ func (d *Discovery) hasSynced() bool {
 }
 
 func (p *Pod) hasSynced() bool {
-	return p.podInformer.HasSynced() && p.cmapInformer.HasSynced() && p.secretInformer.HasSynced()
+	for _, inf := range []cache.SharedInformer{
+		p.cmapInformer, p.secretInformer, p.rsInformer, p.jobInformer, p.nodeInformer,
+	} {
+		if inf != nil && !inf.HasSynced() {
+			return false
+		}
+	}
+	return p.podInformer.HasSynced()
 }
 
 func (s *Service) hasSynced() bool {
-	return s.informer.HasSynced()
+	return s.informer.HasSynced() && (s.esInformer == nil || s.esInformer.HasSynced())
+}
+
+func (e *EndpointSlice) hasSynced() bool {
+	return e.informer.HasSynced() && e.podInformer.HasSynced()
+}
+
+func (n *Node) hasSynced() bool {
+	return n.informer.HasSynced()
+}
+
+func (i *Ingress) hasSynced() bool {
+	return i.informer.HasSynced()
+}
+
+func (c *Custom) hasSynced() bool {
+	return c.informer.HasSynced()
+}
+
+func (n *Namespace) hasSynced() bool {
+	if !n.informer.HasSynced() || n.queue.Len() > 0 {
+		return false
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, nd := range n.running {
+		v, ok := nd.discoverer.(hasSynced)
+		if !ok || !v.hasSynced() {
+			return false
+		}
+	}
+	return true
 }
 
 func sortGroups(groups []model.Group) {
@@ -4,34 +4,89 @@ import (
 	"context"
 	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/netdata/sd/pipeline/discovery/consul"
+	"github.com/netdata/sd/pipeline/discovery/dns"
+	"github.com/netdata/sd/pipeline/discovery/docker"
+	"github.com/netdata/sd/pipeline/discovery/file"
 	"github.com/netdata/sd/pipeline/discovery/kubernetes"
+	"github.com/netdata/sd/pipeline/discovery/netlisteners"
+	"github.com/netdata/sd/pipeline/discovery/process"
 	"github.com/netdata/sd/pipeline/model"
+	"github.com/netdata/sd/pkg/health"
 	"github.com/netdata/sd/pkg/log"
 
 	"github.com/rs/zerolog"
 )
 
 type Config struct {
-	K8S []kubernetes.Config `yaml:"k8s"`
+	// SendInterval is how often the collected updates are sent to the pipeline.
+	SendInterval time.Duration `yaml:"send_interval"`
+	// SendOnSync enables sending the first snapshot as soon as all the discoverers are synced.
+	SendOnSync bool `yaml:"send_on_sync"`
+	// MaxDelay limits waiting for the discoverers to sync before sending the first snapshot,
+	// and waiting for the send interval before sending the next updates.
+	MaxDelay time.Duration `yaml:"max_delay"`
+
+	K8S          []kubernetes.Config   `yaml:"k8s"`
+	Docker       []docker.Config       `yaml:"docker"`
+	NetListeners []netlisteners.Config `yaml:"net_listeners"`
+	File         []file.Config         `yaml:"file"`
+	Consul       []consul.Config       `yaml:"consul"`
+	DNS          []dns.Config          `yaml:"dns"`
+	Process      []process.Config      `yaml:"process"`
+}
+
+func (c Config) discoverersNum() int {
+	return len(c.K8S) +
+		len(c.Docker) +
+		len(c.NetListeners) +
+		len(c.File) +
+		len(c.Consul) +
+		len(c.DNS) +
+		len(c.Process)
 }
 
 func validateConfig(cfg Config) error {
-	if len(cfg.K8S) == 0 {
+	if cfg.discoverersNum() == 0 {
 		return errors.New("empty config")
 	}
+	if cfg.SendInterval < 0 {
+		return errors.New("negative send_interval")
+	}
+	if cfg.MaxDelay < 0 {
+		return errors.New("negative max_delay")
+	}
+	if cfg.MaxDelay > 0 && !cfg.SendOnSync {
+		return errors.New("max_delay is set, but send_on_sync is disabled")
+	}
 	return nil
 }
 
+const (
+	defaultSendInterval = 5 * time.Second
+	defaultMaxDelay     = 30 * time.Second
+)
+
 type (
 	discoverer interface {
 		Discover(ctx context.Context, in chan<- []model.Group)
 	}
+	healthReporter interface {
+		Health() []health.Status
+		// OnHealthChange registers a function that is called after every health state change.
+		OnHealthChange(fn func())
+	}
 	Manager struct {
 		discoverers []discoverer
 		send        chan struct{}
 		sendEvery   time.Duration
+		sendOnSync  bool
+		maxDelay    time.Duration
+		synced      chan struct{}
+		unsynced    atomic.Int32
 		cache       *cache
 		log         zerolog.Logger
 	}
@@ -43,11 +98,19 @@ func New(cfg Config) (*Manager, error) {
 	}
 	mgr := &Manager{
 		send:        make(chan struct{}, 1),
-		sendEvery:   5 * time.Second,
+		sendEvery:   cfg.SendInterval,
+		sendOnSync:  cfg.SendOnSync,
+		maxDelay:    cfg.MaxDelay,
 		discoverers: make([]discoverer, 0),
 		cache:       newCache(),
 		log:         log.New("discovery manager"),
 	}
+	if mgr.sendEvery == 0 {
+		mgr.sendEvery = defaultSendInterval
+	}
+	if mgr.maxDelay == 0 {
+		mgr.maxDelay = defaultMaxDelay
+	}
 	if err := mgr.registerDiscoverers(cfg); err != nil {
 		return nil, err
 	}
@@ -64,13 +127,74 @@ func (m *Manager) registerDiscoverers(conf Config) error {
 		}
 		m.discoverers = append(m.discoverers, d)
 	}
+	for _, cfg := range conf.Docker {
+		d, err := docker.NewDiscovery(cfg)
+		if err != nil {
+			return err
+		}
+		m.discoverers = append(m.discoverers, d)
+	}
+	for _, cfg := range conf.NetListeners {
+		d, err := netlisteners.NewDiscovery(cfg)
+		if err != nil {
+			return err
+		}
+		m.discoverers = append(m.discoverers, d)
+	}
+	for _, cfg := range conf.File {
+		d, err := file.NewDiscovery(cfg)
+		if err != nil {
+			return err
+		}
+		m.discoverers = append(m.discoverers, d)
+	}
+	for _, cfg := range conf.Consul {
+		d, err := consul.NewDiscovery(cfg)
+		if err != nil {
+			return err
+		}
+		m.discoverers = append(m.discoverers, d)
+	}
+	for _, cfg := range conf.DNS {
+		d, err := dns.NewDiscovery(cfg)
+		if err != nil {
+			return err
+		}
+		m.discoverers = append(m.discoverers, d)
+	}
+	for _, cfg := range conf.Process {
+		d, err := process.NewDiscovery(cfg)
+		if err != nil {
+			return err
+		}
+		m.discoverers = append(m.discoverers, d)
+	}
 	return nil
 }
 
+// Health returns the statuses of the discoverers that report their health.
+func (m *Manager) Health() []health.Status {
+	var statuses []health.Status
+	for _, d := range m.discoverers {
+		if v, ok := d.(healthReporter); ok {
+			statuses = append(statuses, v.Health()...)
+		}
+	}
+	return statuses
+}
+
 func (m *Manager) Discover(ctx context.Context, in chan<- []model.Group) {
 	m.log.Info().Msg("instance is started")
 	defer m.log.Info().Msg("instance is stopped")
 
+	if m.sendOnSync {
+		m.synced = make(chan struct{})
+		m.unsynced.Store(int32(len(m.discoverers)))
+		if len(m.discoverers) == 0 {
+			close(m.synced)
+		}
+	}
+
 	var wg sync.WaitGroup
 
 	for _, d := range m.discoverers {
@@ -86,6 +210,16 @@ func (m *Manager) Discover(ctx context.Context, in chan<- []model.Group) {
 }
 
 func (m *Manager) runDiscoverer(ctx context.Context, d discoverer) {
+	// discoverers that don't report their health are synced after their first groups are received
+	var waiter *health.SyncWaiter
+	var firstGroups bool
+	if hr, ok := d.(healthReporter); ok && m.sendOnSync {
+		waiter = health.NewSyncWaiter(hr.Health)
+		hr.OnHealthChange(waiter.Notify)
+	} else {
+		firstGroups = m.sendOnSync
+	}
+
 	updates := make(chan []model.Group)
 	go d.Discover(ctx, updates)
 
@@ -93,6 +227,11 @@ func (m *Manager) runDiscoverer(ctx context.Context, d discoverer) {
 		select {
 		case <-ctx.Done():
 			return
+		case <-waiter.Changed():
+			// discoverers are synced after their initial groups are received, they are cached at this point
+			if waiter.Check() {
+				m.markSynced()
+			}
 		case groups, ok := <-updates:
 			if !ok {
 				return
@@ -104,37 +243,113 @@ func (m *Manager) runDiscoverer(ctx context.Context, d discoverer) {
 				m.cache.update(groups)
 				m.triggerSend()
 			}()
+			if firstGroups {
+				firstGroups = false
+				m.markSynced()
+			}
 		}
 	}
 }
 
+func (m *Manager) markSynced() {
+	if m.unsynced.Add(-1) == 0 {
+		close(m.synced)
+	}
+}
+
 func (m *Manager) run(ctx context.Context, in chan<- []model.Group) {
+	if m.sendOnSync && !m.sendFirst(ctx, in) {
+		return
+	}
+
 	tk := time.NewTicker(m.sendEvery)
 	defer tk.Stop()
 
+	// with send_on_sync enabled, pending updates wait for the send interval no longer than the max delay
+	var pending bool
+	var deadline <-chan time.Time
+
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-tk.C:
-			select {
-			case <-m.send:
-				m.trySend(in)
-			default:
+		case <-m.send:
+			if !pending && m.sendOnSync {
+				deadline = time.After(m.maxDelay)
 			}
+			pending = true
+			continue
+		case <-tk.C:
+		case <-deadline:
+			deadline = nil
+		}
+		if pending && m.trySend(in) {
+			pending, deadline = false, nil
+		}
+	}
+}
+
+// sendFirst sends the first snapshot once all the discoverers are synced, but no later than the max delay.
+func (m *Manager) sendFirst(ctx context.Context, in chan<- []model.Group) bool {
+	tm := time.NewTimer(m.maxDelay)
+	defer tm.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-m.synced:
+		m.log.Info().Msg("all discoverers are synced, sending the first snapshot")
+	case <-tm.C:
+		m.log.Warn().Msgf("not all discoverers are synced after %s, sending the first snapshot", m.maxDelay)
+	}
+
+	// nothing discovered yet, the first update is sent right away
+	groups := m.takeSnapshot()
+	for len(groups) == 0 {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-m.send:
+			groups = m.takeSnapshot()
 		}
 	}
+
+	select {
+	case <-ctx.Done():
+		return false
+	case in <- groups:
+		return true
+	}
 }
 
-func (m *Manager) trySend(in chan<- []model.Group) {
+func (m *Manager) takeSnapshot() []model.Group {
+	m.cache.mu.Lock()
+	defer m.cache.mu.Unlock()
+
+	select {
+	case <-m.send:
+	default:
+	}
+	groups := m.cache.asList()
+	m.cache.reset()
+	return groups
+}
+
+func (m *Manager) trySend(in chan<- []model.Group) bool {
 	m.cache.mu.Lock()
 	defer m.cache.mu.Unlock()
 
 	select {
 	case in <- m.cache.asList():
+		// the pending send trigger is for the updates that are already sent
+		select {
+		case <-m.send:
+		default:
+		}
 		m.cache.reset()
+		return true
 	default:
-		m.triggerSend()
+		return false
 	}
 }
 
@@ -6,11 +6,64 @@ import (
 	"testing"
 	"time"
 
+	"github.com/netdata/sd/pipeline/discovery/dns"
 	"github.com/netdata/sd/pipeline/model"
+	"github.com/netdata/sd/pkg/health"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNew(t *testing.T) {
+	dnsCfg := []dns.Config{{Tags: "dns", Names: []string{"example.com"}}}
+
+	tests := map[string]struct {
+		cfg               Config
+		wantFail          bool
+		expectedSendEvery time.Duration
+		expectedMaxDelay  time.Duration
+	}{
+		"empty config": {
+			wantFail: true,
+		},
+		"defaults": {
+			cfg:               Config{DNS: dnsCfg},
+			expectedSendEvery: defaultSendInterval,
+			expectedMaxDelay:  defaultMaxDelay,
+		},
+		"send interval and max delay": {
+			cfg:               Config{DNS: dnsCfg, SendInterval: time.Second, SendOnSync: true, MaxDelay: time.Minute},
+			expectedSendEvery: time.Second,
+			expectedMaxDelay:  time.Minute,
+		},
+		"negative send interval": {
+			cfg:      Config{DNS: dnsCfg, SendInterval: -time.Second},
+			wantFail: true,
+		},
+		"negative max delay": {
+			cfg:      Config{DNS: dnsCfg, SendOnSync: true, MaxDelay: -time.Second},
+			wantFail: true,
+		},
+		"max delay without send on sync": {
+			cfg:      Config{DNS: dnsCfg, MaxDelay: time.Minute},
+			wantFail: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			mgr, err := New(test.cfg)
 
+			if test.wantFail {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, test.expectedSendEvery, mgr.sendEvery)
+				assert.Equal(t, test.expectedMaxDelay, mgr.maxDelay)
+				assert.Equal(t, test.cfg.SendOnSync, mgr.sendOnSync)
+			}
+		})
+	}
 }
 
 func TestManager_Discover(t *testing.T) {
@@ -86,6 +139,146 @@ func TestManager_Discover(t *testing.T) {
 	}
 }
 
+func TestManager_Discover_SendOnSync(t *testing.T) {
+	tests := map[string]struct {
+		discoverers []discoverer
+		maxDelay    time.Duration
+		minWait     time.Duration
+		maxWait     time.Duration
+	}{
+		"discoverers are synced": {
+			discoverers: []discoverer{
+				prepareSyncingDiscoverer("test1", 200*time.Millisecond),
+				prepareSyncingDiscoverer("test2", 400*time.Millisecond),
+			},
+			maxDelay: time.Minute,
+			minWait:  400 * time.Millisecond,
+			maxWait:  time.Second,
+		},
+		"discoverer that doesn't report health": {
+			discoverers: []discoverer{
+				prepareMockDiscoverer("test1", 2, 1),
+			},
+			maxDelay: time.Minute,
+			maxWait:  time.Second,
+		},
+		"discoverer that doesn't report health is synced after its first groups": {
+			discoverers: []discoverer{
+				prepareSyncingDiscoverer("test1", 200*time.Millisecond),
+				prepareDelayedDiscoverer("test2", 400*time.Millisecond),
+			},
+			maxDelay: time.Minute,
+			minWait:  400 * time.Millisecond,
+			maxWait:  time.Second,
+		},
+		"discoverer that doesn't report health sends no groups in max delay": {
+			discoverers: []discoverer{
+				prepareSyncingDiscoverer("test1", 200*time.Millisecond),
+				prepareDelayedDiscoverer("test2", time.Minute),
+			},
+			maxDelay: 500 * time.Millisecond,
+			minWait:  500 * time.Millisecond,
+			maxWait:  1500 * time.Millisecond,
+		},
+		"discoverer is not synced in max delay": {
+			discoverers: []discoverer{
+				prepareSyncingDiscoverer("test1", 200*time.Millisecond),
+				prepareSyncingDiscoverer("test2", time.Minute),
+			},
+			maxDelay: 500 * time.Millisecond,
+			minWait:  500 * time.Millisecond,
+			maxWait:  1500 * time.Millisecond,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			mgr := prepareManager(test.discoverers...)
+			mgr.sendEvery = time.Minute
+			mgr.sendOnSync = true
+			mgr.maxDelay = test.maxDelay
+
+			var expected []model.Group
+			for _, d := range test.discoverers {
+				switch v := d.(type) {
+				case mockDiscoverer:
+					expected = append(expected, v.groups...)
+				case *mockSyncingDiscoverer:
+					// groups of the discoverers that are not synced in time come with the next send
+					if v.syncAfter < test.maxDelay {
+						expected = append(expected, v.groups...)
+					}
+				case mockDelayedDiscoverer:
+					if v.delays[0] < test.maxDelay {
+						expected = append(expected, v.groups...)
+					}
+				}
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			in := make(chan []model.Group)
+			start := time.Now()
+			go mgr.Discover(ctx, in)
+
+			select {
+			case groups := <-in:
+				elapsed := time.Since(start)
+				assert.GreaterOrEqual(t, elapsed, test.minWait)
+				assert.Less(t, elapsed, test.maxWait)
+				sortGroups(expected)
+				sortGroups(groups)
+				assert.Equal(t, expected, groups)
+			case <-time.After(test.maxWait):
+				t.Fatalf("the first snapshot is not sent in %s", test.maxWait)
+			}
+		})
+	}
+}
+
+func TestManager_Discover_SendMaxDelay(t *testing.T) {
+	d := prepareDelayedDiscoverer("test1", 0, 300*time.Millisecond)
+	mgr := prepareManager(d)
+	mgr.sendEvery = time.Minute
+	mgr.sendOnSync = true
+	mgr.maxDelay = 500 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	in := make(chan []model.Group)
+	start := time.Now()
+	go mgr.Discover(ctx, in)
+
+	// the first snapshot is sent on sync, the update is sent after the max delay instead of the send interval
+	for i, minWait := range []time.Duration{0, 800 * time.Millisecond} {
+		select {
+		case groups := <-in:
+			assert.GreaterOrEqualf(t, time.Since(start), minWait, "snapshot #%d", i)
+			assert.Equalf(t, d.groups, groups, "snapshot #%d", i)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("snapshot #%d is not sent in time", i)
+		}
+	}
+}
+
+func TestManager_Health(t *testing.T) {
+	d1 := mockHealthDiscoverer{statuses: []health.Status{{Name: "test1", State: health.StateSynced}}}
+	d2 := prepareMockDiscoverer("test2", 1, 1)
+	d3 := mockHealthDiscoverer{statuses: []health.Status{
+		{Name: "test3", State: health.StateFailed, LastError: "timed out"},
+		{Name: "test4", State: health.StateStarting},
+	}}
+	mgr := prepareManager(d1, d2, d3)
+
+	expected := []health.Status{
+		{Name: "test1", State: health.StateSynced},
+		{Name: "test3", State: health.StateFailed, LastError: "timed out"},
+		{Name: "test4", State: health.StateStarting},
+	}
+	assert.Equal(t, expected, mgr.Health())
+	assert.Nil(t, prepareManager(d2).Health())
+}
+
 func prepareMockDiscoverer(source string, groups, targets int) mockDiscoverer {
 	d := mockDiscoverer{}
 
@@ -127,6 +320,68 @@ func (md mockDiscoverer) Discover(ctx context.Context, out chan<- []model.Group)
 	}
 }
 
+func prepareSyncingDiscoverer(source string, syncAfter time.Duration) *mockSyncingDiscoverer {
+	return &mockSyncingDiscoverer{
+		mockDiscoverer: prepareMockDiscoverer(source, 1, 1),
+		syncAfter:      syncAfter,
+		health:         health.NewReporter("mock"),
+	}
+}
+
+// mockSyncingDiscoverer sends its groups after a delay and reports synced once they are received.
+type mockSyncingDiscoverer struct {
+	mockDiscoverer
+	syncAfter time.Duration
+	health    *health.Reporter
+}
+
+func (md *mockSyncingDiscoverer) Discover(ctx context.Context, out chan<- []model.Group) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(md.syncAfter):
+	}
+	md.mockDiscoverer.Discover(ctx, out)
+	md.health.Synced()
+}
+
+func (md *mockSyncingDiscoverer) Health() []health.Status { return []health.Status{md.health.Status()} }
+
+func (md *mockSyncingDiscoverer) OnHealthChange(fn func()) { md.health.OnChange(fn) }
+
+func prepareDelayedDiscoverer(source string, delays ...time.Duration) mockDelayedDiscoverer {
+	return mockDelayedDiscoverer{
+		mockDiscoverer: prepareMockDiscoverer(source, 1, 1),
+		delays:         delays,
+	}
+}
+
+// mockDelayedDiscoverer sends its groups after every delay, it doesn't report its health.
+type mockDelayedDiscoverer struct {
+	mockDiscoverer
+	delays []time.Duration
+}
+
+func (md mockDelayedDiscoverer) Discover(ctx context.Context, out chan<- []model.Group) {
+	for _, delay := range md.delays {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		md.mockDiscoverer.Discover(ctx, out)
+	}
+}
+
+type mockHealthDiscoverer struct {
+	mockDiscoverer
+	statuses []health.Status
+}
+
+func (md mockHealthDiscoverer) Health() []health.Status { return md.statuses }
+
+func (md mockHealthDiscoverer) OnHealthChange(func()) {}
+
 type mockGroup struct {
 	targets []model.Target
 	source  string
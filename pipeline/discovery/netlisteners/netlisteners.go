@@ -0,0 +1,224 @@
+package netlisteners
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/netdata/sd/pipeline/model"
+	"github.com/netdata/sd/pkg/log"
+
+	"github.com/ilyam8/hashstructure"
+	"github.com/rs/zerolog"
+)
+
+const (
+	defaultProcRoot = "/proc"
+	defaultInterval = time.Second * 10
+)
+
+type Config struct {
+	Tags     string        `yaml:"tags"`
+	ProcRoot string        `yaml:"proc_root"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+func validateConfig(cfg Config) error {
+	if cfg.Tags == "" {
+		return errors.New("no tags set")
+	}
+	if cfg.Interval < 0 {
+		return errors.New("negative interval")
+	}
+	return nil
+}
+
+type (
+	listenersGroup struct {
+		targets []model.Target
+		source  string
+	}
+	ListenerTarget struct {
+		model.Base `hash:"ignore"`
+		hash       uint64
+		tuid       string
+		Address    string
+
+		Protocol  string
+		IPAddress string
+		Port      string
+		Inode     string
+		UID       string
+
+		PID     string
+		Comm    string
+		Cmdline string
+		Exe     string
+	}
+)
+
+func (lt ListenerTarget) Hash() uint64 { return lt.hash }
+func (lt ListenerTarget) TUID() string { return lt.tuid }
+
+func (lg listenersGroup) Source() string          { return lg.source }
+func (lg listenersGroup) Targets() []model.Target { return lg.targets }
+
+type Discovery struct {
+	tags     model.Tags
+	procRoot string
+	source   string
+	interval time.Duration
+	lastHash uint64
+	sent     bool
+	log      zerolog.Logger
+}
+
+func NewDiscovery(cfg Config) (*Discovery, error) {
+	if err := validateConfig(cfg); err != nil {
+		return nil, fmt.Errorf("net_listeners discovery config validation: %v", err)
+	}
+
+	tags, err := model.ParseTags(cfg.Tags)
+	if err != nil {
+		return nil, fmt.Errorf("net_listeners discovery initialization: parse config->tags: %v", err)
+	}
+
+	d := &Discovery{
+		tags:     tags,
+		procRoot: cfg.ProcRoot,
+		interval: cfg.Interval,
+		log:      log.New("net_listeners discovery"),
+	}
+	if d.procRoot == "" {
+		d.procRoot = defaultProcRoot
+	}
+	if d.interval == 0 {
+		d.interval = defaultInterval
+	}
+	d.source = listenersSource(d.procRoot)
+	return d, nil
+}
+
+func (d *Discovery) String() string {
+	return "net_listeners discovery"
+}
+
+func (d *Discovery) Discover(ctx context.Context, in chan<- []model.Group) {
+	d.log.Info().Msg("instance is started")
+	defer d.log.Info().Msg("instance is stopped")
+
+	tk := time.NewTicker(d.interval)
+	defer tk.Stop()
+
+	for {
+		d.refresh(ctx, in)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-tk.C:
+		}
+	}
+}
+
+func (d *Discovery) refresh(ctx context.Context, in chan<- []model.Group) {
+	group, err := d.discover()
+	if err != nil {
+		d.log.Error().Err(err).Msg("failed to discover listeners")
+		return
+	}
+
+	var hash uint64
+	for _, tgt := range group.Targets() {
+		hash = hash*31 + tgt.Hash()
+	}
+	if d.sent && hash == d.lastHash {
+		return
+	}
+	d.lastHash, d.sent = hash, true
+
+	select {
+	case <-ctx.Done():
+	case in <- []model.Group{group}:
+	}
+}
+
+func (d *Discovery) discover() (model.Group, error) {
+	sockets, err := readListeningSockets(d.procRoot)
+	if err != nil {
+		return nil, err
+	}
+	procs := readSocketProcesses(d.procRoot)
+
+	sort.Slice(sockets, func(i, j int) bool {
+		if sockets[i].protocol != sockets[j].protocol {
+			return sockets[i].protocol < sockets[j].protocol
+		}
+		if sockets[i].port != sockets[j].port {
+			return sockets[i].port < sockets[j].port
+		}
+		return sockets[i].ip < sockets[j].ip
+	})
+
+	seen := make(map[string]bool)
+	var targets []model.Target
+
+	for _, sock := range sockets {
+		target := &ListenerTarget{
+			tuid:      fmt.Sprintf("%s_%s_%d", sock.protocol, sock.ip, sock.port),
+			Address:   net.JoinHostPort(connectableIP(sock.ip), strconv.Itoa(sock.port)),
+			Protocol:  sock.protocol,
+			IPAddress: sock.ip,
+			Port:      strconv.Itoa(sock.port),
+			Inode:     sock.inode,
+			UID:       sock.uid,
+		}
+		if seen[target.tuid] {
+			continue
+		}
+		seen[target.tuid] = true
+
+		if proc, ok := procs[sock.inode]; ok {
+			target.PID = proc.pid
+			target.Comm = proc.comm
+			target.Cmdline = proc.cmdline
+			target.Exe = proc.exe
+		}
+
+		if d.finalize(target) {
+			targets = append(targets, target)
+		}
+	}
+
+	return &listenersGroup{source: d.source, targets: targets}, nil
+}
+
+// listenersSource returns the group source, instances reading different procfs send different groups.
+func listenersSource(procRoot string) string {
+	return "net_listeners/" + procRoot
+}
+
+func (d *Discovery) finalize(target *ListenerTarget) bool {
+	hash, err := hashstructure.Hash(target, nil)
+	if err != nil {
+		return false
+	}
+	target.hash = hash
+	target.Tags().Merge(d.tags)
+	return true
+}
+
+// connectableIP replaces a wildcard address with the loopback address of the same family.
+func connectableIP(ip string) string {
+	switch ip {
+	case "0.0.0.0":
+		return "127.0.0.1"
+	case "::":
+		return "::1"
+	}
+	return ip
+}
@@ -0,0 +1,154 @@
+package netlisteners
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/netdata/sd/pipeline/model"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testProcRoot = "testdata/proc"
+
+func TestNewDiscovery(t *testing.T) {
+	tests := map[string]struct {
+		cfg     Config
+		wantErr bool
+	}{
+		"defaults":          {cfg: Config{Tags: "net"}},
+		"custom proc root":  {cfg: Config{Tags: "net", ProcRoot: testProcRoot, Interval: time.Second}},
+		"no tags":           {wantErr: true, cfg: Config{}},
+		"bad tags":          {wantErr: true, cfg: Config{Tags: "!"}},
+		"negative interval": {wantErr: true, cfg: Config{Tags: "net", Interval: -time.Second}},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			d, err := NewDiscovery(test.cfg)
+
+			if test.wantErr {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.NotNil(t, d)
+			}
+		})
+	}
+}
+
+func TestDiscovery_String(t *testing.T) {
+	var d Discovery
+	assert.NotEmpty(t, d.String())
+}
+
+func TestDiscovery_Discover(t *testing.T) {
+	tests := map[string]struct {
+		procRoot       string
+		expectedGroups []model.Group
+	}{
+		"fixture procfs": {
+			procRoot: testProcRoot,
+			expectedGroups: []model.Group{
+				&listenersGroup{
+					source: "net_listeners/" + testProcRoot,
+					targets: []model.Target{
+						prepareTarget("tcp", "0.0.0.0", "22", "1001", "0",
+							"100", "sshd", "/usr/sbin/sshd -D", "/usr/sbin/sshd"),
+						prepareTarget("tcp", "127.0.0.1", "5432", "1002", "999",
+							"200", "postgres", "/usr/lib/postgresql/15/bin/postgres -D /var/lib/postgresql/15/main", "/usr/lib/postgresql/15/bin/postgres"),
+						prepareTarget("tcp6", "::", "80", "1003", "0",
+							"300", "nginx", "nginx: master process /usr/sbin/nginx", "/usr/sbin/nginx"),
+						prepareTarget("tcp6", "::1", "8080", "1005", "1000",
+							"400", "app", "/opt/app/bin/app --listen [::1]:8080", "/opt/app/bin/app"),
+						prepareTarget("udp", "0.0.0.0", "53", "1004", "101",
+							"", "", "", ""),
+					},
+				},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			d, err := NewDiscovery(Config{Tags: "net", ProcRoot: test.procRoot, Interval: time.Hour})
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+
+			in := make(chan []model.Group)
+			go d.Discover(ctx, in)
+
+			select {
+			case groups := <-in:
+				assert.Equal(t, test.expectedGroups, groups)
+			case <-ctx.Done():
+				t.Fatal("timeout waiting for groups")
+			}
+		})
+	}
+}
+
+func TestDiscovery_Discover_NoProcfs(t *testing.T) {
+	d, err := NewDiscovery(Config{Tags: "net", ProcRoot: t.TempDir()})
+	require.NoError(t, err)
+
+	_, err = d.discover()
+	assert.Error(t, err)
+}
+
+func TestParseHexAddress(t *testing.T) {
+	tests := map[string]struct {
+		input    string
+		wantIP   string
+		wantPort int
+		wantErr  bool
+	}{
+		"ipv4 any":       {input: "00000000:0016", wantIP: "0.0.0.0", wantPort: 22},
+		"ipv4 loopback":  {input: "0100007F:1538", wantIP: "127.0.0.1", wantPort: 5432},
+		"ipv4 private":   {input: "0F02000A:9C40", wantIP: "10.0.2.15", wantPort: 40000},
+		"ipv6 any":       {input: "00000000000000000000000000000000:0050", wantIP: "::", wantPort: 80},
+		"ipv6 loopback":  {input: "00000000000000000000000001000000:1F90", wantIP: "::1", wantPort: 8080},
+		"no port":        {input: "00000000", wantErr: true},
+		"bad hex":        {input: "0000000Z:0016", wantErr: true},
+		"bad ip length":  {input: "000000:0016", wantErr: true},
+		"port too large": {input: "00000000:FFFFF", wantErr: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			ip, port, err := parseHexAddress(test.input)
+
+			if test.wantErr {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, test.wantIP, ip)
+				assert.Equal(t, test.wantPort, port)
+			}
+		})
+	}
+}
+
+func prepareTarget(proto, ip, port, inode, uid, pid, comm, cmdline, exe string) model.Target {
+	target := &ListenerTarget{
+		tuid:      proto + "_" + ip + "_" + port,
+		Address:   net.JoinHostPort(connectableIP(ip), port),
+		Protocol:  proto,
+		IPAddress: ip,
+		Port:      port,
+		Inode:     inode,
+		UID:       uid,
+		PID:       pid,
+		Comm:      comm,
+		Cmdline:   cmdline,
+		Exe:       exe,
+	}
+	d := Discovery{tags: model.Tags{"net": {}}}
+	d.finalize(target)
+	return target
+}
@@ -0,0 +1,184 @@
+package netlisteners
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	tcpStateListen = "0A"
+	udpStateClose  = "07"
+)
+
+type (
+	socket struct {
+		protocol string
+		ip       string
+		port     int
+		uid      string
+		inode    string
+	}
+	process struct {
+		pid     string
+		comm    string
+		cmdline string
+		exe     string
+	}
+)
+
+// readListeningSockets returns TCP sockets in the LISTEN state and unconnected UDP sockets.
+func readListeningSockets(procRoot string) ([]socket, error) {
+	var sockets []socket
+	var found bool
+
+	for _, proto := range []string{"tcp", "tcp6", "udp", "udp6"} {
+		v, err := readNetFile(filepath.Join(procRoot, "net", proto), proto)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		found = true
+		sockets = append(sockets, v...)
+	}
+	if !found {
+		return nil, fmt.Errorf("no net files found in '%s'", filepath.Join(procRoot, "net"))
+	}
+	return sockets, nil
+}
+
+func readNetFile(path, proto string) ([]socket, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	listenState := tcpStateListen
+	if strings.HasPrefix(proto, "udp") {
+		listenState = udpStateClose
+	}
+
+	var sockets []socket
+	sc := bufio.NewScanner(f)
+	for first := true; sc.Scan(); first = false {
+		if first {
+			// header
+			continue
+		}
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode ...
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 10 || fields[3] != listenState {
+			continue
+		}
+		ip, port, err := parseHexAddress(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("parse '%s' local address '%s': %v", path, fields[1], err)
+		}
+		if strings.HasPrefix(proto, "udp") {
+			// skip connected UDP sockets
+			if _, remPort, err := parseHexAddress(fields[2]); err != nil || remPort != 0 {
+				continue
+			}
+		}
+		sockets = append(sockets, socket{
+			protocol: proto,
+			ip:       ip,
+			port:     port,
+			uid:      fields[7],
+			inode:    fields[9],
+		})
+	}
+	return sockets, sc.Err()
+}
+
+// parseHexAddress parses 'IP:PORT' in the /proc/net format. The IP address is printed
+// as a sequence of 32-bit words in host byte order (little-endian is assumed).
+func parseHexAddress(s string) (string, int, error) {
+	idx := strings.IndexByte(s, ':')
+	if idx == -1 {
+		return "", 0, fmt.Errorf("bad format")
+	}
+	bs, err := hex.DecodeString(s[:idx])
+	if err != nil {
+		return "", 0, err
+	}
+	if len(bs) != net.IPv4len && len(bs) != net.IPv6len {
+		return "", 0, fmt.Errorf("bad ip length %d", len(bs))
+	}
+	for i := 0; i < len(bs); i += 4 {
+		bs[i], bs[i+1], bs[i+2], bs[i+3] = bs[i+3], bs[i+2], bs[i+1], bs[i]
+	}
+	port, err := strconv.ParseUint(s[idx+1:], 16, 16)
+	if err != nil {
+		return "", 0, err
+	}
+	return net.IP(bs).String(), int(port), nil
+}
+
+// readSocketProcesses maps socket inodes to the processes that own them. Processes that can't be read
+// (e.g. insufficient permissions) are skipped. If several processes share a socket the lowest pid wins.
+func readSocketProcesses(procRoot string) map[string]process {
+	procs := make(map[string]process)
+
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		return procs
+	}
+
+	for _, entry := range entries {
+		pid := entry.Name()
+		if _, err := strconv.Atoi(pid); err != nil {
+			continue
+		}
+		fds, err := os.ReadDir(filepath.Join(procRoot, pid, "fd"))
+		if err != nil {
+			continue
+		}
+
+		var proc *process
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(procRoot, pid, "fd", fd.Name()))
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+			inode := strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")
+			if v, ok := procs[inode]; ok && lessPID(v.pid, pid) {
+				continue
+			}
+			if proc == nil {
+				proc = readProcess(procRoot, pid)
+			}
+			procs[inode] = *proc
+		}
+	}
+	return procs
+}
+
+func readProcess(procRoot, pid string) *process {
+	proc := &process{pid: pid}
+	if bs, err := os.ReadFile(filepath.Join(procRoot, pid, "comm")); err == nil {
+		proc.comm = strings.TrimSpace(string(bs))
+	}
+	if bs, err := os.ReadFile(filepath.Join(procRoot, pid, "cmdline")); err == nil {
+		proc.cmdline = string(bytes.TrimSpace(bytes.ReplaceAll(bytes.TrimRight(bs, "\x00"), []byte{0}, []byte{' '})))
+	}
+	if v, err := os.Readlink(filepath.Join(procRoot, pid, "exe")); err == nil {
+		proc.exe = v
+	}
+	return proc
+}
+
+func lessPID(a, b string) bool {
+	x, _ := strconv.Atoi(a)
+	y, _ := strconv.Atoi(b)
+	return x < y
+}
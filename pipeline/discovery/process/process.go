@@ -0,0 +1,281 @@
+package process
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/netdata/sd/pipeline/model"
+	"github.com/netdata/sd/pkg/log"
+
+	"github.com/gobwas/glob"
+	"github.com/ilyam8/hashstructure"
+	"github.com/rs/zerolog"
+)
+
+const (
+	defaultProcRoot = "/proc"
+	defaultInterval = time.Second * 30
+)
+
+type Config struct {
+	Tags     string        `yaml:"tags"`
+	ProcRoot string        `yaml:"proc_root"`
+	Interval time.Duration `yaml:"interval"`
+	Include  []string      `yaml:"include"`
+	Exclude  []string      `yaml:"exclude"`
+}
+
+func validateConfig(cfg Config) error {
+	if cfg.Tags == "" {
+		return errors.New("no tags set")
+	}
+	if cfg.Interval < 0 {
+		return errors.New("negative interval")
+	}
+	return nil
+}
+
+type (
+	processGroup struct {
+		targets []model.Target
+		source  string
+	}
+	ProcessTarget struct {
+		model.Base `hash:"ignore"`
+		hash       uint64
+		tuid       string
+		Address    string
+
+		PID         string
+		PPID        string
+		Comm        string
+		Exe         string
+		Cmdline     string
+		Cgroup      string
+		ContainerID string
+		UID         string
+		User        string
+	}
+)
+
+func (pt ProcessTarget) Hash() uint64 { return pt.hash }
+func (pt ProcessTarget) TUID() string { return pt.tuid }
+
+func (pg processGroup) Source() string          { return pg.source }
+func (pg processGroup) Targets() []model.Target { return pg.targets }
+
+type Discovery struct {
+	tags       model.Tags
+	procRoot   string
+	interval   time.Duration
+	include    []glob.Glob
+	exclude    []glob.Glob
+	lookupUser func(uid string) string
+	source     string
+	lastHash   uint64
+	sent       bool
+	log        zerolog.Logger
+}
+
+func NewDiscovery(cfg Config) (*Discovery, error) {
+	if err := validateConfig(cfg); err != nil {
+		return nil, fmt.Errorf("process discovery config validation: %v", err)
+	}
+
+	d, err := initDiscovery(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("process discovery initialization: %v", err)
+	}
+	return d, nil
+}
+
+func initDiscovery(cfg Config) (*Discovery, error) {
+	tags, err := model.ParseTags(cfg.Tags)
+	if err != nil {
+		return nil, fmt.Errorf("parse config->tags: %v", err)
+	}
+	include, err := compileGlobs(cfg.Include)
+	if err != nil {
+		return nil, fmt.Errorf("parse config->include: %v", err)
+	}
+	exclude, err := compileGlobs(cfg.Exclude)
+	if err != nil {
+		return nil, fmt.Errorf("parse config->exclude: %v", err)
+	}
+
+	d := &Discovery{
+		tags:       tags,
+		procRoot:   cfg.ProcRoot,
+		interval:   cfg.Interval,
+		include:    include,
+		exclude:    exclude,
+		lookupUser: newUserLookup(),
+		log:        log.New("process discovery"),
+	}
+	if d.procRoot == "" {
+		d.procRoot = defaultProcRoot
+	}
+	if d.interval == 0 {
+		d.interval = defaultInterval
+	}
+	d.source = processSource(d.procRoot, cfg.Include, cfg.Exclude)
+	return d, nil
+}
+
+func (d *Discovery) String() string {
+	return "process discovery"
+}
+
+func (d *Discovery) Discover(ctx context.Context, in chan<- []model.Group) {
+	d.log.Info().Msg("instance is started")
+	defer d.log.Info().Msg("instance is stopped")
+
+	tk := time.NewTicker(d.interval)
+	defer tk.Stop()
+
+	for {
+		d.refresh(ctx, in)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-tk.C:
+		}
+	}
+}
+
+func (d *Discovery) refresh(ctx context.Context, in chan<- []model.Group) {
+	group, err := d.discover()
+	if err != nil {
+		d.log.Error().Err(err).Msg("failed to discover processes")
+		return
+	}
+
+	var hash uint64
+	for _, tgt := range group.Targets() {
+		hash = hash*31 + tgt.Hash()
+	}
+	if d.sent && hash == d.lastHash {
+		return
+	}
+	d.lastHash, d.sent = hash, true
+
+	select {
+	case <-ctx.Done():
+	case in <- []model.Group{group}:
+	}
+}
+
+func (d *Discovery) discover() (model.Group, error) {
+	procs, err := readProcesses(d.procRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(procs, func(i, j int) bool { return procs[i].pid < procs[j].pid })
+
+	group := &processGroup{source: d.source}
+	for _, proc := range procs {
+		if !d.matches(proc) {
+			continue
+		}
+
+		pid := strconv.Itoa(proc.pid)
+		target := &ProcessTarget{
+			tuid:        proc.comm + "_" + pid,
+			PID:         pid,
+			PPID:        proc.ppid,
+			Comm:        proc.comm,
+			Exe:         proc.exe,
+			Cmdline:     proc.cmdline,
+			Cgroup:      proc.cgroup,
+			ContainerID: containerID(proc.cgroup),
+			UID:         proc.uid,
+			User:        d.lookupUser(proc.uid),
+		}
+
+		if d.finalize(target) {
+			group.targets = append(group.targets, target)
+		}
+	}
+	return group, nil
+}
+
+// matches reports whether the process comm or exe matches any include pattern (if set) and none of exclude patterns.
+func (d *Discovery) matches(proc process) bool {
+	if len(d.include) > 0 && !matchAny(d.include, proc.comm, proc.exe) {
+		return false
+	}
+	return !matchAny(d.exclude, proc.comm, proc.exe)
+}
+
+func (d *Discovery) finalize(target *ProcessTarget) bool {
+	hash, err := hashstructure.Hash(target, nil)
+	if err != nil {
+		return false
+	}
+	target.hash = hash
+	target.Tags().Merge(d.tags)
+	return true
+}
+
+func matchAny(globs []glob.Glob, values ...string) bool {
+	for _, g := range globs {
+		for _, v := range values {
+			if v != "" && g.Match(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func compileGlobs(patterns []string) ([]glob.Glob, error) {
+	var globs []glob.Glob
+	for _, pattern := range patterns {
+		g, err := glob.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("bad pattern '%s': %v", pattern, err)
+		}
+		globs = append(globs, g)
+	}
+	return globs, nil
+}
+
+// newUserLookup returns a function that resolves user names by their IDs, results are cached.
+func newUserLookup() func(uid string) string {
+	cache := make(map[string]string)
+	return func(uid string) string {
+		if uid == "" {
+			return ""
+		}
+		if name, ok := cache[uid]; ok {
+			return name
+		}
+		var name string
+		if u, err := user.LookupId(uid); err == nil {
+			name = u.Username
+		}
+		cache[uid] = name
+		return name
+	}
+}
+
+// processSource returns the group source, instances reading different procfs or using different filters send
+// different groups.
+func processSource(procRoot string, include, exclude []string) string {
+	source := "process/" + procRoot
+	if len(include) > 0 {
+		source += "/include:" + strings.Join(include, ",")
+	}
+	if len(exclude) > 0 {
+		source += "/exclude:" + strings.Join(exclude, ",")
+	}
+	return source
+}
@@ -0,0 +1,218 @@
+package process
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/netdata/sd/pipeline/model"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testProcRoot = "testdata/proc"
+
+	nginxContainerID  = "3f4b1c9a8e2d7f6051a2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5e6f7a8"
+	workerContainerID = "9e8d7c6b5a4f3e2d1c0b9a8f7e6d5c4b3a291807f6e5d4c3b2a190817263544b"
+)
+
+func TestNewDiscovery(t *testing.T) {
+	tests := map[string]struct {
+		cfg     Config
+		wantErr bool
+	}{
+		"defaults":          {cfg: Config{Tags: "proc"}},
+		"include exclude":   {cfg: Config{Tags: "proc", Include: []string{"nginx*", "/usr/bin/*"}, Exclude: []string{"bash"}}},
+		"no tags":           {wantErr: true, cfg: Config{}},
+		"bad tags":          {wantErr: true, cfg: Config{Tags: "!"}},
+		"negative interval": {wantErr: true, cfg: Config{Tags: "proc", Interval: -time.Second}},
+		"bad include":       {wantErr: true, cfg: Config{Tags: "proc", Include: []string{"[nginx"}}},
+		"bad exclude":       {wantErr: true, cfg: Config{Tags: "proc", Exclude: []string{"[nginx"}}},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			d, err := NewDiscovery(test.cfg)
+
+			if test.wantErr {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.NotNil(t, d)
+			}
+		})
+	}
+}
+
+func TestDiscovery_String(t *testing.T) {
+	var d Discovery
+	assert.NotEmpty(t, d.String())
+}
+
+func TestDiscovery_Discover(t *testing.T) {
+	tests := map[string]struct {
+		cfg            Config
+		expectedGroups []model.Group
+	}{
+		"all processes": {
+			cfg: Config{},
+			expectedGroups: []model.Group{
+				prepareGroup("process/"+testProcRoot,
+					prepareSystemdTarget(), prepareNginxTarget(), prepareWorkerTarget(), prepareBashTarget()),
+			},
+		},
+		"include comm and exe": {
+			cfg: Config{Include: []string{"nginx", "/usr/bin/python*"}},
+			expectedGroups: []model.Group{
+				prepareGroup("process/"+testProcRoot+"/include:nginx,/usr/bin/python*",
+					prepareNginxTarget(), prepareWorkerTarget()),
+			},
+		},
+		"exclude": {
+			cfg: Config{Exclude: []string{"systemd", "/usr/bin/*"}},
+			expectedGroups: []model.Group{
+				prepareGroup("process/"+testProcRoot+"/exclude:systemd,/usr/bin/*", prepareNginxTarget()),
+			},
+		},
+		"include and exclude": {
+			cfg: Config{Include: []string{"/usr/*"}, Exclude: []string{"bash"}},
+			expectedGroups: []model.Group{
+				prepareGroup("process/"+testProcRoot+"/include:/usr/*/exclude:bash",
+					prepareSystemdTarget(), prepareNginxTarget(), prepareWorkerTarget()),
+			},
+		},
+		"nothing matches": {
+			cfg: Config{Include: []string{"postgres"}},
+			expectedGroups: []model.Group{
+				prepareGroup("process/" + testProcRoot + "/include:postgres"),
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := test.cfg
+			cfg.Tags = "proc"
+			cfg.ProcRoot = testProcRoot
+			cfg.Interval = time.Hour
+			d, err := NewDiscovery(cfg)
+			require.NoError(t, err)
+			d.lookupUser = fakeUserLookup
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+
+			in := make(chan []model.Group)
+			go d.Discover(ctx, in)
+
+			select {
+			case groups := <-in:
+				assert.Equal(t, test.expectedGroups, groups)
+			case <-ctx.Done():
+				t.Fatal("timeout waiting for groups")
+			}
+		})
+	}
+}
+
+func TestDiscovery_Discover_NoProcfs(t *testing.T) {
+	d, err := NewDiscovery(Config{Tags: "proc", ProcRoot: "testdata/not_exists"})
+	require.NoError(t, err)
+
+	_, err = d.discover()
+	assert.Error(t, err)
+}
+
+func TestContainerID(t *testing.T) {
+	tests := map[string]struct {
+		cgroup   string
+		expected string
+	}{
+		"docker cgroup v1":     {cgroup: "/docker/" + nginxContainerID, expected: nginxContainerID},
+		"docker systemd scope": {cgroup: "/system.slice/docker-" + nginxContainerID + ".scope", expected: nginxContainerID},
+		"cri-o":                {cgroup: "/kubepods.slice/kubepods-pod1.slice/crio-" + nginxContainerID + ".scope", expected: nginxContainerID},
+		"containerd":           {cgroup: "/kubepods/burstable/pod1/cri-containerd-" + workerContainerID + ".scope", expected: workerContainerID},
+		"not a container":      {cgroup: "/user.slice/user-1000.slice/session-1.scope"},
+		"empty":                {},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.expected, containerID(test.cgroup))
+		})
+	}
+}
+
+func fakeUserLookup(uid string) string {
+	return map[string]string{"0": "root", "101": "nginx", "1000": "worker"}[uid]
+}
+
+func prepareGroup(source string, targets ...model.Target) model.Group {
+	return &processGroup{source: source, targets: targets}
+}
+
+func prepareSystemdTarget() model.Target {
+	return withHashAndTags(&ProcessTarget{
+		tuid:    "systemd_1",
+		PID:     "1",
+		PPID:    "0",
+		Comm:    "systemd",
+		Exe:     "/usr/lib/systemd/systemd",
+		Cmdline: "/sbin/init splash",
+		Cgroup:  "/init.scope",
+		UID:     "0",
+		User:    "root",
+	})
+}
+
+func prepareNginxTarget() model.Target {
+	return withHashAndTags(&ProcessTarget{
+		tuid:        "nginx_100",
+		PID:         "100",
+		PPID:        "1",
+		Comm:        "nginx",
+		Exe:         "/usr/sbin/nginx",
+		Cmdline:     "nginx: master process nginx -g daemon off;",
+		Cgroup:      "/system.slice/docker-" + nginxContainerID + ".scope",
+		ContainerID: nginxContainerID,
+		UID:         "101",
+		User:        "nginx",
+	})
+}
+
+func prepareWorkerTarget() model.Target {
+	return withHashAndTags(&ProcessTarget{
+		tuid:        "python3_200",
+		PID:         "200",
+		PPID:        "1",
+		Comm:        "python3",
+		Exe:         "/usr/bin/python3.11",
+		Cmdline:     "python3 worker.py --queue default",
+		Cgroup:      "/kubepods/burstable/pod1234/" + workerContainerID,
+		ContainerID: workerContainerID,
+		UID:         "1000",
+		User:        "worker",
+	})
+}
+
+func prepareBashTarget() model.Target {
+	return withHashAndTags(&ProcessTarget{
+		tuid:    "bash_300",
+		PID:     "300",
+		PPID:    "1",
+		Comm:    "bash",
+		Exe:     "/usr/bin/bash",
+		Cmdline: "-bash",
+		Cgroup:  "/user.slice/user-1000.slice/session-1.scope",
+		UID:     "1000",
+		User:    "worker",
+	})
+}
+
+func withHashAndTags(target *ProcessTarget) *ProcessTarget {
+	d := Discovery{tags: model.Tags{"proc": {}}}
+	d.finalize(target)
+	return target
+}
@@ -0,0 +1,145 @@
+package process
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type process struct {
+	pid     int
+	ppid    string
+	comm    string
+	exe     string
+	cmdline string
+	cgroup  string
+	uid     string
+}
+
+// readProcesses reads all user space processes. Kernel threads (empty cmdline) and processes
+// that exit while reading are skipped.
+func readProcesses(procRoot string) ([]process, error) {
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	var procs []process
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		proc, ok := readProcess(filepath.Join(procRoot, entry.Name()))
+		if !ok {
+			continue
+		}
+		proc.pid = pid
+		procs = append(procs, proc)
+	}
+	return procs, nil
+}
+
+func readProcess(dir string) (process, bool) {
+	var proc process
+
+	bs, err := os.ReadFile(filepath.Join(dir, "cmdline"))
+	if err != nil || len(bs) == 0 {
+		return proc, false
+	}
+	proc.cmdline = string(bytes.TrimSpace(bytes.ReplaceAll(bytes.TrimRight(bs, "\x00"), []byte{0}, []byte{' '})))
+
+	if bs, err := os.ReadFile(filepath.Join(dir, "comm")); err == nil {
+		proc.comm = strings.TrimSpace(string(bs))
+	}
+	if v, err := os.Readlink(filepath.Join(dir, "exe")); err == nil {
+		proc.exe = v
+	}
+	proc.ppid, proc.uid = readStatus(filepath.Join(dir, "status"))
+	proc.cgroup = readCgroup(filepath.Join(dir, "cgroup"))
+
+	return proc, true
+}
+
+// readStatus returns the parent pid and the real user ID of the process.
+func readStatus(path string) (ppid, uid string) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", ""
+	}
+	defer func() { _ = f.Close() }()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		key, value, ok := strings.Cut(sc.Text(), ":")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "PPid":
+			ppid = strings.TrimSpace(value)
+		case "Uid":
+			// real, effective, saved set, and filesystem UIDs
+			if fields := strings.Fields(value); len(fields) > 0 {
+				uid = fields[0]
+			}
+		}
+	}
+	return ppid, uid
+}
+
+// readCgroup returns the cgroup v2 (unified hierarchy) path of the process. For cgroup v1 (or hybrid
+// mode with the process in the root v2 cgroup) it returns the path of the 'name=systemd' hierarchy,
+// or the first one if it is missing.
+func readCgroup(path string) string {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+
+	var unified, systemd, first string
+	for _, line := range strings.Split(strings.TrimSpace(string(bs)), "\n") {
+		// hierarchy-ID:controller-list:cgroup-path
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		switch {
+		case parts[0] == "0" && parts[1] == "":
+			unified = parts[2]
+		case parts[1] == "name=systemd":
+			systemd = parts[2]
+		case first == "":
+			first = parts[2]
+		}
+	}
+	if unified != "" && unified != "/" {
+		return unified
+	}
+	return firstNotEmpty(systemd, first, unified)
+}
+
+func firstNotEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// reContainerID matches 64 hex characters container IDs used by docker, containerd and cri-o, e.g.
+// '/docker/<id>', '/system.slice/docker-<id>.scope', '/kubepods/.../cri-containerd-<id>.scope'.
+var reContainerID = regexp.MustCompile(`[0-9a-f]{64}`)
+
+func containerID(cgroup string) string {
+	ids := reContainerID.FindAllString(cgroup, -1)
+	if len(ids) == 0 {
+		return ""
+	}
+	return ids[len(ids)-1]
+}
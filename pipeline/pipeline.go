@@ -5,6 +5,7 @@ import (
 	"sync"
 
 	"github.com/netdata/sd/pipeline/model"
+	"github.com/netdata/sd/pkg/health"
 	"github.com/netdata/sd/pkg/log"
 
 	"github.com/rs/zerolog"
@@ -26,6 +27,10 @@ type Exporter interface {
 	Export(ctx context.Context, out <-chan []model.Config)
 }
 
+type healthReporter interface {
+	Health() []health.Status
+}
+
 type (
 	Pipeline struct {
 		Discoverer
@@ -51,6 +56,14 @@ func New(discoverer Discoverer, tagger Tagger, builder Builder, exporter Exporte
 	}
 }
 
+// Health returns the statuses of the discoverers, it is empty if the discoverer doesn't report its health.
+func (p *Pipeline) Health() []health.Status {
+	if v, ok := p.Discoverer.(healthReporter); ok {
+		return v.Health()
+	}
+	return nil
+}
+
 func (p *Pipeline) Run(ctx context.Context) {
 	p.log.Info().Msg("instance is started")
 	defer p.log.Info().Msg("instance is stopped")
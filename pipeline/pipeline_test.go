@@ -5,8 +5,10 @@ import (
 	"testing"
 
 	"github.com/netdata/sd/pipeline/model"
+	"github.com/netdata/sd/pkg/health"
 
 	"github.com/ilyam8/hashstructure"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestPipeline_Run(t *testing.T) {
@@ -122,6 +124,23 @@ type (
 	}
 )
 
+func TestPipeline_Health(t *testing.T) {
+	statuses := []health.Status{{Name: "test", State: health.StateSynced}}
+
+	p := New(mockDiscoverer{}, &mockTagger{}, &mockBuilder{}, &mockExporter{})
+	assert.Nil(t, p.Health())
+
+	p = New(mockHealthDiscoverer{statuses: statuses}, &mockTagger{}, &mockBuilder{}, &mockExporter{})
+	assert.Equal(t, statuses, p.Health())
+}
+
+type mockHealthDiscoverer struct {
+	mockDiscoverer
+	statuses []health.Status
+}
+
+func (d mockHealthDiscoverer) Health() []health.Status { return d.statuses }
+
 func (d mockDiscoverer) Discover(ctx context.Context, in chan<- []model.Group) {
 	select {
 	case <-ctx.Done():
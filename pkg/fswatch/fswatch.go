@@ -0,0 +1,198 @@
+package fswatch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog"
+)
+
+// RefreshFunc handles a refresh: changed are the new and modified files, removed are the files that are gone.
+type RefreshFunc func(ctx context.Context, changed, removed []string)
+
+// Watcher tracks regular files matching glob patterns. It rescans them on fsnotify events
+// of their directories and periodically, and reports the files whose modification time changed.
+type Watcher struct {
+	// Lstat makes the watcher not follow symlinks, a symlink is not a regular file.
+	Lstat        bool
+	RefreshEvery time.Duration
+
+	paths   []string
+	watcher *fsnotify.Watcher
+	cache   cache
+	log     zerolog.Logger
+}
+
+type cache map[string]time.Time
+
+func (c cache) lookup(path string) (time.Time, bool) { v, ok := c[path]; return v, ok }
+func (c cache) has(path string) bool                 { _, ok := c.lookup(path); return ok }
+func (c cache) remove(path string)                   { delete(c, path) }
+func (c cache) put(path string, modTime time.Time)   { c[path] = modTime }
+
+func New(paths []string, log zerolog.Logger) *Watcher {
+	return &Watcher{
+		RefreshEvery: time.Minute,
+		paths:        paths,
+		cache:        make(cache),
+		log:          log,
+	}
+}
+
+// Run refreshes the files until ctx is done. The first refresh reports all existing files as changed.
+func (w *Watcher) Run(ctx context.Context, refresh RefreshFunc) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		w.log.Error().Err(err).Msg("failed to initialize fsnotify watcher")
+		return
+	}
+
+	w.watcher = watcher
+	defer w.stop()
+	w.refresh(ctx, refresh)
+
+	tk := time.NewTicker(w.RefreshEvery)
+	defer tk.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tk.C:
+			w.refresh(ctx, refresh)
+		case event := <-w.watcher.Events:
+			if event.Name == "" || isChmod(event) || !w.fileMatches(event.Name) {
+				break
+			}
+			if isCreate(event) && w.cache.has(event.Name) {
+				// vim "backupcopy=no" case, already collected after Rename event.
+				break
+			}
+			if isRename(event) {
+				// It is common to modify files using vim.
+				// When writing to a file a backup is made. "backupcopy" option tells how it's done.
+				// Default is "no": rename the file and write a new one.
+				// This is cheap attempt to not report the file being rewritten as removed.
+				time.Sleep(time.Millisecond * 100)
+			}
+			w.refresh(ctx, refresh)
+		case err := <-w.watcher.Errors:
+			if err != nil {
+				w.log.Warn().Err(err).Msg("watch error event")
+			}
+		}
+	}
+}
+
+func (w *Watcher) refresh(ctx context.Context, refresh RefreshFunc) {
+	select {
+	case <-ctx.Done():
+		return
+	default:
+	}
+
+	stat := os.Stat
+	if w.Lstat {
+		stat = os.Lstat
+	}
+
+	var changed, removed []string
+	seen := make(map[string]bool)
+
+	for _, file := range w.listFiles() {
+		fi, err := stat(file)
+		if err != nil {
+			w.log.Warn().Err(err).Msgf("failed to stat '%s'", file)
+			continue
+		}
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+
+		seen[file] = true
+		if v, ok := w.cache.lookup(file); ok && v.Equal(fi.ModTime()) {
+			continue
+		}
+		w.cache.put(file, fi.ModTime())
+		changed = append(changed, file)
+	}
+
+	for name := range w.cache {
+		if seen[name] {
+			continue
+		}
+		w.cache.remove(name)
+		removed = append(removed, name)
+	}
+
+	if len(changed) > 0 || len(removed) > 0 {
+		refresh(ctx, changed, removed)
+	}
+	w.watchDirs()
+}
+
+func (w *Watcher) fileMatches(file string) bool {
+	for _, pattern := range w.paths {
+		if ok, _ := filepath.Match(pattern, file); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *Watcher) listFiles() (files []string) {
+	for _, pattern := range w.paths {
+		if matches, err := filepath.Glob(pattern); err == nil {
+			files = append(files, matches...)
+		}
+	}
+	return files
+}
+
+func (w *Watcher) watchDirs() {
+	for _, path := range w.paths {
+		if idx := strings.LastIndex(path, "/"); idx > -1 {
+			path = path[:idx]
+		} else {
+			path = "./"
+		}
+		if err := w.watcher.Add(path); err != nil {
+			w.log.Warn().Err(err).Msgf("failed to start watching '%s'", path)
+		}
+	}
+}
+
+func (w *Watcher) stop() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// closing the watcher deadlocks unless all events and errors are drained.
+	go func() {
+		for {
+			select {
+			case <-w.watcher.Errors:
+			case <-w.watcher.Events:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	_ = w.watcher.Close()
+}
+
+func isChmod(event fsnotify.Event) bool {
+	return event.Op^fsnotify.Chmod == 0
+}
+
+func isRename(event fsnotify.Event) bool {
+	return event.Op&fsnotify.Rename == fsnotify.Rename
+}
+
+func isCreate(event fsnotify.Event) bool {
+	return event.Op&fsnotify.Create == fsnotify.Create
+}
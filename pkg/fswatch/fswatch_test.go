@@ -0,0 +1,108 @@
+package fswatch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/netdata/sd/pkg/log"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type refresh struct {
+	changed []string
+	removed []string
+}
+
+func TestWatcher_Run(t *testing.T) {
+	tests := map[string]struct {
+		files      map[string]string
+		afterStart func(dir string)
+		expected   func(dir string) []refresh
+	}{
+		"existing files": {
+			files: map[string]string{"a.yaml": "a", "b.yaml": "b", "c.txt": "c"},
+			expected: func(dir string) []refresh {
+				return []refresh{
+					{changed: []string{filepath.Join(dir, "a.yaml"), filepath.Join(dir, "b.yaml")}},
+				}
+			},
+		},
+		"file modified": {
+			files: map[string]string{"a.yaml": "a"},
+			afterStart: func(dir string) {
+				_ = os.WriteFile(filepath.Join(dir, "a.yaml"), []byte("aa"), 0644)
+			},
+			expected: func(dir string) []refresh {
+				return []refresh{
+					{changed: []string{filepath.Join(dir, "a.yaml")}},
+					{changed: []string{filepath.Join(dir, "a.yaml")}},
+				}
+			},
+		},
+		"file removed": {
+			files: map[string]string{"a.yaml": "a"},
+			afterStart: func(dir string) {
+				_ = os.Remove(filepath.Join(dir, "a.yaml"))
+			},
+			expected: func(dir string) []refresh {
+				return []refresh{
+					{changed: []string{filepath.Join(dir, "a.yaml")}},
+					{removed: []string{filepath.Join(dir, "a.yaml")}},
+				}
+			},
+		},
+		"symlink with lstat": {
+			files: map[string]string{"a.yaml": "a"},
+			afterStart: func(dir string) {
+				_ = os.Symlink(filepath.Join(dir, "a.yaml"), filepath.Join(dir, "b.yaml"))
+				_ = os.WriteFile(filepath.Join(dir, "c.yaml"), []byte("c"), 0644)
+			},
+			expected: func(dir string) []refresh {
+				return []refresh{
+					{changed: []string{filepath.Join(dir, "a.yaml")}},
+					{changed: []string{filepath.Join(dir, "c.yaml")}},
+				}
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range test.files {
+				require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+			}
+
+			w := New([]string{filepath.Join(dir, "*.yaml")}, log.New("fswatch test"))
+			w.Lstat = true
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+			defer cancel()
+
+			ch := make(chan refresh)
+			go w.Run(ctx, func(ctx context.Context, changed, removed []string) {
+				select {
+				case <-ctx.Done():
+				case ch <- refresh{changed: changed, removed: removed}:
+				}
+			})
+
+			for i, expected := range test.expected(dir) {
+				select {
+				case actual := <-ch:
+					assert.Equal(t, expected, actual)
+				case <-ctx.Done():
+					t.Fatalf("timeout waiting for refresh #%d", i)
+				}
+				if i == 0 && test.afterStart != nil {
+					test.afterStart(dir)
+				}
+			}
+		})
+	}
+}
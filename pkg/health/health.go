@@ -0,0 +1,127 @@
+package health
+
+import (
+	"sync"
+	"time"
+)
+
+// State is the state of a component that depends on an external system (e.g. an informer cache).
+type State string
+
+const (
+	StateStarting State = "starting"
+	StateSynced   State = "synced"
+	StateFailed   State = "failed"
+)
+
+// Status is a point-in-time health report of a component.
+type Status struct {
+	Name  string
+	State State
+	// LastError is the most recent error, it is kept after the component recovers.
+	LastError string
+	// Since is the time the component entered the state.
+	Since time.Time
+}
+
+// Reporter tracks the health of a component, it is safe for concurrent use.
+type Reporter struct {
+	mu       sync.Mutex
+	status   Status
+	onChange []func()
+}
+
+func NewReporter(name string) *Reporter {
+	return &Reporter{status: Status{Name: name, State: StateStarting, Since: time.Now()}}
+}
+
+func (r *Reporter) Starting() { r.set(StateStarting, nil) }
+
+func (r *Reporter) Synced() { r.set(StateSynced, nil) }
+
+func (r *Reporter) Failed(err error) { r.set(StateFailed, err) }
+
+func (r *Reporter) Status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status
+}
+
+// OnChange registers a function that is called after every state change, it must not block.
+func (r *Reporter) OnChange(fn func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onChange = append(r.onChange, fn)
+}
+
+func (r *Reporter) set(state State, err error) {
+	r.mu.Lock()
+
+	if err != nil {
+		r.status.LastError = err.Error()
+	}
+	if r.status.State == state {
+		r.mu.Unlock()
+		return
+	}
+	r.status.State = state
+	r.status.Since = time.Now()
+	onChange := r.onChange
+	r.mu.Unlock()
+
+	for _, fn := range onChange {
+		fn()
+	}
+}
+
+// Synced returns true if all the statuses are in the synced state.
+func Synced(statuses []Status) bool {
+	for _, s := range statuses {
+		if s.State != StateSynced {
+			return false
+		}
+	}
+	return true
+}
+
+// SyncWaiter waits for a set of components to sync. Notify is registered as their change callback, the goroutine
+// that waits receives from Changed and calls Check.
+type SyncWaiter struct {
+	statuses func() []Status
+	changed  chan struct{}
+	synced   bool
+}
+
+// NewSyncWaiter returns a waiter for the components whose statuses are returned by the function. A change is
+// pending initially, so the components that are synced before the callbacks are registered are not missed.
+func NewSyncWaiter(statuses func() []Status) *SyncWaiter {
+	w := &SyncWaiter{statuses: statuses, changed: make(chan struct{}, 1)}
+	w.Notify()
+	return w
+}
+
+// Notify signals a state change, it never blocks. Changes that are not received yet are coalesced.
+func (w *SyncWaiter) Notify() {
+	select {
+	case w.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Changed returns the channel the changes are signaled on. It returns nil for a nil waiter and once the components
+// are synced, so it can be used in a select unconditionally.
+func (w *SyncWaiter) Changed() <-chan struct{} {
+	if w == nil || w.synced {
+		return nil
+	}
+	return w.changed
+}
+
+// Check returns true if all the components have just become synced, it returns true only once.
+func (w *SyncWaiter) Check() bool {
+	if w.synced || !Synced(w.statuses()) {
+		return false
+	}
+	w.synced = true
+	return true
+}
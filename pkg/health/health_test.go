@@ -0,0 +1,99 @@
+package health
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReporter(t *testing.T) {
+	r := NewReporter("test")
+
+	status := r.Status()
+	assert.Equal(t, "test", status.Name)
+	assert.Equal(t, StateStarting, status.State)
+	assert.Empty(t, status.LastError)
+
+	r.Failed(errors.New("timed out"))
+	status = r.Status()
+	assert.Equal(t, StateFailed, status.State)
+	assert.Equal(t, "timed out", status.LastError)
+
+	since := status.Since
+	r.Failed(errors.New("timed out again"))
+	status = r.Status()
+	assert.Equal(t, "timed out again", status.LastError)
+	assert.Equal(t, since, status.Since, "same state must not reset the time")
+
+	r.Synced()
+	status = r.Status()
+	assert.Equal(t, StateSynced, status.State)
+	assert.Equal(t, "timed out again", status.LastError, "last error must be kept after recovery")
+}
+
+func TestReporter_OnChange(t *testing.T) {
+	r := NewReporter("test")
+	var changes int
+	r.OnChange(func() { changes++ })
+
+	r.Starting()
+	assert.Equal(t, 0, changes, "same state must not be signaled")
+	r.Failed(errors.New("timed out"))
+	r.Failed(errors.New("timed out again"))
+	assert.Equal(t, 1, changes)
+	r.Synced()
+	assert.Equal(t, 2, changes)
+}
+
+func TestSyncWaiter(t *testing.T) {
+	r1, r2 := NewReporter("test1"), NewReporter("test2")
+	w := NewSyncWaiter(func() []Status { return []Status{r1.Status(), r2.Status()} })
+	r1.OnChange(w.Notify)
+	r2.OnChange(w.Notify)
+
+	changed := func() bool {
+		select {
+		case <-w.Changed():
+			return true
+		default:
+			return false
+		}
+	}
+
+	require.True(t, changed(), "a change must be pending initially")
+	assert.False(t, w.Check())
+	assert.False(t, changed())
+
+	r1.Synced()
+	r2.Failed(errors.New("timed out"))
+	require.True(t, changed())
+	assert.False(t, changed(), "changes must be coalesced")
+	assert.False(t, w.Check())
+
+	r2.Synced()
+	require.True(t, changed())
+	assert.True(t, w.Check())
+	assert.False(t, w.Check(), "synced must be reported once")
+	assert.Nil(t, w.Changed())
+
+	var nilWaiter *SyncWaiter
+	assert.Nil(t, nilWaiter.Changed())
+}
+
+func TestSynced(t *testing.T) {
+	tests := map[string]struct {
+		statuses []Status
+		expected bool
+	}{
+		"no statuses":  {expected: true},
+		"all synced":   {statuses: []Status{{State: StateSynced}, {State: StateSynced}}, expected: true},
+		"one starting": {statuses: []Status{{State: StateSynced}, {State: StateStarting}}},
+		"one failed":   {statuses: []Status{{State: StateFailed}, {State: StateSynced}}},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) { assert.Equal(t, test.expected, Synced(test.statuses)) })
+	}
+}
@@ -1,10 +1,12 @@
 package k8s
 
 import (
-	"errors"
 	"os"
-	"path/filepath"
+	"sync"
 
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/rest"
@@ -15,47 +17,144 @@ import (
 
 const (
 	EnvFakeClient = "KUBERNETES_FAKE_CLIENTSET"
+	EnvKubeConfig = clientcmd.RecommendedConfigPathEnvVar
 )
 
-func Clientset() (kubernetes.Interface, error) {
-	switch {
-	case os.Getenv(EnvFakeClient) != "":
+const userAgent = "Netdata/auto-discovery"
+
+// ClientConfig selects the cluster and the credentials a client talks to.
+// Zero value means in-cluster config when running in a pod, otherwise
+// the KUBECONFIG env variable or '~/.kube/config' and its current context.
+type ClientConfig struct {
+	APIServer       string `yaml:"api_server"`
+	KubeConfig      string `yaml:"kubeconfig"`
+	Context         string `yaml:"context"`
+	BearerTokenFile string `yaml:"bearer_token_file"`
+	CAFile          string `yaml:"ca_file"`
+}
+
+var clientsets = struct {
+	sync.Mutex
+	cache map[ClientConfig]kubernetes.Interface
+}{cache: make(map[ClientConfig]kubernetes.Interface)}
+
+// Clientset returns a clientset for the config. Clientsets are cached, the same config always gets the same
+// clientset, so the informers from SharedInformers are shared by all its users.
+func Clientset(cfg ClientConfig) (kubernetes.Interface, error) {
+	if os.Getenv(EnvFakeClient) != "" {
 		return fake.NewSimpleClientset(), nil
-	case InCluster():
-		return clientsetInCluster()
-	default:
-		return clientsetOutOfCluster()
 	}
+
+	clientsets.Lock()
+	defer clientsets.Unlock()
+
+	if client, ok := clientsets.cache[cfg]; ok {
+		return client, nil
+	}
+	config, err := RESTConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	clientsets.cache[cfg] = client
+	return client, nil
 }
 
-func clientsetInCluster() (*kubernetes.Clientset, error) {
-	config, err := rest.InClusterConfig()
+var dynamicClients = struct {
+	sync.Mutex
+	cache map[ClientConfig]dynamic.Interface
+}{cache: make(map[ClientConfig]dynamic.Interface)}
+
+// DynamicClient returns a dynamic client for the config. Clients are cached the same way as clientsets.
+func DynamicClient(cfg ClientConfig) (dynamic.Interface, error) {
+	if os.Getenv(EnvFakeClient) != "" {
+		return dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()), nil
+	}
+
+	dynamicClients.Lock()
+	defer dynamicClients.Unlock()
+
+	if client, ok := dynamicClients.cache[cfg]; ok {
+		return client, nil
+	}
+	config, err := RESTConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	dynamicClients.cache[cfg] = client
+	return client, nil
+}
+
+func RESTConfig(cfg ClientConfig) (*rest.Config, error) {
+	var config *rest.Config
+	var err error
+
+	if useInClusterConfig(cfg) {
+		config, err = restConfigInCluster(cfg)
+	} else {
+		config, err = restConfigOutOfCluster(cfg)
+	}
 	if err != nil {
 		return nil, err
 	}
-	config.UserAgent = "Netdata/auto-discovery"
-	return kubernetes.NewForConfig(config)
+	config.UserAgent = userAgent
+	return config, nil
+}
+
+func useInClusterConfig(cfg ClientConfig) bool {
+	return InCluster() && cfg.KubeConfig == "" && cfg.Context == "" && os.Getenv(EnvKubeConfig) == ""
 }
 
-func clientsetOutOfCluster() (*kubernetes.Clientset, error) {
-	home := homeDir()
-	if home == "" {
-		return nil, errors.New("couldn't find home directory")
+func restConfigInCluster(cfg ClientConfig) (*rest.Config, error) {
+	if cfg.APIServer != "" {
+		// the service account token and the cluster CA are for the local API server, they must not be sent to another one
+		return &rest.Config{
+			Host:            cfg.APIServer,
+			BearerTokenFile: cfg.BearerTokenFile,
+			TLSClientConfig: rest.TLSClientConfig{CAFile: cfg.CAFile},
+		}, nil
 	}
-	configPath := filepath.Join(home, ".kube", "config")
-	config, err := clientcmd.BuildConfigFromFlags("", configPath)
+
+	config, err := rest.InClusterConfig()
 	if err != nil {
 		return nil, err
 	}
-	config.UserAgent = "Netdata/auto-discovery"
-	return kubernetes.NewForConfig(config)
+	if cfg.BearerTokenFile != "" {
+		config.BearerToken = ""
+		config.BearerTokenFile = cfg.BearerTokenFile
+	}
+	if cfg.CAFile != "" {
+		config.TLSClientConfig.CAFile = cfg.CAFile
+	}
+	return config, nil
 }
 
-func homeDir() string {
-	if h := os.Getenv("HOME"); h != "" {
-		return h
+func restConfigOutOfCluster(cfg ClientConfig) (*rest.Config, error) {
+	// default loading rules honor the KUBECONFIG env variable and fall back to '~/.kube/config'
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if cfg.KubeConfig != "" {
+		rules.ExplicitPath = cfg.KubeConfig
 	}
-	return os.Getenv("USERPROFILE") // windows
+
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: cfg.Context}
+	if cfg.APIServer != "" {
+		overrides.ClusterInfo.Server = cfg.APIServer
+	}
+	if cfg.CAFile != "" {
+		overrides.ClusterInfo.CertificateAuthority = cfg.CAFile
+	}
+	if cfg.BearerTokenFile != "" {
+		overrides.AuthInfo.TokenFile = cfg.BearerTokenFile
+	}
+
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
 }
 
 func InCluster() bool {
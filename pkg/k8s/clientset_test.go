@@ -0,0 +1,172 @@
+package k8s
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testKubeConfig = `
+apiVersion: v1
+kind: Config
+current-context: dev
+clusters:
+- name: dev
+  cluster:
+    server: https://dev.example.com:6443
+- name: prod
+  cluster:
+    server: https://prod.example.com:6443
+users:
+- name: admin
+  user:
+    token: secret
+contexts:
+- name: dev
+  context:
+    cluster: dev
+    user: admin
+- name: prod
+  context:
+    cluster: prod
+    user: admin
+`
+
+func TestRESTConfig(t *testing.T) {
+	dir := t.TempDir()
+	kubeconfig := filepath.Join(dir, "config")
+	require.NoError(t, os.WriteFile(kubeconfig, []byte(testKubeConfig), 0644))
+	tokenFile := filepath.Join(dir, "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("secret"), 0644))
+	caFile := filepath.Join(dir, "ca.crt")
+	require.NoError(t, os.WriteFile(caFile, []byte("ca"), 0644))
+
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	t.Setenv("KUBERNETES_SERVICE_PORT", "")
+
+	tests := map[string]struct {
+		cfg           ClientConfig
+		env           string
+		wantHost      string
+		wantTokenFile string
+		wantCAFile    string
+		wantErr       bool
+	}{
+		"kubeconfig current context": {
+			cfg:      ClientConfig{KubeConfig: kubeconfig},
+			wantHost: "https://dev.example.com:6443",
+		},
+		"kubeconfig explicit context": {
+			cfg:      ClientConfig{KubeConfig: kubeconfig, Context: "prod"},
+			wantHost: "https://prod.example.com:6443",
+		},
+		"kubeconfig from env": {
+			env:      kubeconfig,
+			cfg:      ClientConfig{Context: "prod"},
+			wantHost: "https://prod.example.com:6443",
+		},
+		"api server override": {
+			cfg:      ClientConfig{KubeConfig: kubeconfig, APIServer: "https://10.0.0.1:6443"},
+			wantHost: "https://10.0.0.1:6443",
+		},
+		"api server with token and ca files": {
+			env: filepath.Join(dir, "not-exists"),
+			cfg: ClientConfig{
+				APIServer:       "https://10.0.0.1:6443",
+				BearerTokenFile: tokenFile,
+				CAFile:          caFile,
+			},
+			wantHost:      "https://10.0.0.1:6443",
+			wantTokenFile: tokenFile,
+			wantCAFile:    caFile,
+		},
+		"explicit kubeconfig not exists": {
+			cfg:     ClientConfig{KubeConfig: filepath.Join(dir, "not-exists")},
+			wantErr: true,
+		},
+		"unknown context": {
+			cfg:     ClientConfig{KubeConfig: kubeconfig, Context: "staging"},
+			wantErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(EnvKubeConfig, test.env)
+
+			config, err := RESTConfig(test.cfg)
+
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.wantHost, config.Host)
+			assert.Equal(t, userAgent, config.UserAgent)
+			if test.wantTokenFile != "" {
+				assert.Equal(t, test.wantTokenFile, config.BearerTokenFile)
+			}
+			if test.wantCAFile != "" {
+				assert.Equal(t, test.wantCAFile, config.TLSClientConfig.CAFile)
+			}
+		})
+	}
+}
+
+func TestRESTConfig_InClusterAPIServer(t *testing.T) {
+	t.Setenv("KUBERNETES_SERVICE_HOST", "10.96.0.1")
+	t.Setenv("KUBERNETES_SERVICE_PORT", "443")
+	t.Setenv(EnvKubeConfig, "")
+
+	// the in-cluster service account credentials are not used with an explicit API server
+	config, err := RESTConfig(ClientConfig{APIServer: "https://10.0.0.1:6443"})
+	require.NoError(t, err)
+	assert.Equal(t, "https://10.0.0.1:6443", config.Host)
+	assert.Empty(t, config.BearerToken)
+	assert.Empty(t, config.BearerTokenFile)
+	assert.Empty(t, config.TLSClientConfig.CAFile)
+
+	config, err = RESTConfig(ClientConfig{APIServer: "https://10.0.0.1:6443", BearerTokenFile: "token", CAFile: "ca.crt"})
+	require.NoError(t, err)
+	assert.Equal(t, "token", config.BearerTokenFile)
+	assert.Equal(t, "ca.crt", config.TLSClientConfig.CAFile)
+}
+
+func TestClientset_Cache(t *testing.T) {
+	kubeconfig := filepath.Join(t.TempDir(), "config")
+	require.NoError(t, os.WriteFile(kubeconfig, []byte(testKubeConfig), 0644))
+	t.Setenv(EnvFakeClient, "")
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	t.Setenv("KUBERNETES_SERVICE_PORT", "")
+
+	dev, err := Clientset(ClientConfig{KubeConfig: kubeconfig})
+	require.NoError(t, err)
+	devAgain, err := Clientset(ClientConfig{KubeConfig: kubeconfig})
+	require.NoError(t, err)
+	prod, err := Clientset(ClientConfig{KubeConfig: kubeconfig, Context: "prod"})
+	require.NoError(t, err)
+
+	assert.True(t, dev == devAgain, "same config must return the same clientset")
+	assert.False(t, dev == prod, "different configs must return different clientsets")
+}
+
+func TestDynamicClient_Cache(t *testing.T) {
+	kubeconfig := filepath.Join(t.TempDir(), "config")
+	require.NoError(t, os.WriteFile(kubeconfig, []byte(testKubeConfig), 0644))
+	t.Setenv(EnvFakeClient, "")
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	t.Setenv("KUBERNETES_SERVICE_PORT", "")
+
+	dev, err := DynamicClient(ClientConfig{KubeConfig: kubeconfig})
+	require.NoError(t, err)
+	devAgain, err := DynamicClient(ClientConfig{KubeConfig: kubeconfig})
+	require.NoError(t, err)
+	prod, err := DynamicClient(ClientConfig{KubeConfig: kubeconfig, Context: "prod"})
+	require.NoError(t, err)
+
+	assert.True(t, dev == devAgain, "same config must return the same client")
+	assert.False(t, dev == prod, "different configs must return different clients")
+}
@@ -0,0 +1,321 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/tools/cache"
+)
+
+// SharedInformers is the process-wide informer registry. Discoverers and config providers watching
+// the same objects with the same client share a single watch and cache.
+var SharedInformers = NewInformers()
+
+// InformerKey identifies a shared informer. Clients that are pointers (clientsets are) are compared by address,
+// other comparable clients by value. Informers of non-comparable clients are not shared.
+type InformerKey struct {
+	Client        interface{}
+	Resource      string
+	Namespace     string
+	LabelSelector string
+	FieldSelector string
+}
+
+// Informer is a handle to a shared informer. Event handlers added using a handle are removed when it is closed.
+type Informer interface {
+	cache.SharedIndexInformer
+	// Close removes the handle event handlers and releases the shared informer, it is safe to call more than once.
+	Close()
+	// Restart recreates the shared informer unless it has synced, the event handlers of all its handles are
+	// moved to the new informer. The store and the indexer are replaced as well.
+	Restart()
+	// LastError returns the last list or watch error of the shared informer.
+	LastError() error
+}
+
+// NewInformerFunc creates an informer. The context is canceled when the informer is no longer used,
+// list and watch calls should use it.
+type NewInformerFunc func(ctx context.Context) cache.SharedIndexInformer
+
+var (
+	errNotSupported = errors.New("not supported by shared informer handles")
+	errClosed       = errors.New("shared informer handle is closed")
+)
+
+type (
+	Informers struct {
+		mu        sync.Mutex
+		informers map[registryKey]*sharedInformer
+	}
+	registryKey struct {
+		client                                            interface{}
+		resource, namespace, labelSelector, fieldSelector string
+	}
+	clientPointer struct {
+		typ reflect.Type
+		ptr uintptr
+	}
+	sharedInformer struct {
+		newInformer NewInformerFunc
+		informer    cache.SharedIndexInformer
+		ctx         context.Context
+		cancel      context.CancelFunc
+		handles     map[*informerHandle]bool
+		started     bool
+		lastErr     error
+	}
+)
+
+func NewInformers() *Informers {
+	return &Informers{informers: make(map[registryKey]*sharedInformer)}
+}
+
+// Get returns a handle to the informer registered for the key, the informer is created using newInformer if there
+// is none. The informer is started by the first running handle and stopped when all the handles are closed.
+// Run closes the handle on stop, a handle that is never run must be closed by its user.
+func (r *Informers) Get(key InformerKey, newInformer NewInformerFunc) Informer {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rkey := registryKey{
+		client:        clientID(key.Client),
+		resource:      key.Resource,
+		namespace:     key.Namespace,
+		labelSelector: key.LabelSelector,
+		fieldSelector: key.FieldSelector,
+	}
+
+	shared, ok := r.informers[rkey]
+	if !ok {
+		shared = &sharedInformer{newInformer: newInformer, handles: make(map[*informerHandle]bool)}
+		r.create(shared)
+		r.informers[rkey] = shared
+	}
+
+	h := &informerHandle{registry: r, key: rkey, shared: shared}
+	shared.handles[h] = true
+	return h
+}
+
+// create sets a new informer, its list and watch errors are recorded.
+func (r *Informers) create(shared *sharedInformer) {
+	ctx, cancel := context.WithCancel(context.Background())
+	inf := shared.newInformer(ctx)
+
+	_ = inf.SetWatchErrorHandler(func(rf *cache.Reflector, err error) {
+		r.mu.Lock()
+		if shared.informer == inf {
+			shared.lastErr = err
+		}
+		r.mu.Unlock()
+		cache.DefaultWatchErrorHandler(rf, err)
+	})
+
+	shared.informer, shared.ctx, shared.cancel = inf, ctx, cancel
+	shared.started = false
+}
+
+func (r *Informers) start(shared *sharedInformer) {
+	if !shared.started {
+		shared.started = true
+		go shared.informer.Run(shared.ctx.Done())
+	}
+}
+
+func (r *Informers) restart(shared *sharedInformer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// a synced informer may be in use, its users are not affected by the sync failures of the others
+	if len(shared.handles) == 0 || shared.informer.HasSynced() {
+		return
+	}
+
+	old, started := shared.informer, shared.started
+	shared.cancel()
+	r.create(shared)
+
+	for h := range shared.handles {
+		for _, hr := range h.handlers {
+			_ = old.RemoveEventHandler(hr.reg)
+			hr.reg, _ = hr.add(shared.informer)
+		}
+	}
+	if started {
+		r.start(shared)
+	}
+}
+
+func (r *Informers) release(h *informerHandle) {
+	shared := h.shared
+	delete(shared.handles, h)
+	if len(shared.handles) > 0 {
+		return
+	}
+	shared.cancel()
+	if r.informers[h.key] == shared {
+		delete(r.informers, h.key)
+	}
+}
+
+// clientID returns a comparable identity of the client.
+func clientID(client interface{}) interface{} {
+	if client == nil {
+		return nil
+	}
+	v := reflect.ValueOf(client)
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		return clientPointer{typ: v.Type(), ptr: v.Pointer()}
+	}
+	if v.Type().Comparable() {
+		return client
+	}
+	// a unique identity, the informer is not shared
+	return new(byte)
+}
+
+// handlerRegistration is an event handler added using a handle, it is added again when the informer is recreated.
+type handlerRegistration struct {
+	handler      cache.ResourceEventHandler
+	resyncPeriod *time.Duration
+	reg          cache.ResourceEventHandlerRegistration
+}
+
+func (hr *handlerRegistration) add(inf cache.SharedInformer) (cache.ResourceEventHandlerRegistration, error) {
+	if hr.resyncPeriod != nil {
+		return inf.AddEventHandlerWithResyncPeriod(hr.handler, *hr.resyncPeriod)
+	}
+	return inf.AddEventHandler(hr.handler)
+}
+
+// informerHandle delegates to the current informer of the shared informer, its state is guarded by the registry lock.
+type informerHandle struct {
+	registry *Informers
+	key      registryKey
+	shared   *sharedInformer
+	handlers []*handlerRegistration
+	closed   bool
+}
+
+func (h *informerHandle) informer() cache.SharedIndexInformer {
+	h.registry.mu.Lock()
+	defer h.registry.mu.Unlock()
+	return h.shared.informer
+}
+
+func (h *informerHandle) AddEventHandler(
+	handler cache.ResourceEventHandler,
+) (cache.ResourceEventHandlerRegistration, error) {
+	return h.addEventHandler(&handlerRegistration{handler: handler})
+}
+
+func (h *informerHandle) AddEventHandlerWithResyncPeriod(
+	handler cache.ResourceEventHandler,
+	resyncPeriod time.Duration,
+) (cache.ResourceEventHandlerRegistration, error) {
+	return h.addEventHandler(&handlerRegistration{handler: handler, resyncPeriod: &resyncPeriod})
+}
+
+func (h *informerHandle) addEventHandler(hr *handlerRegistration) (cache.ResourceEventHandlerRegistration, error) {
+	h.registry.mu.Lock()
+	defer h.registry.mu.Unlock()
+
+	if h.closed {
+		return nil, errClosed
+	}
+	reg, err := hr.add(h.shared.informer)
+	if err != nil {
+		return nil, err
+	}
+	hr.reg = reg
+	h.handlers = append(h.handlers, hr)
+	return reg, nil
+}
+
+func (h *informerHandle) RemoveEventHandler(reg cache.ResourceEventHandlerRegistration) error {
+	h.registry.mu.Lock()
+	defer h.registry.mu.Unlock()
+
+	for i, hr := range h.handlers {
+		if hr.reg == reg {
+			h.handlers = append(h.handlers[:i], h.handlers[i+1:]...)
+			break
+		}
+	}
+	return h.shared.informer.RemoveEventHandler(reg)
+}
+
+// HasSynced reports whether the handle event handlers have received the initial list of objects. A handle without
+// event handlers reports the shared informer state.
+func (h *informerHandle) HasSynced() bool {
+	h.registry.mu.Lock()
+	defer h.registry.mu.Unlock()
+
+	if len(h.handlers) == 0 {
+		return h.shared.informer.HasSynced()
+	}
+	for _, hr := range h.handlers {
+		if hr.reg == nil || !hr.reg.HasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+// Run starts the shared informer (if it is not started yet) and blocks until the stop channel is closed,
+// then it closes the handle.
+func (h *informerHandle) Run(stopCh <-chan struct{}) {
+	defer h.Close()
+
+	h.registry.mu.Lock()
+	if !h.closed {
+		h.registry.start(h.shared)
+	}
+	h.registry.mu.Unlock()
+
+	<-stopCh
+}
+
+func (h *informerHandle) Close() {
+	h.registry.mu.Lock()
+	defer h.registry.mu.Unlock()
+
+	if h.closed {
+		return
+	}
+	h.closed = true
+
+	for _, hr := range h.handlers {
+		_ = h.shared.informer.RemoveEventHandler(hr.reg)
+	}
+	h.handlers = nil
+
+	h.registry.release(h)
+}
+
+func (h *informerHandle) Restart() { h.registry.restart(h.shared) }
+
+func (h *informerHandle) LastError() error {
+	h.registry.mu.Lock()
+	defer h.registry.mu.Unlock()
+	return h.shared.lastErr
+}
+
+func (h *informerHandle) GetStore() cache.Store           { return h.informer().GetStore() }
+func (h *informerHandle) GetIndexer() cache.Indexer       { return h.informer().GetIndexer() }
+func (h *informerHandle) GetController() cache.Controller { return h.informer().GetController() }
+func (h *informerHandle) LastSyncResourceVersion() string {
+	return h.informer().LastSyncResourceVersion()
+}
+func (h *informerHandle) IsStopped() bool { return h.informer().IsStopped() }
+
+// AddIndexers, SetTransform and SetWatchErrorHandler are not supported: indexers and transforms are set by the informer
+// factory, so recreated informers get them as well, the watch error handler is set by the registry (see LastError).
+
+func (h *informerHandle) AddIndexers(cache.Indexers) error                   { return errNotSupported }
+func (h *informerHandle) SetTransform(cache.TransformFunc) error             { return errNotSupported }
+func (h *informerHandle) SetWatchErrorHandler(cache.WatchErrorHandler) error { return errNotSupported }
@@ -0,0 +1,217 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestInformers_Get(t *testing.T) {
+	client := fake.NewSimpleClientset(newPod("httpd"))
+	r := NewInformers()
+	var created int
+	newInformer := newPodInformerFunc(client, &created)
+
+	key := InformerKey{Client: client, Resource: "pods", Namespace: "default"}
+	inf1 := r.Get(key, newInformer)
+	inf2 := r.Get(key, newInformer)
+	inf3 := r.Get(InformerKey{Client: client, Resource: "pods", Namespace: "default", LabelSelector: "app=httpd"}, newInformer)
+	inf4 := r.Get(InformerKey{Client: fake.NewSimpleClientset(), Resource: "pods", Namespace: "default"}, newInformer)
+
+	assert.Equal(t, 3, created)
+	assert.True(t, inf1.GetStore() == inf2.GetStore(), "same key must share the informer")
+	assert.False(t, inf1.GetStore() == inf3.GetStore(), "different selectors must not share the informer")
+	assert.False(t, inf1.GetStore() == inf4.GetStore(), "different clients must not share the informer")
+	assert.Len(t, r.informers, 3)
+}
+
+func TestInformers_Get_NonComparableClient(t *testing.T) {
+	type client struct{ opts map[string]string }
+	r := NewInformers()
+	var created int
+	newInformer := newPodInformerFunc(fake.NewSimpleClientset(), &created)
+
+	assert.NotPanics(t, func() {
+		_ = r.Get(InformerKey{Client: client{}, Resource: "pods"}, newInformer)
+		_ = r.Get(InformerKey{Client: client{}, Resource: "pods"}, newInformer)
+	})
+	assert.Equal(t, 2, created, "non-comparable clients must not share the informer")
+}
+
+func TestInformers_Close(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	r := NewInformers()
+	var created int
+	key := InformerKey{Client: client, Resource: "pods", Namespace: "default"}
+
+	inf := r.Get(key, newPodInformerFunc(client, &created))
+	inf.Close()
+	inf.Close()
+	assert.Empty(t, r.informers, "a closed handle must release the informer")
+
+	// running a closed handle doesn't start the released informer
+	inf.Run(closedChan())
+	assert.Empty(t, r.informers)
+	assert.False(t, inf.HasSynced())
+
+	_, err := inf.AddEventHandler(podAddHandler(make(chan string)))
+	assert.Error(t, err)
+}
+
+func TestInformerHandle_HasSynced(t *testing.T) {
+	client := fake.NewSimpleClientset(newPod("httpd"))
+	r := NewInformers()
+	var created int
+	newInformer := newPodInformerFunc(client, &created)
+	key := InformerKey{Client: client, Resource: "pods", Namespace: "default"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	inf1 := r.Get(key, newInformer)
+	go inf1.Run(ctx.Done())
+	require.True(t, cache.WaitForCacheSync(ctx.Done(), inf1.HasSynced))
+
+	// the second handle joins the synced informer, it is synced once its handler receives the existing pods
+	inf2 := r.Get(key, newInformer)
+	release := make(chan struct{})
+	_, _ = inf2.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(interface{}) { <-release },
+	})
+	go inf2.Run(ctx.Done())
+
+	assert.Never(t, inf2.HasSynced, time.Millisecond*200, time.Millisecond*10)
+	close(release)
+	assert.Eventually(t, inf2.HasSynced, time.Second, time.Millisecond*10)
+}
+
+func TestInformerHandle_Restart(t *testing.T) {
+	r := NewInformers()
+	var created int
+	newInformer := newFailingPodInformerFunc(1, &created)
+	key := InformerKey{Resource: "pods", Namespace: "default"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	inf1, inf2 := r.Get(key, newInformer), r.Get(key, newInformer)
+	added := make(chan string, 10)
+	_, _ = inf1.AddEventHandler(podAddHandler(added))
+	go inf1.Run(ctx.Done())
+	go inf2.Run(ctx.Done())
+
+	require.Eventually(t, func() bool { return inf1.LastError() != nil }, time.Second*2, time.Millisecond*10)
+	assert.ErrorContains(t, inf2.LastError(), "pods is forbidden")
+	assert.False(t, inf1.HasSynced())
+
+	// the handlers are moved to the new informer, both handles use it
+	inf2.Restart()
+	assert.Equal(t, 2, created)
+	require.True(t, cache.WaitForCacheSync(ctx.Done(), inf1.HasSynced, inf2.HasSynced))
+	assert.Equal(t, "httpd", receive(t, added))
+	assert.True(t, inf1.GetStore() == inf2.GetStore())
+	assert.Len(t, inf1.GetStore().List(), 1)
+
+	// a synced informer is not recreated
+	inf1.Restart()
+	assert.Equal(t, 2, created)
+}
+
+func TestInformers_Lifecycle(t *testing.T) {
+	client := fake.NewSimpleClientset(newPod("httpd"))
+	r := NewInformers()
+	var created int
+	newInformer := newPodInformerFunc(client, &created)
+	key := InformerKey{Client: client, Resource: "pods", Namespace: "default"}
+
+	inf1, inf2 := r.Get(key, newInformer), r.Get(key, newInformer)
+	added1, added2 := make(chan string, 10), make(chan string, 10)
+	_, _ = inf1.AddEventHandler(podAddHandler(added1))
+	_, _ = inf2.AddEventHandler(podAddHandler(added2))
+
+	stop1, stop2 := make(chan struct{}), make(chan struct{})
+	done1, done2 := make(chan struct{}), make(chan struct{})
+	go func() { defer close(done1); inf1.Run(stop1) }()
+	go func() { defer close(done2); inf2.Run(stop2) }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	require.True(t, cache.WaitForCacheSync(ctx.Done(), inf1.HasSynced, inf2.HasSynced))
+	assert.Equal(t, "httpd", receive(t, added1))
+	assert.Equal(t, "httpd", receive(t, added2))
+
+	// the first user stops, the informer keeps running for the second one
+	close(stop1)
+	<-done1
+	assert.Len(t, r.informers, 1)
+
+	_, err := client.CoreV1().Pods("default").Create(ctx, newPod("nginx"), metav1.CreateOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "nginx", receive(t, added2))
+	select {
+	case name := <-added1:
+		t.Errorf("stopped handle event handler received '%s'", name)
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	// the last user stops, the informer is stopped and removed from the registry
+	close(stop2)
+	<-done2
+	assert.Empty(t, r.informers)
+	assert.Eventually(t, inf2.IsStopped, time.Second, time.Millisecond*10)
+
+	_ = r.Get(key, newInformer)
+	assert.Equal(t, 2, created)
+}
+
+func newPodInformerFunc(client kubernetes.Interface, created *int) NewInformerFunc {
+	return func(ctx context.Context) cache.SharedIndexInformer {
+		*created++
+		pod := client.CoreV1().Pods("default")
+		lw := &cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return pod.List(ctx, options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return pod.Watch(ctx, options)
+			},
+		}
+		return cache.NewSharedIndexInformer(lw, &apiv1.Pod{}, 0, cache.Indexers{})
+	}
+}
+
+func podAddHandler(ch chan string) cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) { ch <- obj.(*apiv1.Pod).Name },
+	}
+}
+
+func receive(t *testing.T, ch chan string) string {
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(time.Second * 2):
+		t.Fatal("timeout waiting for an event")
+		return ""
+	}
+}
+
+func closedChan() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}
+
+func newPod(name string) *apiv1.Pod {
+	return &apiv1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+}
@@ -0,0 +1,81 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/netdata/sd/pkg/health"
+
+	"github.com/rs/zerolog"
+	"k8s.io/client-go/tools/cache"
+)
+
+// SyncBackoff configures the cache sync attempts. An attempt waits up to Timeout for the caches to sync. After a
+// failed attempt the informers are recreated after a delay that starts at Initial and is doubled up to Max.
+type SyncBackoff struct {
+	Timeout time.Duration
+	Initial time.Duration
+	Max     time.Duration
+}
+
+var DefaultSyncBackoff = SyncBackoff{Timeout: 30 * time.Second, Initial: 5 * time.Second, Max: 5 * time.Minute}
+
+// WaitForCacheSync waits for the informers caches to sync. A timed out attempt is reported as failed with the last
+// list or watch error, then the informers that have not synced are recreated (only the shared informer handles
+// support it) and a new attempt is made. It returns false only if the context is canceled. Reporting the synced
+// state is up to the caller.
+func (b SyncBackoff) WaitForCacheSync(
+	ctx context.Context,
+	log zerolog.Logger,
+	rep *health.Reporter,
+	informers ...cache.SharedInformer,
+) bool {
+	synced := make([]cache.InformerSynced, 0, len(informers))
+	for _, inf := range informers {
+		synced = append(synced, inf.HasSynced)
+	}
+
+	delay := b.Initial
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, b.Timeout)
+		ok := cache.WaitForCacheSync(attemptCtx.Done(), synced...)
+		cancel()
+
+		if ok {
+			return true
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+
+		err := syncError(b.Timeout, attempt, informers)
+		log.Warn().Err(err).Msgf("failed to sync caches, restarting informers in %s", delay)
+		rep.Failed(err)
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(delay):
+		}
+
+		for _, inf := range informers {
+			if v, ok := inf.(Informer); ok {
+				v.Restart()
+			}
+		}
+
+		if delay *= 2; delay > b.Max {
+			delay = b.Max
+		}
+	}
+}
+
+func syncError(timeout time.Duration, attempt int, informers []cache.SharedInformer) error {
+	for _, inf := range informers {
+		if v, ok := inf.(Informer); ok && !inf.HasSynced() && v.LastError() != nil {
+			return fmt.Errorf("caches not synced after %s (attempt %d): %v", timeout, attempt, v.LastError())
+		}
+	}
+	return fmt.Errorf("caches not synced after %s (attempt %d)", timeout, attempt)
+}
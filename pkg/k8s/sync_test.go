@@ -0,0 +1,92 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/netdata/sd/pkg/health"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestSyncBackoff_WaitForCacheSync(t *testing.T) {
+	b := SyncBackoff{Timeout: 300 * time.Millisecond, Initial: 50 * time.Millisecond, Max: 100 * time.Millisecond}
+
+	t.Run("synced", func(t *testing.T) {
+		rep := health.NewReporter("test")
+		var created int
+		inf := NewInformers().Get(InformerKey{Resource: "pods"}, newFailingPodInformerFunc(0, &created))
+		defer inf.Close()
+		go inf.Run(context.Background().Done())
+
+		assert.True(t, b.WaitForCacheSync(context.Background(), zerolog.Nop(), rep, inf))
+		assert.Equal(t, health.StateStarting, rep.Status().State)
+		assert.Empty(t, rep.Status().LastError)
+		assert.Equal(t, 1, created)
+	})
+
+	t.Run("synced after the informer is recreated", func(t *testing.T) {
+		rep := health.NewReporter("test")
+		var created int
+		inf := NewInformers().Get(InformerKey{Resource: "pods"}, newFailingPodInformerFunc(1, &created))
+		defer inf.Close()
+		go inf.Run(context.Background().Done())
+
+		assert.True(t, b.WaitForCacheSync(context.Background(), zerolog.Nop(), rep, inf))
+		assert.Equal(t, health.StateFailed, rep.Status().State)
+		assert.Contains(t, rep.Status().LastError, "caches not synced after 300ms (attempt 1)")
+		assert.Contains(t, rep.Status().LastError, "pods is forbidden")
+		assert.Equal(t, 2, created)
+	})
+
+	t.Run("context canceled", func(t *testing.T) {
+		rep := health.NewReporter("test")
+		var created int
+		inf := NewInformers().Get(InformerKey{Resource: "pods"}, newFailingPodInformerFunc(100, &created))
+		defer inf.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go inf.Run(ctx.Done())
+		go func() {
+			for rep.Status().State != health.StateFailed {
+				time.Sleep(10 * time.Millisecond)
+			}
+			cancel()
+		}()
+
+		assert.False(t, b.WaitForCacheSync(ctx, zerolog.Nop(), rep, inf))
+		assert.Equal(t, health.StateFailed, rep.Status().State)
+	})
+}
+
+// newFailingPodInformerFunc creates pod informers, the list calls of the first 'failing' informers fail.
+func newFailingPodInformerFunc(failing int, created *int) NewInformerFunc {
+	client := fake.NewSimpleClientset(newPod("httpd"))
+	return func(ctx context.Context) cache.SharedIndexInformer {
+		*created++
+		fail := *created <= failing
+		pod := client.CoreV1().Pods("default")
+		lw := &cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				if fail {
+					return nil, errors.New("pods is forbidden")
+				}
+				return pod.List(ctx, options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return pod.Watch(ctx, options)
+			},
+		}
+		return cache.NewSharedIndexInformer(lw, &apiv1.Pod{}, 0, cache.Indexers{})
+	}
+}